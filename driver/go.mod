@@ -2,7 +2,10 @@ module github.com/farovictor/MongodbDriver
 
 go 1.20
 
-require go.mongodb.org/mongo-driver v1.12.1
+require (
+	go.mongodb.org/mongo-driver v1.12.1
+	golang.org/x/sync v0.1.0
+)
 
 require (
 	github.com/golang/snappy v0.0.3 // indirect
@@ -14,6 +17,5 @@ require (
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
 	golang.org/x/crypto v0.9.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 )
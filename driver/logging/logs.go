@@ -1,6 +1,7 @@
 package logging
 
 import (
+	"io"
 	"log"
 	"os"
 )
@@ -21,3 +22,10 @@ func init() {
 	ErrorLogger = log.New(os.Stdout, "ERROR: ", log.LstdFlags|log.Lshortfile)
 	DebugLogger = log.New(os.Stdout, "DEBUG: ", log.LstdFlags)
 }
+
+// Redirects every logger (e.g.: to stderr when data is streamed to stdout)
+func SetOutput(w io.Writer) {
+	for _, l := range []*log.Logger{InfoLogger, WarningLogger, ErrorLogger, DebugLogger} {
+		l.SetOutput(w)
+	}
+}
@@ -2,16 +2,25 @@ package mongo
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	logger "github.com/farovictor/MongodbDriver/logging"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"golang.org/x/sync/errgroup"
 )
 
+// Empty bson document (length + terminator)
+var emptyDocument = []byte{5, 0, 0, 0, 0}
+
 func Ping(connUri string) (bool, error) {
 
 	// Create a Client to a MongoDB server and use Ping to verify that the
@@ -78,7 +87,10 @@ func (m *ConnectionHandler) setDatabase(dbname string) {
 	m.database = m.client.Database(dbname)
 }
 
-func (m *ConnectionHandler) ExtractResults(mapping string, filePrefix string, fileLocation string, process func([]*bson.M, string, string, string) error, coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) error {
+// Reads the documents of a find and passes them to process in batches of batchSize documents, as they come
+// from the cursor, so memory does not grow with the number of documents. Process should append every batch
+// to the same output (it is not called when no document matches).
+func (m *ConnectionHandler) ExtractResults(mapping string, filePrefix string, fileLocation string, batchSize int32, process func([]*bson.M, string, string, string) error, coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) error {
 
 	// Find all documents in which the "name" field is "Bob".
 	// Specify the Sort option to sort the returned documents by age in
@@ -87,21 +99,32 @@ func (m *ConnectionHandler) ExtractResults(mapping string, filePrefix string, fi
 	// e.g.: filter := bson.D{{"name", "Bob"}}
 	ctx := context.TODO()
 	cursor, err := coll.Find(ctx, filter, opts...)
-	defer cursor.Close(ctx)
 	if err != nil {
-		logger.ErrorLogger.Fatal(err)
+		return err
 	}
+	defer cursor.Close(ctx)
 
-	// Get a list of all returned documents and print them out.
-	// See the mongo.Cursor documentation for more examples of using cursors.
-	var results []*bson.M
-	if err = cursor.All(ctx, &results); err != nil {
-		logger.ErrorLogger.Fatal(err)
+	results := make([]*bson.M, 0, batchSize)
+	for cursor.Next(ctx) {
+		var result bson.M
+		if err := cursor.Decode(&result); err != nil {
+			return err
+		}
+		results = append(results, &result)
+		if int32(len(results)) >= batchSize {
+			if err := process(results, mapping, filePrefix, fileLocation); err != nil {
+				return err
+			}
+			results = make([]*bson.M, 0, batchSize)
+		}
 	}
-
-	if err := process(results, mapping, filePrefix, fileLocation); err != nil {
+	if err := cursor.Err(); err != nil {
 		return err
 	}
+
+	if len(results) > 0 {
+		return process(results, mapping, filePrefix, fileLocation)
+	}
 	return nil
 }
 
@@ -134,42 +157,426 @@ func (m *ConnectionHandler) CollectionExists(collection string) bool {
 	return false
 }
 
+// Picks a random sample of documents matching filter (used to infer output schemas).
+// Projection (optional) is applied to sampled documents.
+func (m *ConnectionHandler) SampleDocuments(coll *mongo.Collection, filter interface{}, projection interface{}, size int32) ([]*bson.M, error) {
+	ctx := context.Background()
+
+	if filter == nil {
+		filter = bson.D{}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}},
+	}
+	if projection != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: projection}})
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []*bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Picks a random sample of documents returned by an aggregation pipeline (used to infer output schemas)
+func (m *ConnectionHandler) SamplePipeline(coll *mongo.Collection, pipeline []bson.D, size int32) ([]*bson.M, error) {
+	ctx := context.Background()
+
+	sampled := append(mongo.Pipeline{}, pipeline...)
+	sampled = append(sampled, bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}})
+
+	cursor, err := coll.Aggregate(ctx, sampled, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []*bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Returns the highest value of field (dotted path) among documents matching filter.
+// Found is false when no document holds the field.
+func (m *ConnectionHandler) MaxValue(coll *mongo.Collection, filter interface{}, field string) (interface{}, bool, error) {
+	ctx := context.Background()
+
+	if filter == nil {
+		filter = bson.D{}
+	}
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: field, Value: -1}}).
+		SetProjection(bson.D{{Key: field, Value: 1}})
+
+	raw, err := coll.FindOne(ctx, filter, opts).DecodeBytes()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Documents without the field come last in a descending sort
+	rawValue, err := raw.LookupErr(strings.Split(field, ".")...)
+	if err != nil {
+		return nil, false, nil
+	}
+	var value interface{}
+	if err = rawValue.Unmarshal(&value); err != nil {
+		return nil, false, err
+	}
+	return value, value != nil, nil
+}
+
+// Methods splitting a collection into ranges (PartitionBounds)
+const (
+	// Quantiles of a random sample of key values ($sample), fast but approximate
+	SplitSample = "sample"
+	// Buckets of even sizes ($bucketAuto), exact but reads every key value
+	SplitBucketAuto = "bucket-auto"
+	// Split points of the key index, by size (splitVector command, requires an index on key)
+	SplitVector = "split-vector"
+)
+
+// Number of key values sampled per range by SplitSample
+const samplesPerPartition = 100
+
+// Returns up to partitions-1 increasing values of key splitting documents matching filter into ranges of
+// similar sizes. Less bounds are returned when key holds too few distinct values.
+// Bounds all hold the same bson type (numbers are one type), since ranges only match values of their type.
+func (m *ConnectionHandler) PartitionBounds(coll *mongo.Collection, filter interface{}, key string, partitions int32, method string) ([]interface{}, error) {
+	ctx := context.Background()
+	if partitions < 2 {
+		return nil, nil
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	var values []bson.RawValue
+	switch method {
+	case SplitSample:
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$sample", Value: bson.D{{Key: "size", Value: partitions * samplesPerPartition}}}},
+			{{Key: "$project", Value: bson.D{{Key: "_id", Value: 0}, {Key: "value", Value: "$" + key}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "value", Value: 1}}}},
+		}
+		sampled, err := aggregateValues(ctx, coll, pipeline, "value")
+		if err != nil {
+			return nil, err
+		}
+		for i := int32(1); i < partitions && len(sampled) > 0; i++ {
+			values = append(values, sampled[int(i)*len(sampled)/int(partitions)])
+		}
+
+	case SplitBucketAuto:
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$bucketAuto", Value: bson.D{{Key: "groupBy", Value: "$" + key}, {Key: "buckets", Value: partitions}}}},
+			{{Key: "$project", Value: bson.D{{Key: "value", Value: "$_id.min"}}}},
+			{{Key: "$sort", Value: bson.D{{Key: "value", Value: 1}}}},
+		}
+		mins, err := aggregateValues(ctx, coll, pipeline, "value")
+		if err != nil {
+			return nil, err
+		}
+		// First bucket starts at the lowest value
+		if len(mins) > 0 {
+			values = mins[1:]
+		}
+
+	case SplitVector:
+		var stats struct {
+			StorageStats struct {
+				Size int64 `bson:"size"`
+			} `bson:"storageStats"`
+		}
+		cursor, err := coll.Aggregate(ctx, mongo.Pipeline{{{Key: "$collStats", Value: bson.D{{Key: "storageStats", Value: bson.D{}}}}}})
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Next(ctx) {
+			err = cursor.Decode(&stats)
+		}
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		command := bson.D{
+			{Key: "splitVector", Value: coll.Database().Name() + "." + coll.Name()},
+			{Key: "keyPattern", Value: bson.D{{Key: key, Value: 1}}},
+			{Key: "maxChunkSizeBytes", Value: stats.StorageStats.Size/int64(partitions) + 1},
+		}
+		var result struct {
+			SplitKeys []bson.Raw `bson:"splitKeys"`
+		}
+		if err = coll.Database().RunCommand(ctx, command).Decode(&result); err != nil {
+			return nil, err
+		}
+		for _, splitKey := range result.SplitKeys {
+			value, err := splitKey.LookupErr(strings.Split(key, ".")...)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		// Split points are computed by size, keeps partitions-1 evenly spread ones
+		if len(values) >= int(partitions) {
+			spread := make([]bson.RawValue, 0, partitions-1)
+			for i := int32(1); i < partitions; i++ {
+				spread = append(spread, values[int(i)*len(values)/int(partitions)])
+			}
+			values = spread
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown split method %s (%s, %s or %s)", method, SplitSample, SplitBucketAuto, SplitVector)
+	}
+
+	return distinctBounds(values, key)
+}
+
+// Values of field in the documents returned by pipeline
+func aggregateValues(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline, field string) ([]bson.RawValue, error) {
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var values []bson.RawValue
+	for cursor.Next(ctx) {
+		// Documents without key (or null) belong to the first range anyway
+		if value, err := cursor.Current.LookupErr(field); err == nil && value.Type != bsontype.Null && value.Type != bsontype.Undefined {
+			values = append(values, value)
+		}
+	}
+	return values, cursor.Err()
+}
+
+// Removes repeated bounds and checks every bound holds the same type
+func distinctBounds(values []bson.RawValue, key string) ([]interface{}, error) {
+	var bounds []interface{}
+	var previous bson.RawValue
+	for i, value := range values {
+		if typeBracket(value.Type) != typeBracket(values[0].Type) {
+			return nil, fmt.Errorf("%s holds values of several types (%s and %s), ranges cannot be computed", key, values[0].Type, value.Type)
+		}
+		if i > 0 && value.Equal(previous) {
+			continue
+		}
+		previous = value
+
+		var bound interface{}
+		if err := value.Unmarshal(&bound); err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, bound)
+	}
+	return bounds, nil
+}
+
+// Types compared with each other by range queries
+func typeBracket(t bsontype.Type) bsontype.Type {
+	switch t {
+	case bsontype.Int32, bsontype.Int64, bsontype.Double, bsontype.Decimal128:
+		return bsontype.Double
+	case bsontype.Symbol:
+		return bsontype.String
+	}
+	return t
+}
+
+// Returns the version of the server (buildInfo command)
+func (m *ConnectionHandler) ServerVersion() (string, error) {
+	var result struct {
+		Version string `bson:"version"`
+	}
+	err := m.database.RunCommand(context.Background(), bson.D{{Key: "buildInfo", Value: 1}}).Decode(&result)
+	return result.Version, err
+}
+
+// Creates indexes out of their specifications (as listed by listIndexes or found in mongodump metadata).
+// Default _id index is skipped since it always exists.
+func (m *ConnectionHandler) CreateIndexes(coll *mongo.Collection, specs []bson.D) error {
+	var indexes []bson.D
+	for _, spec := range specs {
+		var index bson.D
+		for _, elem := range spec {
+			// Namespace field was dropped on newer servers and is rejected by createIndexes
+			if elem.Key == "ns" {
+				continue
+			}
+			if elem.Key == "name" && elem.Value == "_id_" {
+				index = nil
+				break
+			}
+			index = append(index, elem)
+		}
+		if index != nil {
+			indexes = append(indexes, index)
+		}
+	}
+
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	command := bson.D{{Key: "createIndexes", Value: coll.Name()}, {Key: "indexes", Value: indexes}}
+	if err := coll.Database().RunCommand(context.Background(), command).Err(); err != nil {
+		return err
+	}
+	logger.InfoLogger.Printf("%d indexes created on %s", len(indexes), coll.Name())
+	return nil
+}
+
 // Retrieve collection
 func (m *ConnectionHandler) GetCollection(collectionName string) *mongo.Collection {
 	logger.InfoLogger.Println("GetCollectionName:", collectionName)
 	return m.database.Collection(collectionName)
 }
 
+// Opens the cursor streamed into workers (find or aggregate)
+type openCursor func(ctx context.Context) (*mongo.Cursor, error)
+
 // Streaming results into a pool of workers
-// Process function should loop through channel. Once the channel is closed, worker should send a Done signal when finished iterating the channel.
+// Process function should loop through channel until it is closed, or until ctx is done (another worker or the cursor
+// failed). The first error returned by a worker cancels the extraction and is returned.
 func (m *ConnectionHandler) StreamingResults(mapping, filePrefix, fileLocation string,
 	batchSize, numWorkers int32,
-	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, wg *sync.WaitGroup, filePrefix string, folder string),
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error,
 	coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) error {
 
-	ctx := context.Background()
+	return m.streamResults(mapping, filePrefix, fileLocation, batchSize, numWorkers, process, func(ctx context.Context) (*mongo.Cursor, error) {
+		return coll.Find(ctx, filter, opts...)
+	})
+}
+
+// Same as StreamingResults, but documents come from an aggregation pipeline
+func (m *ConnectionHandler) StreamingAggregateResults(mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32,
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error,
+	coll *mongo.Collection, pipeline interface{}, opts ...*options.AggregateOptions) error {
+
+	return m.streamResults(mapping, filePrefix, fileLocation, batchSize, numWorkers, process, func(ctx context.Context) (*mongo.Cursor, error) {
+		return coll.Aggregate(ctx, pipeline, opts...)
+	})
+}
+
+// Same as StreamingResults, but every filter (a range of the collection) is read by its own cursor, concurrently.
+// Every cursor feeds the same pool of workers, so batches of ranges are interleaved.
+func (m *ConnectionHandler) StreamingPartitionedResults(mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32,
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error,
+	coll *mongo.Collection, filters []interface{}, opts ...*options.FindOptions) error {
+
+	opens := make([]openCursor, len(filters))
+	for i, filter := range filters {
+		filter := filter
+		opens[i] = func(ctx context.Context) (*mongo.Cursor, error) {
+			return coll.Find(ctx, filter, opts...)
+		}
+	}
+	return m.streamResults(mapping, filePrefix, fileLocation, batchSize, numWorkers, process, opens...)
+}
+
+// Max attempts to read a page of StreamingPagedResults
+const pageAttempts = 5
+
+// Same as StreamingResults, but documents are read by pages of at most pageSize documents in _id order
+// (keyset pagination): every page is a new query for documents above the last _id read, fetched in a single
+// batch when it fits, so no cursor stays idle while workers are busy. Filter and options (projection, hint,
+// collation...) apply to every page, their sort, skip and limit are replaced. Documents must hold their _id.
+// A transient error (network, election, cursor killed) only reads the current page again, from the last
+// document read, waiting longer after every attempt.
+func (m *ConnectionHandler) StreamingPagedResults(mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers, pageSize int32,
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error,
+	coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) error {
+
+	return m.streamReads(mapping, filePrefix, fileLocation, numWorkers, process, func(ctx context.Context, pipe chan<- []*bson.M) error {
+		return readPages(ctx, coll, filter, opts, pipe, batchSize, pageSize)
+	})
+}
+
+// Reads every cursor into a pool of workers
+func (m *ConnectionHandler) streamResults(mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32,
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error,
+	opens ...openCursor) error {
+
+	reads := make([]func(ctx context.Context, pipe chan<- []*bson.M) error, len(opens))
+	for i, open := range opens {
+		open := open
+		reads[i] = func(ctx context.Context, pipe chan<- []*bson.M) error {
+			return readCursor(ctx, open, pipe, batchSize)
+		}
+	}
+	return m.streamReads(mapping, filePrefix, fileLocation, numWorkers, process, reads...)
+}
+
+// Runs every read concurrently, feeding the same pool of workers
+func (m *ConnectionHandler) streamReads(mapping, filePrefix, fileLocation string,
+	numWorkers int32,
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error,
+	reads ...func(ctx context.Context, pipe chan<- []*bson.M) error) error {
+
+	// First failure (a worker or a reader) cancels ctx, so every other one stops
+	group, ctx := errgroup.WithContext(context.Background())
 
 	// Creating channel that will handler the results list
 	pipe := make(chan []*bson.M, numWorkers)
 
-	// Wait Group to Handler file dumps
-	var wg sync.WaitGroup
-
 	// Creating workers and attaching channel
 	for i := int32(0); i < numWorkers; i++ {
-		wg.Add(1)
-
 		// Dispatching function to workers
-		go process(ctx, pipe, mapping, &wg, filePrefix, fileLocation)
+		group.Go(func() error {
+			return process(ctx, pipe, mapping, filePrefix, fileLocation)
+		})
 	}
 
-	cursor, err := coll.Find(ctx, filter, opts...)
-	defer cursor.Close(ctx)
+	// One reader per cursor
+	group.Go(func() error {
+		return readConcurrently(ctx, pipe, reads...)
+	})
+
+	// Wait for all workers
+	return group.Wait()
+}
+
+// Runs every read concurrently into pipe, closed once they are all done. The first read failing stops the others.
+func readConcurrently[T any](ctx context.Context, pipe chan<- T, reads ...func(ctx context.Context, pipe chan<- T) error) error {
+	defer close(pipe)
+
+	readers, ctx := errgroup.WithContext(ctx)
+	for _, read := range reads {
+		read := read
+		readers.Go(func() error {
+			return read(ctx, pipe)
+		})
+	}
+	return readers.Wait()
+}
+
+// Decodes the documents of a cursor and sends them into pipe, in batches
+func readCursor(ctx context.Context, open openCursor, pipe chan<- []*bson.M, batchSize int32) error {
+	cursor, err := open(ctx)
 	if err != nil {
-		// logger.ErrorLogger.Fatal(err)
-		close(pipe)
 		return err
 	}
+	defer cursor.Close(ctx)
 
 	// Get a list of all returned documents and print them out.
 	// See the mongo.Cursor documentation for more examples of using cursors.
@@ -178,8 +585,6 @@ func (m *ConnectionHandler) StreamingResults(mapping, filePrefix, fileLocation s
 	for cursor.Next(ctx) {
 		var result bson.M
 		if err := cursor.Decode(&result); err != nil {
-			// logger.WarningLogger.Println("Error decoding document:", err)
-			close(pipe)
 			return err
 		} else {
 			// Appending to slice
@@ -187,13 +592,15 @@ func (m *ConnectionHandler) StreamingResults(mapping, filePrefix, fileLocation s
 			counter += 1
 		}
 
-		if counter > batchSize {
+		if counter >= batchSize {
 
 			// Reset counter
 			counter = 0
 
 			// Send data to channel
-			pipe <- results
+			if err := sendBatch(ctx, pipe, results); err != nil {
+				return err
+			}
 
 			// Resetting results
 			results = []*bson.M{}
@@ -202,22 +609,602 @@ func (m *ConnectionHandler) StreamingResults(mapping, filePrefix, fileLocation s
 
 	}
 
-	// Send residual results to channel
-	pipe <- results
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	// Send residual results to channel (ranges may be empty)
+	if len(results) > 0 {
+		return sendBatch(ctx, pipe, results)
+	}
+	return nil
+}
+
+// Sends a batch to workers, unless the extraction was cancelled (workers may not be reading anymore)
+func sendBatch[T any](ctx context.Context, pipe chan<- T, batch T) error {
+	select {
+	case pipe <- batch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reads documents matching filter by pages of pageSize documents in _id order and sends them into pipe,
+// in batches of batchSize documents
+func readPages(ctx context.Context, coll *mongo.Collection, filter interface{}, opts []*options.FindOptions,
+	pipe chan<- []*bson.M, batchSize, pageSize int32) error {
+
+	if filter == nil {
+		filter = bson.D{}
+	}
+	// A page is fetched in a single batch unless it exceeds the max reply size
+	opts = append(append([]*options.FindOptions{}, opts...),
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetSkip(0).SetLimit(int64(pageSize)).SetBatchSize(pageSize))
+
+	var last interface{}
+	results := make([]*bson.M, 0, batchSize)
+	for attempt := 1; ; {
+		pageFilter := filter
+		if last != nil {
+			pageFilter = bson.D{{Key: "$and", Value: bson.A{filter, bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: last}}}}}}}
+		}
+
+		read, err := readPage(ctx, coll, pageFilter, opts, func(result *bson.M) error {
+			id, found := (*result)["_id"]
+			if !found {
+				return fmt.Errorf("document without _id, collection cannot be read by pages (check projection)")
+			}
+			last = id
+			results = append(results, result)
+			if int32(len(results)) >= batchSize {
+				if err := sendBatch(ctx, pipe, results); err != nil {
+					return err
+				}
+				results = make([]*bson.M, 0, batchSize)
+			}
+			return nil
+		})
+		if err != nil {
+			if !isTransientError(err) || attempt >= pageAttempts {
+				return err
+			}
+			wait := time.Duration(1<<(attempt-1)) * time.Second
+			logger.WarningLogger.Printf("Reading page after _id %v failed (attempt %d of %d), retrying in %s: %s", last, attempt, pageAttempts, wait, err)
+			attempt++
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		attempt = 1
+
+		// Last page
+		if read < int64(pageSize) {
+			break
+		}
+	}
+	if last != nil {
+		if err := checkPagesCompleted(ctx, coll, filter, last); err != nil {
+			return err
+		}
+	}
+
+	if len(results) > 0 {
+		return sendBatch(ctx, pipe, results)
+	}
+	return nil
+}
+
+// $gt only compares values of the same type bracket (numbers, strings, ObjectIds...), so pages stop at the end of
+// the first bracket. Fails when the highest _id is not in the bracket of the last _id read.
+func checkPagesCompleted(ctx context.Context, coll *mongo.Collection, filter interface{}, last interface{}) error {
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetProjection(bson.D{{Key: "_id", Value: 1}})
+
+	raw, err := coll.FindOne(ctx, filter, opts).DecodeBytes()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lastType, _, err := bson.MarshalValue(last)
+	if err != nil {
+		return err
+	}
+	if maxType := raw.Lookup("_id").Type; typeBracket(lastType) != typeBracket(maxType) {
+		return fmt.Errorf("_id values are of several types (%s up to %s), collection cannot be read by pages", lastType, maxType)
+	}
+	return nil
+}
+
+// Runs a page query, read is called with every document. Returns the number of documents read.
+func readPage(ctx context.Context, coll *mongo.Collection, filter interface{}, opts []*options.FindOptions, read func(result *bson.M) error) (int64, error) {
+	cursor, err := coll.Find(ctx, filter, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var n int64
+	for cursor.Next(ctx) {
+		var result bson.M
+		if err := cursor.Decode(&result); err != nil {
+			return n, err
+		}
+		if err := read(&result); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, cursor.Err()
+}
+
+// Server error codes of failures a read can be retried after: host unreachable or not found, network timeout,
+// cursor not found, shutdown in progress, interrupted at shutdown or by a replica set state change, stepped down
+// or not primary anymore, socket exception
+var transientErrorCodes = []int{6, 7, 89, 43, 91, 11600, 11602, 189, 10107, 13435, 13436, 9001}
+
+// Checks if a read failed on an error worth retrying it
+func isTransientError(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		for _, code := range transientErrorCodes {
+			if serverErr.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Anything a change stream can be opened on: a collection, a database or the whole deployment (client)
+type Watcher interface {
+	Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error)
+}
+
+// Returns what a change stream is opened on: the whole deployment when cluster is set,
+// the collection when one is given, the database otherwise
+func (m *ConnectionHandler) GetWatcher(collectionName string, cluster bool) Watcher {
+	if cluster {
+		return m.client
+	}
+	if collectionName != "" {
+		return m.database.Collection(collectionName)
+	}
+	return m.database
+}
+
+// Streaming change events into a pool of workers, until ctx is done (events received so far are still sent).
+// A batch is sent once it holds batchSize events, or once rollInterval elapsed since its first event.
+// Before a batch is sent, sent is called with the resume token following its last event, so batches and
+// tokens come in the same order. Process function follows the same contract as in StreamingResults.
+func (m *ConnectionHandler) StreamingChanges(ctx context.Context, mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32, rollInterval time.Duration,
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error,
+	sent func(token bson.Raw),
+	watcher Watcher, pipeline interface{}, opts ...*options.ChangeStreamOptions) error {
+
+	// Creating channel that will handler the results list
+	pipe := make(chan []*bson.M, numWorkers)
+
+	// Workers keep running after ctx is done, until every batch is written. The first one failing stops the stream.
+	group, ctx, send := startAwaitWorkers(ctx, numWorkers, func(workerCtx context.Context) error {
+		return process(workerCtx, pipe, mapping, filePrefix, fileLocation)
+	}, pipe)
+
+	stream, err := watcher.Watch(ctx, pipeline, opts...)
+	if err == nil {
+		defer stream.Close(context.Background())
+
+		err = sendAwaitBatches(ctx, stream, send, batchSize, rollInterval, func(batch []*bson.M) {
+			sent(stream.ResumeToken())
+		})
+	}
 
 	close(pipe)
 
+	// Wait for all workers, a worker error comes first
+	if workersErr := group.Wait(); workersErr != nil {
+		return workersErr
+	}
+	return err
+}
+
+// Runs numWorkers workers of an await stream (change stream or tailable cursor). Returns their group, the context
+// the stream is read with (ctx, also cancelled once a worker failed) and the function sending batches to workers
+// (failing once no worker reads them anymore).
+func startAwaitWorkers(ctx context.Context, numWorkers int32, worker func(workerCtx context.Context) error,
+	pipe chan<- []*bson.M) (*errgroup.Group, context.Context, func(batch []*bson.M) error) {
+
+	group, workerCtx := errgroup.WithContext(context.Background())
+	for i := int32(0); i < numWorkers; i++ {
+		group.Go(func() error {
+			return worker(workerCtx)
+		})
+	}
+
+	readCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-workerCtx.Done():
+		case <-readCtx.Done():
+		}
+	}()
+	return group, readCtx, func(batch []*bson.M) error {
+		return sendBatch(workerCtx, pipe, batch)
+	}
+}
+
+// Streaming documents of a capped collection into a pool of workers through a tailable cursor, until ctx is done
+// (documents received so far are still sent). Documents come in natural (insertion) order, after the one holding
+// from on field when from is set, so field must grow with insertions (e.g.: an ObjectId _id).
+// Batches are rolled as in StreamingChanges. Before a batch is sent, sent is called with the value of field
+// in the last document sent so far. The cursor is opened again, after that value, whenever it dies
+// (empty collection or position lost because documents were overwritten).
+func (m *ConnectionHandler) StreamingTailableResults(ctx context.Context, mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32, rollInterval time.Duration,
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error,
+	sent func(last interface{}),
+	coll *mongo.Collection, filter bson.D, field string, from interface{}, opts ...*options.FindOptions) error {
+
+	// Creating channel that will handler the results list
+	pipe := make(chan []*bson.M, numWorkers)
+
+	// Workers keep running after ctx is done, until every batch is written. The first one failing stops the stream.
+	group, ctx, send := startAwaitWorkers(ctx, numWorkers, func(workerCtx context.Context) error {
+		return process(workerCtx, pipe, mapping, filePrefix, fileLocation)
+	}, pipe)
+
+	opts = append(opts, options.Find().SetCursorType(options.TailableAwait))
+	last := from
+	var err error
+	for ctx.Err() == nil {
+		tailFilter := filter
+		if last != nil {
+			tailFilter = bson.D{{Key: field, Value: bson.D{{Key: "$gt", Value: last}}}}
+			if len(filter) > 0 {
+				tailFilter = bson.D{{Key: "$and", Value: bson.A{filter, tailFilter}}}
+			}
+		}
+
+		var cursor *mongo.Cursor
+		if cursor, err = coll.Find(ctx, tailFilter, opts...); err != nil {
+			break
+		}
+		err = sendAwaitBatches(ctx, cursor, send, batchSize, rollInterval, func(batch []*bson.M) {
+			for i := len(batch) - 1; i >= 0; i-- {
+				if value, found := lookupValue(*batch[i], field); found {
+					last = value
+					break
+				}
+			}
+			sent(last)
+		})
+		cursor.Close(context.Background())
+		if err != nil {
+			break
+		}
+
+		// Cursor died, waits for documents before opening it again
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	if ctx.Err() != nil {
+		err = nil
+	}
+
+	close(pipe)
+
+	// Wait for all workers, a worker error comes first
+	if workersErr := group.Wait(); workersErr != nil {
+		return workersErr
+	}
+	return err
+}
+
+// Iterator waiting for new documents (change streams and tailable cursors)
+type awaitIterator interface {
+	ID() int64
+	TryNext(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+}
+
+// Sends documents of iter to workers (send) until ctx is done or iter dies, in batches of batchSize documents.
+// A batch is also sent once rollInterval elapsed since its first document. sent is called with every batch
+// before it is sent. Residual documents are sent, unless iteration failed (they are left to be read again).
+func sendAwaitBatches(ctx context.Context, iter awaitIterator, send func(batch []*bson.M) error, batchSize int32, rollInterval time.Duration, sent func(batch []*bson.M)) error {
+	results := make([]*bson.M, 0, batchSize)
+	var first time.Time
+	flush := func() error {
+		sent(results)
+		err := send(results)
+		results = make([]*bson.M, 0, batchSize)
+		return err
+	}
+
+	var err error
+	for {
+		// Waits at most the max await time of iter
+		if iter.TryNext(ctx) {
+			var result bson.M
+			if err = iter.Decode(&result); err != nil {
+				break
+			}
+			if len(results) == 0 {
+				first = time.Now()
+			}
+			results = append(results, &result)
+			if int32(len(results)) >= batchSize {
+				if err = flush(); err != nil {
+					break
+				}
+			}
+			continue
+		}
+		if err = iter.Err(); err != nil || ctx.Err() != nil || iter.ID() == 0 {
+			break
+		}
+		if len(results) > 0 && time.Since(first) >= rollInterval {
+			if err = flush(); err != nil {
+				break
+			}
+		}
+	}
+
+	// Stopping is not an error
+	if ctx.Err() != nil {
+		err = nil
+	}
+	if err == nil && len(results) > 0 {
+		err = flush()
+	}
+	return err
+}
+
+// Returns the value at path (dotted notation) of a document
+func lookupValue(doc bson.M, path string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		current, ok := value.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if value = current[key]; value == nil {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Same as StreamingResults, but documents are sent as they come from the cursor (bson.Raw), without being decoded
+// Useful when documents are written back as bson, since fields order and types are kept untouched.
+func (m *ConnectionHandler) StreamingRawResults(mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32,
+	process func(ctx context.Context, batchData <-chan []bson.Raw, mapping string, filePrefix string, folder string) error,
+	coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) error {
+
+	return m.streamRawResults(mapping, filePrefix, fileLocation, batchSize, numWorkers, process, func(ctx context.Context) (*mongo.Cursor, error) {
+		return coll.Find(ctx, filter, opts...)
+	})
+}
+
+// Same as StreamingRawResults, but documents come from an aggregation pipeline
+func (m *ConnectionHandler) StreamingRawAggregateResults(mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32,
+	process func(ctx context.Context, batchData <-chan []bson.Raw, mapping string, filePrefix string, folder string) error,
+	coll *mongo.Collection, pipeline interface{}, opts ...*options.AggregateOptions) error {
+
+	return m.streamRawResults(mapping, filePrefix, fileLocation, batchSize, numWorkers, process, func(ctx context.Context) (*mongo.Cursor, error) {
+		return coll.Aggregate(ctx, pipeline, opts...)
+	})
+}
+
+// Same as StreamingPartitionedResults, but documents are sent as they come from the cursor (bson.Raw)
+func (m *ConnectionHandler) StreamingPartitionedRawResults(mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32,
+	process func(ctx context.Context, batchData <-chan []bson.Raw, mapping string, filePrefix string, folder string) error,
+	coll *mongo.Collection, filters []interface{}, opts ...*options.FindOptions) error {
+
+	opens := make([]openCursor, len(filters))
+	for i, filter := range filters {
+		filter := filter
+		opens[i] = func(ctx context.Context) (*mongo.Cursor, error) {
+			return coll.Find(ctx, filter, opts...)
+		}
+	}
+	return m.streamRawResults(mapping, filePrefix, fileLocation, batchSize, numWorkers, process, opens...)
+}
+
+func (m *ConnectionHandler) streamRawResults(mapping, filePrefix, fileLocation string,
+	batchSize, numWorkers int32,
+	process func(ctx context.Context, batchData <-chan []bson.Raw, mapping string, filePrefix string, folder string) error,
+	opens ...openCursor) error {
+
+	// First failure (a worker or a reader) cancels ctx, so every other one stops
+	group, ctx := errgroup.WithContext(context.Background())
+
+	// Creating channel that will handler the results list
+	pipe := make(chan []bson.Raw, numWorkers)
+
+	// Creating workers and attaching channel
+	for i := int32(0); i < numWorkers; i++ {
+		// Dispatching function to workers
+		group.Go(func() error {
+			return process(ctx, pipe, mapping, filePrefix, fileLocation)
+		})
+	}
+
+	// One reader per cursor
+	reads := make([]func(ctx context.Context, pipe chan<- []bson.Raw) error, len(opens))
+	for i, open := range opens {
+		open := open
+		reads[i] = func(ctx context.Context, pipe chan<- []bson.Raw) error {
+			return readRawCursor(ctx, open, pipe, batchSize)
+		}
+	}
+	group.Go(func() error {
+		return readConcurrently(ctx, pipe, reads...)
+	})
+
 	// Wait for all workers
-	wg.Wait()
+	return group.Wait()
+}
+
+// Sends the documents of a cursor into pipe, in batches, without decoding them
+func readRawCursor(ctx context.Context, open openCursor, pipe chan<- []bson.Raw, batchSize int32) error {
+	cursor, err := open(ctx)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	// Workers own the batches sent, every batch gets its own buffer
+	batch := newRawBatch(batchSize)
+	for cursor.Next(ctx) {
+		batch.add(cursor.Current)
+
+		if int32(len(batch.docs)) >= batchSize {
+			if err := sendBatch(ctx, pipe, batch.take()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	// Send residual results to channel
+	if len(batch.docs) > 0 {
+		return sendBatch(ctx, pipe, batch.take())
+	}
+	return nil
+}
+
+// Documents of a batch copied into a shared buffer: cursor.Current is only valid until the next batch is
+// fetched, copying every document into its own slice costs an allocation per document
+type rawBatch struct {
+	buf  []byte
+	docs []bson.Raw
+	size int32
+}
+
+func newRawBatch(size int32) *rawBatch {
+	return &rawBatch{docs: make([]bson.Raw, 0, size), size: size}
+}
 
-	if cursor.Err() != nil {
-		// logger.ErrorLogger.Fatalln("Error while iterating cursor:", cursor.Err())
+// Copies doc at the end of the buffer. A full buffer is replaced by a larger one, documents already
+// added keep pointing to the previous one.
+func (b *rawBatch) add(doc bson.Raw) {
+	if len(b.buf)+len(doc) > cap(b.buf) {
+		b.buf = make([]byte, 0, 2*cap(b.buf)+len(doc))
+	}
+	start := len(b.buf)
+	b.buf = append(b.buf, doc...)
+	b.docs = append(b.docs, b.buf[start:len(b.buf):len(b.buf)])
+}
+
+// Hands the documents over and starts a new batch, its buffer sized after the batch taken
+func (b *rawBatch) take() []bson.Raw {
+	docs := b.docs
+	var bytes int
+	for _, doc := range docs {
+		bytes += len(doc)
+	}
+	b.buf = make([]byte, 0, bytes)
+	b.docs = make([]bson.Raw, 0, b.size)
+	return docs
+}
+
+// Empties the batch keeping its buffer, once documents are not used anymore
+func (b *rawBatch) reset() {
+	b.buf = b.buf[:0]
+	b.docs = b.docs[:0]
+}
+
+// Same as ExtractResults, but documents are passed as they come from the cursor (bson.Raw), without being decoded.
+// Batches share the same buffer: process must not keep documents once it returned.
+func (m *ConnectionHandler) ExtractRawResults(mapping string, filePrefix string, fileLocation string, batchSize int32, process func([]bson.Raw, string, string, string) error, coll *mongo.Collection, filter interface{}, opts ...*options.FindOptions) error {
+	ctx := context.TODO()
+	cursor, err := coll.Find(ctx, filter, opts...)
+	if err != nil {
 		return err
 	}
+	defer cursor.Close(ctx)
 
+	batch := newRawBatch(batchSize)
+	for cursor.Next(ctx) {
+		batch.add(cursor.Current)
+		if int32(len(batch.docs)) >= batchSize {
+			if err := process(batch.docs, mapping, filePrefix, fileLocation); err != nil {
+				return err
+			}
+			batch.reset()
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if len(batch.docs) > 0 {
+		return process(batch.docs, mapping, filePrefix, fileLocation)
+	}
 	return nil
 }
 
+// Retrieves collection metadata in the same shape mongodump writes into <collection>.metadata.json:
+// indexes, options, uuid, collection name and type.
+func (m *ConnectionHandler) CollectionMetadata(collectionName string) (bson.D, error) {
+	ctx := context.Background()
+
+	specs, err := m.database.ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: collectionName}})
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("collection %s not found", collectionName)
+	}
+	spec := specs[0]
+
+	cursor, err := m.database.Collection(collectionName).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	indexes := []bson.Raw{}
+	if err = cursor.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+
+	options := spec.Options
+	if options == nil {
+		options = bson.Raw(emptyDocument)
+	}
+
+	metadata := bson.D{
+		{Key: "indexes", Value: indexes},
+	}
+	if spec.UUID != nil {
+		metadata = append(metadata, bson.E{Key: "uuid", Value: hex.EncodeToString(spec.UUID.Data)})
+	}
+	metadata = append(metadata,
+		bson.E{Key: "collectionName", Value: collectionName},
+		bson.E{Key: "type", Value: spec.Type},
+		bson.E{Key: "options", Value: options},
+	)
+	return metadata, nil
+}
+
 // This method reads files, populates a slice and inserts into a collection (this may require a huge amount of memory)
 func (m *ConnectionHandler) InsertFromFiles(filePrefix, folder string, walk func(filePrefix string, folder string) ([]any, error), coll *mongo.Collection, opts ...*options.InsertManyOptions) error {
 	ctx := context.Background()
@@ -1,11 +1,19 @@
 package mongo
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -75,3 +83,438 @@ func TestInsertMany(t *testing.T) {
 		t.Errorf("Could not insert on collection: `%s`\n%s", collName, err)
 	}
 }
+
+// Change streams require a replica set (a single node one is enough)
+func TestStreamingChanges(t *testing.T) {
+	handler, disconnect, teardown := setup(t)
+	defer disconnect()
+	defer teardown(t)
+
+	collName := os.Getenv("MONGO_COLLECTION_TARGET")
+	collection := handler.GetCollection(collName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var events []*bson.M
+	var tokens []bson.Raw
+	process := func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error {
+		for batch := range batchData {
+			mu.Lock()
+			events = append(events, batch...)
+			if len(events) >= 3 {
+				cancel()
+			}
+			mu.Unlock()
+		}
+		return nil
+	}
+	sent := func(token bson.Raw) {
+		mu.Lock()
+		defer mu.Unlock()
+		tokens = append(tokens, token)
+	}
+
+	go func() {
+		// Leaves time for the stream to open
+		time.Sleep(time.Second)
+		for i := 0; i < 3; i++ {
+			if _, err := collection.InsertOne(context.Background(), bson.D{{Key: "seq", Value: i}}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	opts := options.ChangeStream().SetMaxAwaitTime(100 * time.Millisecond)
+	err := handler.StreamingChanges(ctx, "changes", "changes", t.TempDir(), 2, 1, 200*time.Millisecond, process, sent,
+		handler.GetWatcher(collName, false), bson.A{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	if (*events[0])["operationType"] != "insert" {
+		t.Errorf("Unexpected event: %v", *events[0])
+	}
+	if len(tokens) != 2 || tokens[len(tokens)-1] == nil {
+		t.Errorf("Expected a resume token per batch, got %v", tokens)
+	}
+}
+
+// Tailable cursors require a capped collection, created by the test
+func TestStreamingTailableResults(t *testing.T) {
+	handler, disconnect, teardown := setup(t)
+	defer disconnect()
+	defer teardown(t)
+
+	collName := os.Getenv("MONGO_COLLECTION_TARGET") + "_capped"
+	collection := handler.GetCollection(collName)
+	collection.Drop(context.Background())
+	opts := options.CreateCollection().SetCapped(true).SetSizeInBytes(1 << 20)
+	if err := handler.database.CreateCollection(context.Background(), collName, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer collection.Drop(context.Background())
+	for i := int64(1); i <= 3; i++ {
+		if _, err := collection.InsertOne(context.Background(), bson.D{{Key: "seq", Value: i}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var seqs []int64
+	var positions []interface{}
+	process := func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error {
+		for batch := range batchData {
+			mu.Lock()
+			for _, doc := range batch {
+				seqs = append(seqs, (*doc)["seq"].(int64))
+			}
+			if len(seqs) >= 3 {
+				cancel()
+			}
+			mu.Unlock()
+		}
+		return nil
+	}
+	sent := func(last interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		positions = append(positions, last)
+	}
+
+	// Documents up to seq 1 were written by a previous run
+	go func() {
+		time.Sleep(time.Second)
+		if _, err := collection.InsertOne(context.Background(), bson.D{{Key: "seq", Value: int64(4)}}); err != nil {
+			t.Error(err)
+		}
+	}()
+	findOpts := options.Find().SetMaxAwaitTime(100 * time.Millisecond)
+	err := handler.StreamingTailableResults(ctx, "tail", "tail", t.TempDir(), 2, 1, 200*time.Millisecond, process, sent,
+		collection, nil, "seq", int64(1), findOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seqs) != 3 || seqs[0] != 2 || seqs[2] != 4 {
+		t.Fatalf("Expected documents 2 to 4, got %v", seqs)
+	}
+	if positions[len(positions)-1] != int64(4) {
+		t.Errorf("Expected last position 4, got %v", positions)
+	}
+}
+
+func TestPartitionBounds(t *testing.T) {
+	handler, disconnect, teardown := setup(t)
+	defer disconnect()
+	defer teardown(t)
+
+	collection := handler.GetCollection(os.Getenv("MONGO_COLLECTION"))
+	for _, method := range []string{SplitSample, SplitBucketAuto} {
+		bounds, err := handler.PartitionBounds(collection, nil, "_id", 4, method)
+		if err != nil {
+			t.Fatalf("%s: %s", method, err)
+		}
+		if len(bounds) == 0 || len(bounds) > 3 {
+			t.Errorf("%s: expected 1 to 3 bounds, got %v", method, bounds)
+		}
+	}
+}
+
+func TestStreamingPagedResults(t *testing.T) {
+	handler, disconnect, teardown := setup(t)
+	defer disconnect()
+	defer teardown(t)
+
+	collection := handler.GetCollection(os.Getenv("MONGO_COLLECTION_TARGET") + "_paged")
+	collection.Drop(context.Background())
+	defer collection.Drop(context.Background())
+	var docs []interface{}
+	for i := int64(1); i <= 25; i++ {
+		docs = append(docs, bson.D{{Key: "_id", Value: i}, {Key: "even", Value: i%2 == 0}, {Key: "payload", Value: "x"}})
+	}
+	if _, err := collection.InsertMany(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var ids []int64
+	process := func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error {
+		for batch := range batchData {
+			mu.Lock()
+			for _, doc := range batch {
+				if _, found := (*doc)["payload"]; found {
+					t.Error("Expected projection to apply to every page")
+				}
+				ids = append(ids, (*doc)["_id"].(int64))
+			}
+			mu.Unlock()
+		}
+		return nil
+	}
+
+	// Query and projection apply to every page of 4 documents
+	opts := options.Find().SetProjection(bson.D{{Key: "payload", Value: 0}})
+	err := handler.StreamingPagedResults("paged", "paged", t.TempDir(), 3, 2, 4, process, collection, bson.D{{Key: "even", Value: true}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 12 {
+		t.Fatalf("Expected the 12 even documents, got %v", ids)
+	}
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		if id%2 != 0 || seen[id] {
+			t.Errorf("Unexpected document %d in %v", id, ids)
+		}
+		seen[id] = true
+	}
+}
+
+func TestStreamingPagedResultsMixedIds(t *testing.T) {
+	handler, disconnect, teardown := setup(t)
+	defer disconnect()
+	defer teardown(t)
+
+	collection := handler.GetCollection(os.Getenv("MONGO_COLLECTION_TARGET") + "_paged_mixed")
+	collection.Drop(context.Background())
+	defer collection.Drop(context.Background())
+	docs := []interface{}{bson.D{{Key: "_id", Value: int32(1)}}, bson.D{{Key: "_id", Value: 2.5}}, bson.D{{Key: "_id", Value: "k"}}}
+	if _, err := collection.InsertMany(context.Background(), docs); err != nil {
+		t.Fatal(err)
+	}
+
+	process := func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error {
+		for range batchData {
+		}
+		return nil
+	}
+	// Numbers are read across int and double, the string _id is not
+	err := handler.StreamingPagedResults("paged", "paged", t.TempDir(), 1, 1, 1, process, collection, nil)
+	if err == nil {
+		t.Fatal("Expected mixed _id types to fail")
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	if !isTransientError(mongo.CommandError{Code: 43, Name: "CursorNotFound"}) {
+		t.Error("Expected a cursor not found error to be transient")
+	}
+	if isTransientError(mongo.CommandError{Code: 2, Name: "BadValue"}) || isTransientError(fmt.Errorf("document without _id")) {
+		t.Error("Expected bad values not to be transient")
+	}
+}
+
+func TestRawBatch(t *testing.T) {
+	doc := func(i int32) bson.Raw {
+		raw, _ := bson.Marshal(bson.D{{Key: "_id", Value: i}, {Key: "payload", Value: "some text"}})
+		return raw
+	}
+
+	// Documents copied before the buffer grows keep their bytes
+	batch := newRawBatch(4)
+	for i := int32(0); i < 4; i++ {
+		current := doc(i)
+		batch.add(current)
+		// The cursor reuses its buffer
+		for j := range current {
+			current[j] = 0
+		}
+	}
+	docs := batch.take()
+	for i, raw := range docs {
+		if id := raw.Lookup("_id").Int32(); id != int32(i) {
+			t.Errorf("Expected document %d, got %d", i, id)
+		}
+	}
+
+	// A batch taken is not touched by the next one, which starts with a buffer of its size
+	if cap(batch.buf) != 4*len(doc(0)) || len(batch.docs) != 0 {
+		t.Errorf("Unexpected next batch: %d bytes buffer, %d documents", cap(batch.buf), len(batch.docs))
+	}
+	batch.add(doc(9))
+	if id := docs[0].Lookup("_id").Int32(); id != 0 {
+		t.Errorf("Expected document 0 untouched, got %d", id)
+	}
+
+	// A reset batch reuses its buffer
+	batch.reset()
+	batch.add(doc(7))
+	if id := batch.docs[0].Lookup("_id").Int32(); id != 7 || len(batch.docs) != 1 {
+		t.Errorf("Expected a single document 7, got %v", batch.docs)
+	}
+}
+
+// Throughput of extraction on a local mongod (MONGO_CONN_URI): documents decoded into maps then
+// marshalled into extended json, or converted from the raw bytes read from the cursor.
+// Run with: go test -run xxx -bench StreamingResults
+func BenchmarkStreamingResults(b *testing.B) {
+	handler, disconnect, teardown := setup(b)
+	defer disconnect()
+	defer teardown(b)
+
+	collection := handler.GetCollection(os.Getenv("MONGO_COLLECTION_TARGET") + "_bench")
+	collection.Drop(context.Background())
+	defer collection.Drop(context.Background())
+	const total = 100000
+	var docs []interface{}
+	for i := 0; i < total; i++ {
+		docs = append(docs, bson.D{
+			{Key: "_id", Value: primitive.NewObjectID()},
+			{Key: "counter", Value: int64(i)},
+			{Key: "created", Value: primitive.NewDateTimeFromTime(time.Now())},
+			{Key: "word", Value: "quaerat"},
+			{Key: "location", Value: bson.D{{Key: "latitude", Value: 79.68}, {Key: "longitude", Value: 144.97}}},
+			{Key: "tags", Value: bson.A{"a", "b", "c"}},
+		})
+	}
+	if _, err := collection.InsertMany(context.Background(), docs); err != nil {
+		b.Fatal(err)
+	}
+
+	var written int64
+	b.Run("decoded", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			process := func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error {
+				buf := make([]byte, 0, 1024)
+				for batch := range batchData {
+					for _, doc := range batch {
+						buf, _ = bson.MarshalExtJSONAppend(buf[:0], doc, false, false)
+						atomic.AddInt64(&written, int64(len(buf)))
+					}
+				}
+				return nil
+			}
+			if err := handler.StreamingResults("bench", "bench", b.TempDir(), 1000, 4, process, collection, bson.D{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(total*b.N)/b.Elapsed().Seconds(), "docs/s")
+	})
+	b.Run("raw", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			process := func(ctx context.Context, batchData <-chan []bson.Raw, mapping string, filePrefix string, folder string) error {
+				buf := make([]byte, 0, 1024)
+				for batch := range batchData {
+					for _, doc := range batch {
+						buf, _ = bson.MarshalExtJSONAppend(buf[:0], doc, false, false)
+						atomic.AddInt64(&written, int64(len(buf)))
+					}
+				}
+				return nil
+			}
+			if err := handler.StreamingRawResults("bench", "bench", b.TempDir(), 1000, 4, process, collection, bson.D{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(total*b.N)/b.Elapsed().Seconds(), "docs/s")
+	})
+}
+
+func TestStreamReadsStopsOnFirstError(t *testing.T) {
+	handler := &ConnectionHandler{}
+	diskFull := fmt.Errorf("no space left on device")
+
+	// Reader never ends by itself, a failed worker must cancel it
+	endless := func(ctx context.Context, pipe chan<- []*bson.M) error {
+		for {
+			if err := sendBatch(ctx, pipe, []*bson.M{{"n": 1}}); err != nil {
+				return err
+			}
+		}
+	}
+	var received int64
+	process := func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case _, ok := <-batchData:
+				if !ok {
+					return nil
+				}
+				if atomic.AddInt64(&received, 1) == 3 {
+					return diskFull
+				}
+			}
+		}
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- handler.streamReads("fail", "fail", t.TempDir(), 2, process, endless)
+	}()
+	select {
+	case err := <-done:
+		if err != diskFull {
+			t.Errorf("Expected the worker error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected a failed worker to stop the extraction")
+	}
+
+	// A failed reader stops other readers and workers, its error is returned
+	cursorErr := fmt.Errorf("cursor killed")
+	failing := func(ctx context.Context, pipe chan<- []*bson.M) error {
+		if err := sendBatch(ctx, pipe, []*bson.M{{"n": 1}}); err != nil {
+			return err
+		}
+		return cursorErr
+	}
+	drain := func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case _, ok := <-batchData:
+				if !ok {
+					return nil
+				}
+			}
+		}
+	}
+	go func() {
+		done <- handler.streamReads("fail", "fail", t.TempDir(), 2, drain, endless, failing)
+	}()
+	select {
+	case err := <-done:
+		if err != cursorErr {
+			t.Errorf("Expected the reader error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected a failed reader to stop the extraction")
+	}
+}
+
+func TestFindFilesSkipsMetadata(t *testing.T) {
+	folder := t.TempDir()
+	names := []string{"users.bson", "users.bson.gz", "users.metadata.json", "users.metadata.json.gz", "users.metadata.json.zst", "other.bson"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(folder, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pipe := make(chan string, len(names))
+	counter, err := findFiles("users", folder, pipe)
+	close(pipe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for path := range pipe {
+		found = append(found, filepath.Base(path))
+	}
+	if counter != 2 || len(found) != 2 || found[0] != "users.bson" || found[1] != "users.bson.gz" {
+		t.Errorf("Expected only bson files, got %v", found)
+	}
+}
@@ -41,6 +41,7 @@ func createFiles(filePrefix, folder string) error {
 }
 
 // Function to read files listed in test directory
+// Files may hold plain json or MongoDB Extended JSON arrays
 func ReadFiles(filePrefix string, folder string) ([]any, error) {
 	// slice with results
 	var documents []any
@@ -67,8 +68,13 @@ func ReadFiles(filePrefix string, folder string) ([]any, error) {
 			defer file.Close()
 
 			data, err := ioutil.ReadAll(file)
+			if err != nil {
+				logger.ErrorLogger.Println(err)
+				return err
+			}
 
-			var jsonArray []map[string]any
+			// Documents are kept raw so they can be parsed as extended json
+			var jsonArray []json.RawMessage
 
 			err = json.Unmarshal(data, &jsonArray)
 			if err != nil {
@@ -77,18 +83,12 @@ func ReadFiles(filePrefix string, folder string) ([]any, error) {
 			}
 
 			for _, jsonObj := range jsonArray {
-				bsonMap, err := bson.Marshal(jsonObj)
-				if err != nil {
-					logger.ErrorLogger.Printf("Error converting to BSON: %v\n", err)
+				doc := bson.D{}
+				if err = bson.UnmarshalExtJSON(jsonObj, false, &doc); err != nil {
+					logger.ErrorLogger.Printf("Error unmarshalling extended json: %v\n", err)
 					return err
 				}
-				bsonM := bson.M{}
-				err = bson.Unmarshal(bsonMap, &bsonM)
-				if err != nil {
-					logger.ErrorLogger.Printf("Error unmarshalling BSON: %v\n", err)
-					return err
-				}
-				documents = append(documents, &bsonM)
+				documents = append(documents, doc)
 			}
 
 		}
@@ -103,6 +103,20 @@ func ReadFiles(filePrefix string, folder string) ([]any, error) {
 	return documents, nil
 }
 
+// Extensions of compressed files, as written by the extractor
+var compressionExtensions = []string{".gz", ".zst", ".sz", ".snappy"}
+
+// Checks if file is a collection metadata file written next to .bson files, compressed or not
+func IsMetadataFile(fileName string) bool {
+	for _, ext := range compressionExtensions {
+		if strings.HasSuffix(strings.ToLower(fileName), ext) {
+			fileName = fileName[:len(fileName)-len(ext)]
+			break
+		}
+	}
+	return strings.HasSuffix(fileName, ".metadata.json")
+}
+
 func findFiles(filePrefix string, folder string, pipe chan<- string) (int, error) {
 
 	fileCounter := 0
@@ -118,8 +132,8 @@ func findFiles(filePrefix string, folder string, pipe chan<- string) (int, error
 			return nil
 		}
 
-		// Read only files that match prefix
-		if !info.IsDir() && strings.HasPrefix(info.Name(), filePrefix) {
+		// Read only files that match prefix (collection metadata files written next to .bson files are skipped)
+		if !info.IsDir() && strings.HasPrefix(info.Name(), filePrefix) && !IsMetadataFile(info.Name()) {
 			pipe <- path
 			fileCounter++
 		}
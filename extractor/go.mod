@@ -3,18 +3,21 @@ module github.com/farovictor/MongoDbExtractor
 go 1.20
 
 require (
+	github.com/apache/arrow/go/v14 v14.0.2
+	github.com/golang/snappy v0.0.4
 	github.com/google/uuid v1.3.1
+	github.com/klauspost/compress v1.16.7
+	github.com/linkedin/goavro/v2 v2.12.0
 	github.com/spf13/cobra v1.7.0
 	go.mongodb.org/mongo-driver v1.12.1
+	golang.org/x/sync v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/golang/snappy v0.0.3 // indirect
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
 	golang.org/x/crypto v0.9.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
 )
@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/farovictor/MongoDbExtractor/src/files"
+	logger "github.com/farovictor/MongoDbExtractor/src/logging"
+	mongo "github.com/farovictor/MongodbDriver"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Checks checkpoint flags: documents are read by a single cursor in sort order, into chunk files named after
+// their chunk id only, so a resumed run writes the same files
+func validateCheckpoint() error {
+	if resume {
+		checkpointing = true
+	}
+	if !checkpointing {
+		return nil
+	}
+	if pipelineSpec != "" || pipelineFile != "" {
+		return fmt.Errorf("--checkpoint cannot be used along with an aggregation pipeline")
+	}
+	if partitions >= 2 {
+		return fmt.Errorf("--checkpoint cannot be used along with --partitions")
+	}
+	if limit != 0 || skip != 0 {
+		return fmt.Errorf("--checkpoint cannot be used along with --limit or --skip")
+	}
+	if archivePath != "" || outputFormat == constants.FormatBson || outputFormat == constants.FormatArrow {
+		return fmt.Errorf("--checkpoint cannot be used with a single file output (archive, bson or arrow)")
+	}
+	if files.TemplateUses(outputFilePrefix, files.VarWorkerId) {
+		return fmt.Errorf("--checkpoint cannot be used with {%s} in --output-prefix", files.VarWorkerId)
+	}
+	if !files.TemplateUses(outputFilePrefix, files.VarChunkId) {
+		return fmt.Errorf("--checkpoint needs {%s} in --output-prefix", files.VarChunkId)
+	}
+	_, err := checkpointSort()
+	return err
+}
+
+// Order documents are read in when checkpointing: --sort (1 or -1 directions) followed by _id,
+// so every document has its own position
+func checkpointSort() (bson.D, error) {
+	sort := bson.D{}
+	if sortBy != "" {
+		var err error
+		if sort, err = parseSort(sortBy); err != nil {
+			return nil, fmt.Errorf("invalid --sort: %w", err)
+		}
+	}
+	for _, elem := range sort {
+		if _, ok := elem.Value.(bson.D); ok {
+			return nil, fmt.Errorf("--checkpoint cannot be used with a $meta sort on %s", elem.Key)
+		}
+		if elem.Key == "_id" {
+			return sort, nil
+		}
+	}
+	return append(sort, bson.E{Key: "_id", Value: 1}), nil
+}
+
+// Checkpoint of the run to resume (nil unless --resume is set and a run did not complete in --output-path).
+// Its run goes on: same run id and start time, so file names follow the ones already written.
+func loadCheckpoint() *files.Checkpoint {
+	if !resume {
+		return nil
+	}
+	checkpoint, err := files.ReadCheckpoint(outputPath)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error reading checkpoint:", err)
+	}
+	if checkpoint == nil {
+		logger.InfoLogger.Printf("No checkpoint found in %s, starting a new run", outputPath)
+		return nil
+	}
+	sort, _ := checkpointSort()
+	sortText, err := bson.MarshalExtJSON(sort, true, false)
+	if err != nil {
+		logger.ErrorLogger.Fatalln(err)
+	}
+	for _, value := range []struct {
+		flag       string
+		saved, set string
+	}{
+		{"mapping", checkpoint.Mapping, mapping},
+		{"db-name", checkpoint.Database, dbName},
+		{"collection", checkpoint.Collection, collectionName},
+		{"query", checkpoint.Query, query},
+		{"projection", checkpoint.Projection, projection},
+		{"collation", checkpoint.Collation, collationSpec},
+		{"hint", checkpoint.Hint, hint},
+		{"incremental-field", checkpoint.IncrementalField, incrementalField},
+		{"sort", checkpoint.Sort, string(sortText)},
+		{"output-format", checkpoint.Format, outputFormat},
+		{"compress", checkpoint.Compression, outputCompression().Codec},
+		{"output-prefix", checkpoint.OutputPrefix, outputFilePrefix},
+		{"chunk-size", fmt.Sprint(checkpoint.ChunkSize), fmt.Sprint(batchSize)},
+		{"page-size", fmt.Sprint(checkpoint.PageSize), fmt.Sprint(pageSize)},
+	} {
+		if value.saved != value.set {
+			logger.ErrorLogger.Fatalf("Checkpoint in %s was written with --%s %q, remove it to start over", outputPath, value.flag, value.saved)
+		}
+	}
+
+	if err := files.ValidateTemplate(outputFilePrefix); err != nil {
+		logger.ErrorLogger.Fatalln("Error handling output-prefix argument:", err)
+	}
+	run = checkpoint.Run()
+	logger.InfoLogger.Printf("Resuming run %s: %d chunks written, last position %s", run.RunId, len(checkpoint.Chunks), checkpoint.Last)
+	return checkpoint
+}
+
+// Starts the checkpoint of a new run, filter is the one run (watermark range included)
+func newCheckpoint(filter bson.D, to any) *files.Checkpoint {
+	checkpoint := &files.Checkpoint{
+		RunId:            run.RunId,
+		Mapping:          mapping,
+		Database:         dbName,
+		Collection:       collectionName,
+		Query:            query,
+		Projection:       projection,
+		Collation:        collationSpec,
+		Hint:             hint,
+		IncrementalField: incrementalField,
+		Format:           outputFormat,
+		Compression:      outputCompression().Codec,
+		OutputPrefix:     outputFilePrefix,
+		ChunkSize:        batchSize,
+		PageSize:         pageSize,
+		StartTime:        run.Start,
+		Chunks:           []files.ManifestChunk{},
+	}
+	sort, _ := checkpointSort()
+	for _, value := range []struct {
+		field *string
+		doc   interface{}
+	}{{&checkpoint.Filter, filter}, {&checkpoint.Sort, sort}} {
+		text, err := bson.MarshalExtJSON(value.doc, true, false)
+		if err != nil {
+			logger.ErrorLogger.Fatalln("Error writing checkpoint:", err)
+		}
+		*value.field = string(text)
+	}
+	if to != nil {
+		text, err := bson.MarshalExtJSON(bson.D{{Key: "value", Value: to}}, true, false)
+		if err != nil {
+			logger.ErrorLogger.Fatalln("Error writing checkpoint:", err)
+		}
+		checkpoint.Watermark = string(text)
+	}
+	return checkpoint
+}
+
+// Filter and watermark saving function of a resumed run, as they were computed when the run started
+func resumeFilter(handler *mongo.ConnectionHandler, checkpoint *files.Checkpoint) (bson.D, func()) {
+	var filter bson.D
+	if err := bson.UnmarshalExtJSON([]byte(checkpoint.Filter), true, &filter); err != nil {
+		logger.ErrorLogger.Fatalln("Error reading checkpoint filter:", err)
+	}
+	if checkpoint.Watermark == "" {
+		return filter, func() {}
+	}
+	var watermark bson.D
+	if err := bson.UnmarshalExtJSON([]byte(checkpoint.Watermark), true, &watermark); err != nil || len(watermark) != 1 {
+		logger.ErrorLogger.Fatalln("Error reading checkpoint watermark:", checkpoint.Watermark, err)
+	}
+	return filter, watermarkCommit(stateStore(handler), watermark[0].Value)
+}
+
+// Restricts filter to documents after position last in sort order: beyond it on the first key,
+// or equal on the first keys and beyond it on the next one
+func afterFilter(filter bson.D, sort bson.D, last bson.D) bson.D {
+	branches := bson.A{}
+	for i, elem := range sort {
+		operator := "$gt"
+		if descending(elem.Value) {
+			operator = "$lt"
+		}
+		branch := append(bson.D{}, last[:i]...)
+		branch = append(branch, bson.E{Key: elem.Key, Value: bson.D{{Key: operator, Value: last[i].Value}}})
+		branches = append(branches, branch)
+	}
+
+	after := bson.D{{Key: "$or", Value: branches}}
+	if len(branches) == 1 {
+		after = branches[0].(bson.D)
+	}
+	if len(filter) > 0 {
+		return bson.D{{Key: "$and", Value: bson.A{filter, after}}}
+	}
+	return after
+}
+
+func descending(direction interface{}) bool {
+	switch value := direction.(type) {
+	case int32:
+		return value < 0
+	case int64:
+		return value < 0
+	case float64:
+		return value < 0
+	}
+	return false
+}
+
+// Sort key values of a document, its position in the extraction
+func sortPosition(doc bson.M, sort bson.D) (bson.D, error) {
+	position := make(bson.D, 0, len(sort))
+	for _, elem := range sort {
+		value, found := documentValue(doc, elem.Key)
+		if !found {
+			return nil, fmt.Errorf("document without %s, sort keys must be projected and set in every document", elem.Key)
+		}
+		position = append(position, bson.E{Key: elem.Key, Value: value})
+	}
+	return position, nil
+}
+
+// Value at a dotted path of a document
+func documentValue(doc bson.M, path string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		var found bool
+		switch current := value.(type) {
+		case bson.M:
+			value, found = current[key]
+		case bson.D:
+			for _, elem := range current {
+				if elem.Key == key {
+					value, found = elem.Value, true
+					break
+				}
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Sets the dumper hooks keeping checkpoint.json up to date: the position of every batch is recorded as it is
+// received, and saved once its chunk and every chunk before were written. A failed chunk stops the checkpoint
+// and the extraction, a resumed run starts over from the chunk that failed.
+func trackCheckpoint(opts *files.Options, checkpoint *files.Checkpoint, sort bson.D) *resumeCheckpoint {
+	tracker := &resumeCheckpoint{
+		next:   checkpoint.LastChunkId() + 1,
+		done:   map[int64]files.ManifestChunk{},
+		chunks: checkpoint.Chunks,
+		stop:   func() {},
+		save: func(position any, chunks []files.ManifestChunk) error {
+			last, err := bson.MarshalExtJSON(position, true, false)
+			if err != nil {
+				return err
+			}
+			checkpoint.Last = string(last)
+			checkpoint.Chunks = chunks
+			return checkpoint.Write(outputPath)
+		},
+	}
+
+	opts.ChunkOffset = checkpoint.LastChunkId()
+	opts.OnChunk = tracker.written
+	opts.OnReceive = func(chunkId int64, batch []*bson.M) {
+		var position bson.D
+		var err error
+		if len(batch) == 0 {
+			err = fmt.Errorf("chunk %d is empty", chunkId)
+		} else {
+			position, err = sortPosition(*batch[len(batch)-1], sort)
+		}
+		if err != nil {
+			tracker.mu.Lock()
+			tracker.fail(fmt.Errorf("error saving position: %w", err))
+			tracker.mu.Unlock()
+		}
+		tracker.sent(position)
+	}
+	return tracker
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"testing"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/farovictor/MongoDbExtractor/src/files"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAfterFilter(t *testing.T) {
+	sort := bson.D{{Key: "created", Value: int32(-1)}, {Key: "_id", Value: int32(1)}}
+	last := bson.D{{Key: "created", Value: int32(7)}, {Key: "_id", Value: "k"}}
+	filter := afterFilter(bson.D{{Key: "status", Value: "active"}}, sort, last)
+	data, _ := bson.MarshalExtJSON(filter, false, false)
+	expected := `{"$and":[{"status":"active"},{"$or":[{"created":{"$lt":7}},{"created":7,"_id":{"$gt":"k"}}]}]}`
+	if string(data) != expected {
+		t.Errorf("Expected %s, got %s", expected, data)
+	}
+
+	// Default sort only resumes on _id
+	filter = afterFilter(nil, bson.D{{Key: "_id", Value: 1}}, bson.D{{Key: "_id", Value: int32(3)}})
+	data, _ = bson.MarshalExtJSON(filter, false, false)
+	if string(data) != `{"_id":{"$gt":3}}` {
+		t.Errorf("Unexpected filter: %s", data)
+	}
+}
+
+func TestValidateCheckpoint(t *testing.T) {
+	defer resetQueryFlags()
+	defer func() {
+		checkpointing, resume, outputFormat, outputFilePrefix = false, false, constants.FormatJson, constants.MappingDefault
+	}()
+
+	resume, outputFormat, outputFilePrefix = true, constants.FormatNdjson, "{mapping}_{run_id}_{chunk_id}"
+	sortBy = `{"created":-1}`
+	if err := validateCheckpoint(); err != nil || !checkpointing {
+		t.Fatalf("Expected --resume to checkpoint the run: %v", err)
+	}
+	sort, _ := checkpointSort()
+	if len(sort) != 2 || sort[1].Key != "_id" {
+		t.Errorf("Expected _id to follow --sort, got %v", sort)
+	}
+
+	for name, set := range map[string]func(){
+		"limit":     func() { limit = 10 },
+		"worker_id": func() { outputFilePrefix = "{mapping}_{worker_id}_{chunk_id}" },
+		"chunk_id":  func() { outputFilePrefix = "{mapping}_{run_id}" },
+		"bson":      func() { outputFormat = constants.FormatBson },
+		"meta sort": func() { sortBy = `{"score":{"$meta":"textScore"}}` },
+	} {
+		resetQueryFlags()
+		outputFormat, outputFilePrefix = constants.FormatNdjson, "{mapping}_{chunk_id}"
+		set()
+		if err := validateCheckpoint(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSortPosition(t *testing.T) {
+	sort := bson.D{{Key: "meta.created", Value: 1}, {Key: "_id", Value: 1}}
+	position, err := sortPosition(bson.M{"_id": "a", "meta": bson.M{"created": int32(4)}}, sort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position[0].Value != int32(4) || position[1].Value != "a" {
+		t.Errorf("Unexpected position %v", position)
+	}
+	if _, err := sortPosition(bson.M{"_id": "a"}, sort); err == nil {
+		t.Error("Expected an error for a document without sort key")
+	}
+}
+
+func TestTrackCheckpoint(t *testing.T) {
+	defer func(path string) { outputPath = path }(outputPath)
+	outputPath = t.TempDir()
+
+	checkpoint := &files.Checkpoint{Chunks: []files.ManifestChunk{{ChunkId: 1, Documents: 2}}}
+	opts := files.Options{}
+	tracker := trackCheckpoint(&opts, checkpoint, bson.D{{Key: "_id", Value: 1}})
+	if opts.ChunkOffset != 1 {
+		t.Fatalf("Expected new chunks to follow chunk 1, got offset %d", opts.ChunkOffset)
+	}
+
+	opts.OnReceive(2, []*bson.M{{"_id": int32(3)}, {"_id": int32(4)}})
+	opts.OnReceive(3, []*bson.M{{"_id": int32(5)}})
+	opts.OnChunk(files.ManifestChunk{ChunkId: 3, Documents: 1})
+	saved, err := files.ReadCheckpoint(outputPath)
+	if err != nil || saved != nil {
+		t.Fatalf("Expected no checkpoint before chunk 2 is written, got %v (%v)", saved, err)
+	}
+
+	opts.OnChunk(files.ManifestChunk{ChunkId: 2, Documents: 2})
+	saved, err = files.ReadCheckpoint(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Last != `{"_id":{"$numberInt":"5"}}` || saved.LastChunkId() != 3 || len(saved.Chunks) != 3 {
+		t.Errorf("Unexpected checkpoint: %s after %v", saved.Last, saved.Chunks)
+	}
+	if tracker.err != nil {
+		t.Error(tracker.err)
+	}
+}
+
+func TestValidateOutputPrefix(t *testing.T) {
+	defer func() { outputFilePrefix = constants.MappingDefault }()
+
+	for prefix, chunked := range map[string]bool{
+		"users":                 true,
+		"{mapping}_{chunk_id}":  true,
+		"{mapping}_{date}":      false,
+		"{mapping}_{worker_id}": false,
+	} {
+		outputFilePrefix = prefix
+		if err := validateOutputPrefix(chunked); err != nil {
+			t.Errorf("%s: unexpected error: %v", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"{mapping}_{date}", "{mapping}_{worker_id}", "{mapping}_{unknown}"} {
+		outputFilePrefix = prefix
+		if err := validateOutputPrefix(true); err == nil {
+			t.Errorf("%s: expected an error", prefix)
+		}
+	}
+}
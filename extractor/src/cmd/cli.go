@@ -3,8 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/farovictor/MongoDbExtractor/src/files"
 	logger "github.com/farovictor/MongoDbExtractor/src/logging"
 	"github.com/spf13/cobra"
 )
@@ -24,8 +26,52 @@ var (
 	query              string
 	collectionName     string
 	numConcurrentFiles int32
+	outputFormat       string
+	csvColumns         []string
+	csvArrayMode       string
+	csvArraySeparator  string
+	schemaFile         string
+	schemaSampleSize   int32
+	rowGroupSize       int64
+	avroCodec          string
+	compressCodec      string
+	compressLevel      int
+	archivePath        string
+	gzipArchive        bool
+	projection         string
+	sortBy             string
+	limit              int64
+	skip               int64
+	hint               string
+	collationSpec      string
+	maxTime            time.Duration
+	comment            string
+	pipelineSpec       string
+	pipelineFile       string
+	mappingsDir        string
+	incrementalField   string
+	stateFile          string
+	stateCollection    string
+	watchCluster       bool
+	operationTypes     []string
+	fullDocument       string
+	fullDocumentBefore string
+	rollInterval       time.Duration
+	tailField          string
+	partitions         int32
+	partitionKey       string
+	splitMethod        string
+	checkpointing      bool
+	resume             bool
+	pageSize           int32
 )
 
+// Help text for --output-prefix flag
+const outputPrefixUsage = "Output file name template, variables: {mapping}, {chunk_id}, {worker_id}, {run_id}, {date}, {time}, {collection} and {database} (a plain prefix gets _{chunk_id} appended)"
+
+// Help text for --output-format flag
+const outputFormatUsage = "Output format: json, canonical, relaxed (extended json), ndjson, ndjson-canonical (one document per line), csv, parquet, avro, arrow (single feather file) or bson (mongodump layout)"
+
 // Root Command (does nothing, only prints nice things)
 var rootCmd = &cobra.Command{
 	Short:   "This project aims to support mongodb extractors/loaders",
@@ -54,6 +100,22 @@ var extractBatchesCmd = &cobra.Command{
 	Run:     extractBatches,
 }
 
+// Watch Command
+var watchCmd = &cobra.Command{
+	Use:     "watch",
+	Version: rootCmd.Version,
+	Short:   "This command writes change events of a collection, database or cluster into rolling files",
+	Run:     watchChanges,
+}
+
+// Tail Command
+var tailCmd = &cobra.Command{
+	Use:     "tail",
+	Version: rootCmd.Version,
+	Short:   "This command follows a capped collection with a tailable cursor and writes new documents into rolling files",
+	Run:     tailCollection,
+}
+
 // Ping Command
 var pingCmd = &cobra.Command{
 	Use:     "ping",
@@ -87,24 +149,122 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&appName, "app-name", "a", "", "App name")
 	rootCmd.MarkFlagsRequiredTogether("conn-uri", "db-name", "app-name")
 	// Extract command flags setup
-	extractCmd.PersistentFlags().StringVarP(&mapping, "mapping", "m", "", "Mapping name to use for extraction")
-	extractCmd.PersistentFlags().StringVarP(&outputFilePrefix, "output-prefix", "o", constants.MappingDefault, "Output filename prefix")
+	extractCmd.PersistentFlags().StringVarP(&mapping, "mapping", "m", "", "Mapping to use for extraction: name of a mapping file in --mappings-dir, path to a mapping file or a plain name")
+	extractCmd.PersistentFlags().StringVar(&mappingsDir, "mappings-dir", "mappings", "Folder holding mapping files (<mapping>.yaml, .yml or .json)")
+	extractCmd.PersistentFlags().StringVarP(&outputFilePrefix, "output-prefix", "o", constants.MappingDefault, outputPrefixUsage)
 	extractCmd.PersistentFlags().StringVarP(&outputPath, "output-path", "p", ".", "Output folder path")
 	extractCmd.PersistentFlags().StringVarP(&query, "query", "q", "", "WHERE clause to attach to query in a valid mongodb syntax")
-	extractCmd.PersistentFlags().Int32VarP(&batchSize, "chunk-size", "s", 100, "Chunk size for exported files")
+	extractCmd.PersistentFlags().StringVar(&incrementalField, "incremental-field", "", "Extracts only documents above the watermark saved by the previous run, on a monotonically increasing field (e.g.: _id, updatedAt)")
+	extractCmd.PersistentFlags().StringVar(&stateFile, "state-file", "extract_state.json", "Json file holding incremental watermarks")
+	extractCmd.PersistentFlags().StringVar(&stateCollection, "state-collection", "", "Collection holding incremental watermarks (instead of --state-file)")
+	extractCmd.MarkFlagsMutuallyExclusive("state-file", "state-collection")
+	extractCmd.PersistentFlags().StringVar(&projection, "projection", "", "Fields to return, as an extended json document (e.g.: {\"name\":1,\"_id\":0})")
+	extractCmd.PersistentFlags().StringVar(&sortBy, "sort", "", "Sort order, as an extended json document (e.g.: {\"created\":-1})")
+	extractCmd.PersistentFlags().Int64Var(&limit, "limit", 0, "Max number of documents to extract (0 extracts all)")
+	extractCmd.PersistentFlags().Int64Var(&skip, "skip", 0, "Number of documents to skip")
+	extractCmd.PersistentFlags().StringVar(&hint, "hint", "", "Index to use, as an index name or an extended json index specification")
+	extractCmd.PersistentFlags().StringVar(&collationSpec, "collation", "", "Collation, as an extended json document (e.g.: {\"locale\":\"en\",\"strength\":2})")
+	extractCmd.PersistentFlags().DurationVar(&maxTime, "max-time", 0, "Max server time of the query (e.g.: 30s, 5m)")
+	extractCmd.PersistentFlags().StringVar(&comment, "comment", "", "Comment attached to the query (shows in profiler and logs)")
+	extractCmd.PersistentFlags().Int32VarP(&batchSize, "chunk-size", "s", 100, "Number of documents read and written at a time into the single output file")
 	extractCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "Specify the collection you want to check")
-	extractCmd.MarkFlagRequired("collection")
+	extractCmd.PersistentFlags().StringVarP(&outputFormat, "output-format", "f", constants.FormatJson, outputFormatUsage)
+	extractCmd.PersistentFlags().StringSliceVar(&csvColumns, "csv-columns", nil, "Comma separated list of columns for csv output, nested fields in dotted notation (inferred when empty)")
+	extractCmd.PersistentFlags().StringVar(&csvArrayMode, "csv-array-mode", constants.ArrayModeJson, "How csv output renders arrays: json, join or expand")
+	extractCmd.PersistentFlags().StringVar(&csvArraySeparator, "csv-array-separator", "|", "Separator used by join array mode")
+	extractCmd.PersistentFlags().StringVar(&schemaFile, "schema-file", "", "Json file mapping columns to types for parquet and arrow outputs (e.g.: {\"_id\":\"objectid\",\"amount\":\"decimal(38,2)\"})")
+	extractCmd.PersistentFlags().Int32Var(&schemaSampleSize, "schema-sample-size", 1000, "Number of sampled documents used to infer parquet, avro and arrow schemas and csv columns (0 uses the first chunk)")
+	extractCmd.PersistentFlags().Int64Var(&rowGroupSize, "row-group-size", files.DefaultRowGroupSize, "Max number of rows per parquet row group")
+	extractCmd.PersistentFlags().StringVar(&avroCodec, "avro-codec", files.AvroCodecNull, "Avro block codec: null, deflate or snappy")
+	extractCmd.PersistentFlags().StringVar(&compressCodec, "compress", "", "Compresses output files: gzip (.gz), zstd (.zst) or snappy (.sz)")
+	extractCmd.PersistentFlags().IntVar(&compressLevel, "compress-level", 0, "Compression level: 1-9 for gzip, 1-22 for zstd (0 uses codec default)")
 	extractCmd.MarkFlagRequired("mapping")
 	// Extract Batches
-	extractBatchesCmd.PersistentFlags().StringVarP(&mapping, "mapping", "m", "", "Mapping name to use for extraction")
-	extractBatchesCmd.PersistentFlags().StringVarP(&outputFilePrefix, "output-prefix", "o", constants.MappingDefault, "Output filename prefix")
+	extractBatchesCmd.PersistentFlags().StringVarP(&mapping, "mapping", "m", "", "Mapping to use for extraction: name of a mapping file in --mappings-dir, path to a mapping file or a plain name")
+	extractBatchesCmd.PersistentFlags().StringVar(&mappingsDir, "mappings-dir", "mappings", "Folder holding mapping files (<mapping>.yaml, .yml or .json)")
+	extractBatchesCmd.PersistentFlags().StringVarP(&outputFilePrefix, "output-prefix", "o", constants.MappingDefault, outputPrefixUsage)
 	extractBatchesCmd.PersistentFlags().StringVarP(&outputPath, "output-path", "p", ".", "Output folder path")
 	extractBatchesCmd.PersistentFlags().StringVarP(&query, "query", "q", "", "WHERE clause to attach to query in a valid mongodb syntax")
+	extractBatchesCmd.PersistentFlags().StringVar(&incrementalField, "incremental-field", "", "Extracts only documents above the watermark saved by the previous run, on a monotonically increasing field (e.g.: _id, updatedAt)")
+	extractBatchesCmd.PersistentFlags().StringVar(&stateFile, "state-file", "extract_state.json", "Json file holding incremental watermarks")
+	extractBatchesCmd.PersistentFlags().StringVar(&stateCollection, "state-collection", "", "Collection holding incremental watermarks (instead of --state-file)")
+	extractBatchesCmd.MarkFlagsMutuallyExclusive("state-file", "state-collection")
+	extractBatchesCmd.PersistentFlags().StringVar(&projection, "projection", "", "Fields to return, as an extended json document (e.g.: {\"name\":1,\"_id\":0})")
+	extractBatchesCmd.PersistentFlags().StringVar(&sortBy, "sort", "", "Sort order, as an extended json document (e.g.: {\"created\":-1})")
+	extractBatchesCmd.PersistentFlags().Int64Var(&limit, "limit", 0, "Max number of documents to extract (0 extracts all)")
+	extractBatchesCmd.PersistentFlags().Int64Var(&skip, "skip", 0, "Number of documents to skip")
+	extractBatchesCmd.PersistentFlags().StringVar(&hint, "hint", "", "Index to use, as an index name or an extended json index specification")
+	extractBatchesCmd.PersistentFlags().StringVar(&collationSpec, "collation", "", "Collation, as an extended json document (e.g.: {\"locale\":\"en\",\"strength\":2})")
+	extractBatchesCmd.PersistentFlags().DurationVar(&maxTime, "max-time", 0, "Max server time of the query (e.g.: 30s, 5m)")
+	extractBatchesCmd.PersistentFlags().StringVar(&comment, "comment", "", "Comment attached to the query (shows in profiler and logs)")
 	extractBatchesCmd.PersistentFlags().Int32VarP(&batchSize, "chunk-size", "s", 100, "Chunk size for exported files")
 	extractBatchesCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "Specify the collection you want to check")
 	extractBatchesCmd.PersistentFlags().Int32VarP(&numConcurrentFiles, "num-concurrent-files", "n", 50, "Number of concurrent files to dump")
-	extractBatchesCmd.MarkFlagRequired("collection")
+	extractBatchesCmd.PersistentFlags().StringVarP(&outputFormat, "output-format", "f", constants.FormatJson, outputFormatUsage)
+	extractBatchesCmd.PersistentFlags().StringSliceVar(&csvColumns, "csv-columns", nil, "Comma separated list of columns for csv output, nested fields in dotted notation (inferred when empty)")
+	extractBatchesCmd.PersistentFlags().StringVar(&csvArrayMode, "csv-array-mode", constants.ArrayModeJson, "How csv output renders arrays: json, join or expand")
+	extractBatchesCmd.PersistentFlags().StringVar(&csvArraySeparator, "csv-array-separator", "|", "Separator used by join array mode")
+	extractBatchesCmd.PersistentFlags().StringVar(&schemaFile, "schema-file", "", "Json file mapping columns to types for parquet and arrow outputs (e.g.: {\"_id\":\"objectid\",\"amount\":\"decimal(38,2)\"})")
+	extractBatchesCmd.PersistentFlags().Int32Var(&schemaSampleSize, "schema-sample-size", 1000, "Number of sampled documents used to infer parquet, avro and arrow schemas and csv columns (0 uses the first chunk)")
+	extractBatchesCmd.PersistentFlags().Int64Var(&rowGroupSize, "row-group-size", files.DefaultRowGroupSize, "Max number of rows per parquet row group")
+	extractBatchesCmd.PersistentFlags().StringVar(&avroCodec, "avro-codec", files.AvroCodecNull, "Avro block codec: null, deflate or snappy")
+	extractBatchesCmd.PersistentFlags().StringVar(&compressCodec, "compress", "", "Compresses output files: gzip (.gz), zstd (.zst) or snappy (.sz)")
+	extractBatchesCmd.PersistentFlags().IntVar(&compressLevel, "compress-level", 0, "Compression level: 1-9 for gzip, 1-22 for zstd (0 uses codec default)")
+	extractBatchesCmd.PersistentFlags().StringVar(&pipelineSpec, "pipeline", "", "Runs an aggregation pipeline instead of a find, as an extended json array of stages")
+	extractBatchesCmd.PersistentFlags().StringVar(&pipelineFile, "pipeline-file", "", "Json file holding the aggregation pipeline to run instead of a find")
+	extractBatchesCmd.MarkFlagsMutuallyExclusive("pipeline", "pipeline-file")
+	extractBatchesCmd.PersistentFlags().Int32Var(&partitions, "partitions", 0, "Splits the collection into this number of ranges of --partition-key, each one read by its own cursor (0 reads with a single cursor)")
+	extractBatchesCmd.PersistentFlags().StringVar(&partitionKey, "partition-key", "_id", "Field ranges are computed on (an indexed field holding values of a single type)")
+	extractBatchesCmd.PersistentFlags().StringVar(&splitMethod, "split-method", "sample", "How ranges are computed: sample ($sample), bucket-auto ($bucketAuto) or split-vector (splitVector command)")
+	extractBatchesCmd.PersistentFlags().Int32Var(&pageSize, "page-size", 0, "Reads documents by pages of this size in _id order, each one a new query for _id above the last one read, so no cursor stays open (0 reads with a single cursor)")
+	extractBatchesCmd.PersistentFlags().BoolVar(&checkpointing, "checkpoint", false, "Reads documents in --sort order (then _id) and saves progress into checkpoint.json in --output-path after every chunk")
+	extractBatchesCmd.PersistentFlags().BoolVar(&resume, "resume", false, "Goes on with the run saved in checkpoint.json (same run id and chunk numbering), starts a checkpointed run when there is none")
+	for _, flag := range []string{"query", "projection", "sort", "limit", "skip"} {
+		extractBatchesCmd.MarkFlagsMutuallyExclusive("pipeline", flag)
+		extractBatchesCmd.MarkFlagsMutuallyExclusive("pipeline-file", flag)
+	}
+	extractBatchesCmd.PersistentFlags().StringVar(&archivePath, "archive", "", "Writes a single mongodump archive to this path (stdout when no path is given)")
+	extractBatchesCmd.PersistentFlags().Lookup("archive").NoOptDefVal = "-"
+	extractBatchesCmd.PersistentFlags().BoolVar(&gzipArchive, "gzip", false, "Compresses the archive with gzip (same as --compress gzip)")
 	extractBatchesCmd.MarkFlagRequired("mapping")
+	// Watch
+	watchCmd.PersistentFlags().StringVarP(&mapping, "mapping", "m", "", "Name of the change stream, used in file names and as key of its resume token")
+	watchCmd.PersistentFlags().StringVarP(&outputFilePrefix, "output-prefix", "o", "{mapping}_{run_id}_{chunk_id}", outputPrefixUsage)
+	watchCmd.PersistentFlags().StringVarP(&outputPath, "output-path", "p", ".", "Output folder path")
+	watchCmd.PersistentFlags().StringVarP(&outputFormat, "output-format", "f", constants.FormatNdjsonCanonical, "Output format: canonical (extended json) or ndjson-canonical (one event per line)")
+	watchCmd.PersistentFlags().StringVar(&compressCodec, "compress", "", "Compresses output files: gzip (.gz), zstd (.zst) or snappy (.sz)")
+	watchCmd.PersistentFlags().IntVar(&compressLevel, "compress-level", 0, "Compression level: 1-9 for gzip, 1-22 for zstd (0 uses codec default)")
+	watchCmd.PersistentFlags().Int32VarP(&batchSize, "chunk-size", "s", 1000, "Max number of events per file")
+	watchCmd.PersistentFlags().DurationVar(&rollInterval, "roll-interval", time.Minute, "Max time events wait before being written, a file is rolled when it elapses even if not full")
+	watchCmd.PersistentFlags().Int32VarP(&numConcurrentFiles, "num-concurrent-files", "n", 4, "Number of concurrent files to dump")
+	watchCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "Collection to watch (the whole database is watched when empty)")
+	watchCmd.PersistentFlags().BoolVar(&watchCluster, "cluster", false, "Watches every database of the deployment")
+	watchCmd.MarkFlagsMutuallyExclusive("collection", "cluster")
+	watchCmd.PersistentFlags().StringSliceVar(&operationTypes, "operation-types", []string{"insert", "update", "replace", "delete"}, "Comma separated list of change events to write")
+	watchCmd.PersistentFlags().StringVar(&fullDocument, "full-document", "", "Full document of update events: updateLookup, whenAvailable or required (none when empty)")
+	watchCmd.PersistentFlags().StringVar(&fullDocumentBefore, "full-document-before-change", "", "Document before update, replace and delete events: whenAvailable or required (none when empty, requires pre-images)")
+	watchCmd.PersistentFlags().StringVar(&stateFile, "state-file", "extract_state.json", "Json file holding resume tokens")
+	watchCmd.PersistentFlags().StringVar(&stateCollection, "state-collection", "", "Collection holding resume tokens (instead of --state-file)")
+	watchCmd.MarkFlagsMutuallyExclusive("state-file", "state-collection")
+	watchCmd.MarkFlagRequired("mapping")
+	// Tail
+	tailCmd.PersistentFlags().StringVarP(&mapping, "mapping", "m", "", "Name of the tailed collection, used in file names and as key of its last position")
+	tailCmd.PersistentFlags().StringVarP(&outputFilePrefix, "output-prefix", "o", "{mapping}_{run_id}_{chunk_id}", outputPrefixUsage)
+	tailCmd.PersistentFlags().StringVarP(&outputPath, "output-path", "p", ".", "Output folder path")
+	tailCmd.PersistentFlags().StringVarP(&outputFormat, "output-format", "f", constants.FormatNdjson, "Output format: json, canonical, relaxed (extended json), ndjson or ndjson-canonical (one document per line)")
+	tailCmd.PersistentFlags().StringVar(&compressCodec, "compress", "", "Compresses output files: gzip (.gz), zstd (.zst) or snappy (.sz)")
+	tailCmd.PersistentFlags().IntVar(&compressLevel, "compress-level", 0, "Compression level: 1-9 for gzip, 1-22 for zstd (0 uses codec default)")
+	tailCmd.PersistentFlags().Int32VarP(&batchSize, "chunk-size", "s", 1000, "Max number of documents per file")
+	tailCmd.PersistentFlags().DurationVar(&rollInterval, "roll-interval", time.Minute, "Max time documents wait before being written, a file is rolled when it elapses even if not full")
+	tailCmd.PersistentFlags().Int32VarP(&numConcurrentFiles, "num-concurrent-files", "n", 4, "Number of concurrent files to dump")
+	tailCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "Capped collection to tail")
+	tailCmd.PersistentFlags().StringVarP(&query, "query", "q", "", "WHERE clause to attach to query in a valid mongodb syntax")
+	tailCmd.PersistentFlags().StringVar(&tailField, "tail-field", "_id", "Field growing with insertions, its last value written is saved to restart after it")
+	tailCmd.PersistentFlags().StringVar(&stateFile, "state-file", "extract_state.json", "Json file holding last positions")
+	tailCmd.PersistentFlags().StringVar(&stateCollection, "state-collection", "", "Collection holding last positions (instead of --state-file)")
+	tailCmd.MarkFlagsMutuallyExclusive("state-file", "state-collection")
+	tailCmd.MarkFlagRequired("mapping")
+	tailCmd.MarkFlagRequired("collection")
 	// Collection exists command flags setup
 	collExistsCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "Specify the collection you want to check")
 	collExistsCmd.MarkFlagRequired("collection")
@@ -112,6 +272,8 @@ func init() {
 	// Attaching commands to root
 	rootCmd.AddCommand(extractCmd)
 	rootCmd.AddCommand(extractBatchesCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(tailCmd)
 	rootCmd.AddCommand(pingCmd)
 	rootCmd.AddCommand(collExistsCmd)
 }
@@ -1,15 +1,17 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"os"
 
 	constants "github.com/farovictor/MongoDbExtractor/src/constants"
 	"github.com/farovictor/MongoDbExtractor/src/files"
 	logger "github.com/farovictor/MongoDbExtractor/src/logging"
 	mongo "github.com/farovictor/MongodbDriver"
+	driverLogger "github.com/farovictor/MongodbDriver/logging"
 	"github.com/spf13/cobra"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Execution logic for ping command
@@ -27,10 +29,47 @@ func pingExecute(cmd *cobra.Command, args []string) {
 	}
 }
 
+// Values shared by every file written during this run (file name templates)
+var run files.RunInfo
+
+// Validates output file name template and starts a new run (chunked when output is split in several files)
+func startRun(chunked bool) {
+	if err := validateOutputPrefix(chunked); err != nil {
+		logger.ErrorLogger.Fatalln("Error handling output-prefix argument:", err)
+	}
+	run = files.NewRunInfo(collectionName, dbName)
+	logger.InfoLogger.Println("Run id:", run.RunId)
+}
+
+// Checks output file name template: files of a chunked output are told apart by their chunk id only
+func validateOutputPrefix(chunked bool) error {
+	if err := files.ValidateTemplate(outputFilePrefix); err != nil {
+		return err
+	}
+	if chunked && !files.TemplateUses(outputFilePrefix, files.VarChunkId) {
+		return fmt.Errorf("--output-prefix needs {%s} when output is split in several files", files.VarChunkId)
+	}
+	return nil
+}
+
 // Execution logic for extract command
 func extractMapping(cmd *cobra.Command, args []string) {
 	if mapping != "" && mapping != constants.MappingDefault {
 		logger.InfoLogger.Println("Mapping:", mapping)
+		applyMapping(cmd)
+		if batchSize <= 0 {
+			logger.ErrorLogger.Fatalln("--chunk-size must be positive")
+		}
+		if err := validateIncremental(); err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		startRun(false)
+
+		options, err := findOptions()
+		if err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		logger.InfoLogger.Println("Options set!")
 
 		handler, disconnect := mongo.NewConnectionHandler(connUri, dbName, appName)
 		defer disconnect()
@@ -42,17 +81,49 @@ func extractMapping(cmd *cobra.Command, args []string) {
 		if err := bson.UnmarshalExtJSON([]byte(query), true, &filter); err != nil {
 			logger.ErrorLogger.Fatalln("Error handling query argument:", err)
 		}
+		filter, _, commitWatermark := startIncremental(handler, coll, filter)
 		logger.InfoLogger.Println("Filter retrieved", filter)
 
-		options := options.FindOptions{
-			BatchSize: &batchSize,
-		}
-		logger.InfoLogger.Println("Options set!")
-
 		logger.InfoLogger.Println("Processing record")
-		if err := handler.ExtractResults(mapping, outputFilePrefix, outputPath, files.DumpToJsonFile, coll, filter, &options); err != nil {
-			logger.ErrorLogger.Fatalln(err)
+		if outputFormat == constants.FormatBson {
+			dumper := newBsonDumper(handler)
+			err := handler.ExtractRawResults(mapping, outputFilePrefix, outputPath, batchSize, dumper.DumpToFile, coll, filter, options)
+			if closeErr := dumper.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				logger.ErrorLogger.Fatalln(err)
+			}
+		} else if outputFormat == constants.FormatArrow {
+			dumper := newArrowDumper(handler, source{coll: coll, filter: filter, find: options})
+			err := handler.ExtractResults(mapping, outputFilePrefix, outputPath, batchSize, dumper.DumpToFile, coll, filter, options)
+			if closeErr := dumper.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				logger.ErrorLogger.Fatalln(err)
+			}
+		} else {
+			// Documents are encoded into a single file as they come from the cursor
+			dumper := newDumper(handler, source{coll: coll, filter: filter, find: options}, dumperOptions())
+			writer, err := dumper.NewFileWriter(mapping, outputFilePrefix, outputPath)
+			if err != nil {
+				logger.ErrorLogger.Fatalln("Error creating output file:", err)
+			}
+			if dumper.Raw() {
+				err = handler.ExtractRawResults(mapping, outputFilePrefix, outputPath, batchSize, writer.DumpRawToFile, coll, filter, options)
+			} else {
+				err = handler.ExtractResults(mapping, outputFilePrefix, outputPath, batchSize, writer.DumpToFile, coll, filter, options)
+			}
+			if err != nil {
+				writer.Abort()
+				logger.ErrorLogger.Fatalln(err)
+			}
+			if err := writer.Close(); err != nil {
+				logger.ErrorLogger.Fatalln(err)
+			}
 		}
+		commitWatermark()
 	} else {
 		logger.ErrorLogger.Fatalln("Please set a valid mapping")
 	}
@@ -60,30 +131,171 @@ func extractMapping(cmd *cobra.Command, args []string) {
 
 // Execution logic for extract-batches command
 func extractBatches(cmd *cobra.Command, args []string) {
+	// Stdout is taken by the archive stream
+	if archivePath == "-" {
+		logger.SetOutput(os.Stderr)
+		driverLogger.SetOutput(os.Stderr)
+	}
+
 	if mapping != "" && mapping != constants.MappingDefault {
 		logger.InfoLogger.Println("Mapping:", mapping)
+		applyMapping(cmd)
+		if archivePath != "" && cmd.Flags().Changed("output-format") && outputFormat != constants.FormatBson {
+			logger.ErrorLogger.Fatalln("--archive can only be used with bson output format")
+		}
+		if err := validatePartitions(); err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		if err := validatePaging(); err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		if err := validateIncremental(); err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		if err := validateCheckpoint(); err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		checkpoint := loadCheckpoint()
+		if checkpoint == nil {
+			startRun(archivePath == "" && outputFormat != constants.FormatBson && outputFormat != constants.FormatArrow)
+		}
+		pipeline, err := aggregatePipeline()
+		if err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		src := source{pipeline: pipeline}
+		if pipeline != nil {
+			src.aggregate, err = aggregateOptions()
+		} else {
+			src.find, err = findOptions()
+		}
+		if err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		logger.InfoLogger.Println("Options set!")
 
 		handler, disconnect := mongo.NewConnectionHandler(connUri, dbName, appName)
 		defer disconnect()
 
-		coll := handler.GetCollection(collectionName)
+		src.coll = handler.GetCollection(collectionName)
 		logger.InfoLogger.Println("Collection retrieved")
 
-		var filter bson.D
-		if err := bson.UnmarshalExtJSON([]byte(query), true, &filter); err != nil {
-			logger.ErrorLogger.Fatalln("Error handling query argument:", err)
+		commitWatermark := func() {}
+		if pipeline != nil {
+			logger.InfoLogger.Printf("Pipeline retrieved, %d stages", len(pipeline))
+		} else {
+			if checkpoint != nil {
+				src.filter, commitWatermark = resumeFilter(handler, checkpoint)
+			} else {
+				if err := bson.UnmarshalExtJSON([]byte(query), true, &src.filter); err != nil {
+					logger.ErrorLogger.Fatalln("Error handling query argument:", err)
+				}
+				var to any
+				src.filter, to, commitWatermark = startIncremental(handler, src.coll, src.filter)
+				if checkpointing {
+					checkpoint = newCheckpoint(src.filter, to)
+				}
+			}
+			logger.InfoLogger.Println("Filter retrieved", src.filter)
+			src.partitions = partitionFilters(handler, src.coll, src.filter)
 		}
-		logger.InfoLogger.Println("Filter retrieved", filter)
 
-		options := options.FindOptions{
-			BatchSize: &batchSize,
+		// Manifest describes every file written, _SUCCESS is only written when every chunk succeeded
+		if err := files.RemoveSuccessMarker(outputPath); err != nil {
+			logger.ErrorLogger.Fatalln("Error removing previous success marker:", err)
+		}
+		manifestFormat := outputFormat
+		if archivePath != "" {
+			manifestFormat = "archive"
+		}
+		manifest := files.NewManifest(run, mapping, query, manifestFormat, outputCompression())
+		if pipeline != nil {
+			manifest.Pipeline = pipelineText()
+		} else if incrementalField != "" {
+			// Filter actually run, watermark range included
+			if filterText, err := bson.MarshalExtJSON(src.filter, false, false); err == nil {
+				manifest.Query = string(filterText)
+			}
+		}
+
+		// Documents are read in sort order, a resumed run reads the ones after the last chunk written
+		var sort bson.D
+		if checkpoint != nil {
+			for _, chunk := range checkpoint.Chunks {
+				manifest.AddChunk(chunk)
+			}
+			sort, _ = checkpointSort()
+			src.find.SetSort(sort)
+			if checkpoint.Last != "" {
+				var last bson.D
+				if err := bson.UnmarshalExtJSON([]byte(checkpoint.Last), true, &last); err != nil || len(last) != len(sort) {
+					logger.ErrorLogger.Fatalln("Error reading checkpoint position:", checkpoint.Last, err)
+				}
+				src.filter = afterFilter(src.filter, sort, last)
+			}
+			if err := files.RemovePartialFiles(outputPath); err != nil {
+				logger.ErrorLogger.Fatalln("Error removing partial files:", err)
+			}
+			if err := checkpoint.Write(outputPath); err != nil {
+				logger.ErrorLogger.Fatalln("Error writing checkpoint:", err)
+			}
 		}
-		logger.InfoLogger.Println("Options set!")
 
 		logger.InfoLogger.Println("Processing record")
-		if err := handler.StreamingResults(mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, files.DumpStreams, coll, filter, &options); err != nil {
+		var tracker *resumeCheckpoint
+		if archivePath != "" || outputFormat == constants.FormatBson {
+			var dumper rawDumper
+			if archivePath != "" {
+				dumper = newArchiveWriter(handler)
+			} else {
+				dumper = newBsonDumper(handler)
+			}
+			err = src.streamRaw(handler, dumper.DumpStreams)
+			if closeErr := dumper.Close(); err == nil {
+				err = closeErr
+			}
+			manifest.AddChunk(dumper.Chunk())
+		} else if outputFormat == constants.FormatArrow {
+			dumper := newArrowDumper(handler, src)
+			err = src.stream(handler, dumper.DumpStreams)
+			if closeErr := dumper.Close(); err == nil {
+				err = closeErr
+			}
+			manifest.AddChunk(dumper.Chunk())
+		} else {
+			opts := dumperOptions()
+			opts.Manifest = manifest
+			if checkpoint != nil {
+				tracker = trackCheckpoint(&opts, checkpoint, sort)
+			}
+			dumper := newDumper(handler, src, opts)
+			if dumper.Raw() && pageSize == 0 {
+				// Documents are written without being decoded
+				err = src.streamRaw(handler, dumper.DumpRawStreams)
+			} else {
+				err = src.stream(handler, dumper.DumpStreams)
+			}
+		}
+
+		if writeErr := manifest.Write(outputPath, err); writeErr != nil {
+			logger.ErrorLogger.Println("Error writing manifest:", writeErr)
+		}
+		if err != nil {
 			logger.ErrorLogger.Fatalln(err)
 		}
+		if manifest.Failed() {
+			logger.ErrorLogger.Fatalf("%d chunks failed, check %s", manifest.FailedChunks, files.ManifestFile)
+		}
+		if tracker != nil && tracker.err != nil {
+			logger.WarningLogger.Println("Checkpoint was not kept up to date:", tracker.err)
+		}
+		commitWatermark()
+		if checkpoint != nil {
+			if err := files.RemoveCheckpoint(outputPath); err != nil {
+				logger.ErrorLogger.Fatalln("Error removing checkpoint:", err)
+			}
+		}
+		logger.InfoLogger.Printf("%d documents written into %d files", manifest.TotalDocuments, manifest.TotalChunks)
 		logger.InfoLogger.Println("record requested")
 	} else {
 		logger.ErrorLogger.Fatalln("Please set a valid mapping")
@@ -103,3 +315,126 @@ func collExistsExecute(cmd *cobra.Command, args []string) {
 		logger.InfoLogger.Printf("Collection %s exists? %v", collectionName, exist)
 	}
 }
+
+// Output settings taken from flags
+func dumperOptions() files.Options {
+	return files.Options{
+		Format:         outputFormat,
+		Columns:        csvColumns,
+		ArrayMode:      csvArrayMode,
+		ArraySeparator: csvArraySeparator,
+		SchemaFile:     schemaFile,
+		RowGroupSize:   rowGroupSize,
+		AvroCodec:      avroCodec,
+		Compression:    outputCompression(),
+		Run:            run,
+		Transform:      transform,
+		OnDroppedField: droppedField,
+	}
+}
+
+// Warns about a field left out of output files
+func droppedField(field string, reason string) {
+	logger.WarningLogger.Printf("Field %s is not written: %s", field, reason)
+}
+
+// Output compression taken from flags, --gzip is kept as a shortcut for --compress gzip
+func outputCompression() files.Compression {
+	codec := compressCodec
+	if gzipArchive {
+		if codec != "" && codec != constants.CompressGzip {
+			logger.ErrorLogger.Fatalln("--gzip cannot be used along with --compress", codec)
+		}
+		codec = constants.CompressGzip
+	}
+	return files.Compression{Codec: codec, Level: compressLevel}
+}
+
+// Builds the dumper out of output settings, sampling documents when output schema must be inferred
+func newDumper(handler *mongo.ConnectionHandler, src source, opts files.Options) *files.Dumper {
+	opts.SchemaSample = schemaSample(handler, src)
+
+	dumper, err := files.NewDumper(opts)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error handling output arguments:", err)
+	}
+	return dumper
+}
+
+// Samples documents used to infer output schema or csv columns (nil when format has no schema or it is set by flags)
+func schemaSample(handler *mongo.ConnectionHandler, src source) []*bson.M {
+	var inferSchema bool
+	switch outputFormat {
+	case constants.FormatParquet, constants.FormatArrow:
+		inferSchema = schemaFile == ""
+	case constants.FormatAvro:
+		inferSchema = true
+	case constants.FormatCsv:
+		inferSchema = len(csvColumns) == 0
+	}
+	if !inferSchema || schemaSampleSize <= 0 {
+		return nil
+	}
+
+	sample, err := src.sample(handler, schemaSampleSize)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error sampling documents:", err)
+	}
+	// Schema is inferred from documents as they are written
+	if err = transform.Apply(sample); err != nil {
+		logger.ErrorLogger.Fatalln("Error applying mapping to sampled documents:", err)
+	}
+	logger.InfoLogger.Printf("%d documents sampled to infer output schema", len(sample))
+	return sample
+}
+
+// Builds a single arrow file dumper, shared by every worker
+func newArrowDumper(handler *mongo.ConnectionHandler, src source) *files.ArrowDumper {
+	dumper, err := files.NewArrowDumper(outputPath, mapping, outputFilePrefix, schemaFile, schemaSample(handler, src), outputCompression(), run, transform, droppedField)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error creating arrow file:", err)
+	}
+	return dumper
+}
+
+// Dumpers fed with raw documents (bson and archive outputs)
+type rawDumper interface {
+	DumpStreams(ctx context.Context, dataChannel <-chan []bson.Raw, mapping string, filePrefix string, fileLocation string) error
+	Close() error
+	Chunk() files.ManifestChunk
+}
+
+// Builds a mongodump --archive like writer, prelude holds collection metadata (indexes and options)
+func newArchiveWriter(handler *mongo.ConnectionHandler) *files.ArchiveWriter {
+	metadata, err := handler.CollectionMetadata(collectionName)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error retrieving collection metadata:", err)
+	}
+	serverVersion, err := handler.ServerVersion()
+	if err != nil {
+		logger.WarningLogger.Println("Could not retrieve server version:", err)
+	}
+
+	writer, err := files.NewArchiveWriter(archivePath, outputCompression(), dbName, collectionName, metadata, serverVersion, "mongoextract "+Version)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error creating archive:", err)
+	}
+	return writer
+}
+
+// Builds a mongodump like dumper and writes collection metadata (indexes and options)
+func newBsonDumper(handler *mongo.ConnectionHandler) *files.BsonDumper {
+	dumper, err := files.NewBsonDumper(outputPath, dbName, collectionName, outputCompression())
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error creating bson file:", err)
+	}
+
+	metadata, err := handler.CollectionMetadata(collectionName)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error retrieving collection metadata:", err)
+	}
+	if err = dumper.WriteMetadata(metadata); err != nil {
+		logger.ErrorLogger.Fatalln("Error writing collection metadata:", err)
+	}
+	return dumper
+}
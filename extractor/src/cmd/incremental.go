@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	logger "github.com/farovictor/MongoDbExtractor/src/logging"
+	"github.com/farovictor/MongoDbExtractor/src/state"
+	mongo "github.com/farovictor/MongodbDriver"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+)
+
+// Watermark store taken from flags: state collection when set, state file otherwise
+func stateStore(handler *mongo.ConnectionHandler) state.Store {
+	if stateCollection != "" {
+		return state.NewCollectionStore(handler.GetCollection(stateCollection))
+	}
+	return state.NewFileStore(stateFile)
+}
+
+// Checks incremental flags: the watermark is the highest value matching the filter, so documents left out by
+// --limit or --skip would never be extracted by a later run
+func validateIncremental() error {
+	if incrementalField == "" {
+		return nil
+	}
+	if limit != 0 || skip != 0 {
+		return fmt.Errorf("--incremental-field cannot be used along with --limit or --skip")
+	}
+	return nil
+}
+
+// Restricts filter to documents above the watermark saved by the previous run, up to the highest
+// value found now (documents written during the extraction are left to the next run).
+// Returns the new filter, the new watermark (nil when nothing is to extract) and the function saving it, to call
+// once the extraction succeeded.
+func startIncremental(handler *mongo.ConnectionHandler, coll *mongodb.Collection, filter bson.D) (bson.D, any, func()) {
+	if incrementalField == "" {
+		return filter, nil, func() {}
+	}
+
+	store := stateStore(handler)
+	previous, err := store.Load(mapping)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error loading watermark:", err)
+	}
+	var from any
+	if previous != nil {
+		if previous.Field != incrementalField || previous.Database != dbName || previous.Collection != collectionName {
+			logger.ErrorLogger.Fatalf("Watermark of mapping %s was saved for %s of %s.%s, remove it to start over",
+				mapping, previous.Field, previous.Database, previous.Collection)
+		}
+		from = previous.Value
+		logger.InfoLogger.Printf("Extracting %s above %v (run %s)", incrementalField, from, previous.RunId)
+	} else {
+		logger.InfoLogger.Printf("No watermark saved for mapping %s, extracting every document", mapping)
+	}
+
+	to, found, err := handler.MaxValue(coll, filter, incrementalField)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error retrieving highest value of incremental field:", err)
+	}
+	if !found {
+		logger.WarningLogger.Printf("No document holds %s, nothing to extract", incrementalField)
+		return append(filter, bson.E{Key: incrementalField, Value: bson.D{{Key: "$exists", Value: true}}}), nil, func() {}
+	}
+	return state.Filter(filter, incrementalField, from, to), to, watermarkCommit(store, to)
+}
+
+// Function saving the watermark of a completed extraction
+func watermarkCommit(store state.Store, to any) func() {
+	return func() {
+		watermark := state.Watermark{
+			Database:   dbName,
+			Collection: collectionName,
+			Field:      incrementalField,
+			Value:      to,
+			RunId:      run.RunId,
+			UpdatedAt:  time.Now().UTC(),
+		}
+		if err := store.Save(mapping, watermark); err != nil {
+			logger.ErrorLogger.Fatalln("Error saving watermark:", err)
+		}
+		logger.InfoLogger.Printf("Watermark of mapping %s saved: %s = %v", mapping, incrementalField, to)
+	}
+}
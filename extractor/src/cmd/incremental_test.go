@@ -0,0 +1,27 @@
+package cmd
+
+import "testing"
+
+func TestValidateIncremental(t *testing.T) {
+	defer resetQueryFlags()
+	defer func() { incrementalField = "" }()
+
+	resetQueryFlags()
+	limit = 10
+	if err := validateIncremental(); err != nil {
+		t.Errorf("Expected --limit without --incremental-field to pass: %v", err)
+	}
+
+	incrementalField = "updatedAt"
+	if err := validateIncremental(); err == nil {
+		t.Error("Expected --limit to be rejected with --incremental-field")
+	}
+	limit, skip = 0, 5
+	if err := validateIncremental(); err == nil {
+		t.Error("Expected --skip to be rejected with --incremental-field")
+	}
+	skip = 0
+	if err := validateIncremental(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"strconv"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/farovictor/MongoDbExtractor/src/files"
+	logger "github.com/farovictor/MongoDbExtractor/src/logging"
+	"github.com/farovictor/MongoDbExtractor/src/mappings"
+	"github.com/spf13/cobra"
+)
+
+// Field renames and conversions of the mapping file (nil without mapping file)
+var transform *files.Transform
+
+// Loads the mapping file of --mapping, when there is one, and uses its values for every flag
+// not set in command line. Without mapping file, --mapping is only a name (file names).
+func applyMapping(cmd *cobra.Command) {
+	path, found, err := mappings.Resolve(mappingsDir, mapping)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error resolving mapping file:", err)
+	}
+	if found {
+		m, err := mappings.Load(path)
+		if err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		logger.InfoLogger.Println("Mapping file:", path)
+		mapping = m.Name
+
+		values := [][2]string{
+			{"collection", m.Collection},
+			{"query", string(m.Filter)},
+			{"projection", string(m.Projection)},
+			{"sort", string(m.Sort)},
+			{"hint", string(m.Hint)},
+			{"pipeline", string(m.Pipeline)},
+			{"incremental-field", m.IncrementalField},
+			{"output-format", m.Output.Format},
+			{"output-prefix", m.Output.Prefix},
+			{"output-path", m.Output.Path},
+			{"compress", m.Output.Compress},
+		}
+		if m.Limit != 0 {
+			values = append(values, [2]string{"limit", strconv.FormatInt(m.Limit, 10)})
+		}
+		if m.Skip != 0 {
+			values = append(values, [2]string{"skip", strconv.FormatInt(m.Skip, 10)})
+		}
+		if m.Output.ChunkSize != 0 {
+			values = append(values, [2]string{"chunk-size", strconv.FormatInt(int64(m.Output.ChunkSize), 10)})
+		}
+
+		for _, value := range values {
+			name, v := value[0], value[1]
+			if v == "" {
+				continue
+			}
+			flag := cmd.Flags().Lookup(name)
+			if flag == nil {
+				logger.ErrorLogger.Fatalf("%s of mapping %s cannot be used by %s", name, m.Name, cmd.Name())
+			}
+			if flag.Changed {
+				continue
+			}
+			if err = cmd.Flags().Set(name, v); err != nil {
+				logger.ErrorLogger.Fatalf("Invalid %s in mapping %s: %s", name, m.Name, err)
+			}
+		}
+
+		if transform, err = files.NewTransform(m.Rename, m.Convert); err != nil {
+			logger.ErrorLogger.Fatalf("Invalid mapping %s: %s", m.Name, err)
+		}
+	}
+
+	if collectionName == "" {
+		logger.ErrorLogger.Fatalln("No collection specified, set --collection or collection in mapping file")
+	}
+	if transform != nil && (outputFormat == constants.FormatBson || archivePath != "") {
+		logger.ErrorLogger.Fatalln("Field renames and conversions cannot be applied to bson output")
+	}
+	if pipelineSpec != "" || pipelineFile != "" {
+		for _, name := range []string{"query", "projection", "sort", "limit", "skip", "incremental-field"} {
+			if cmd.Flags().Changed(name) {
+				logger.ErrorLogger.Fatalf("--%s cannot be used along with an aggregation pipeline", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+
+	logger "github.com/farovictor/MongoDbExtractor/src/logging"
+	mongo "github.com/farovictor/MongodbDriver"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+)
+
+// Splits the documents matching filter into --partitions ranges of --partition-key, each one read by its own cursor
+// (nil when partitioning is off or the key holds a single value)
+func partitionFilters(handler *mongo.ConnectionHandler, coll *mongodb.Collection, filter bson.D) []interface{} {
+	if partitions < 2 {
+		return nil
+	}
+	bounds, err := handler.PartitionBounds(coll, filter, partitionKey, partitions, splitMethod)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error splitting collection into ranges:", err)
+	}
+	if len(bounds) == 0 {
+		logger.WarningLogger.Printf("No range of %s found, extracting with a single cursor", partitionKey)
+		return nil
+	}
+	logger.InfoLogger.Printf("%d ranges of %s (%s), bounds: %v", len(bounds)+1, partitionKey, splitMethod, bounds)
+	return rangeFilters(filter, partitionKey, bounds)
+}
+
+// Filters of the ranges between bounds (increasing values of key, all of the same type).
+// First range also holds documents without key or with a value of another type, so every document
+// falls into exactly one range.
+func rangeFilters(filter bson.D, key string, bounds []interface{}) []interface{} {
+	ranges := make([]interface{}, 0, len(bounds)+1)
+	for i := 0; i <= len(bounds); i++ {
+		var condition bson.D
+		switch {
+		case i == 0:
+			condition = bson.D{{Key: "$not", Value: bson.D{{Key: "$gte", Value: bounds[0]}}}}
+		case i == len(bounds):
+			condition = bson.D{{Key: "$gte", Value: bounds[i-1]}}
+		default:
+			condition = bson.D{{Key: "$gte", Value: bounds[i-1]}, {Key: "$lt", Value: bounds[i]}}
+		}
+
+		partition := bson.D{{Key: key, Value: condition}}
+		if len(filter) > 0 {
+			partition = bson.D{{Key: "$and", Value: bson.A{filter, partition}}}
+		}
+		ranges = append(ranges, partition)
+	}
+	return ranges
+}
+
+// Checks partitioning flags, ranges are read concurrently so cursor order, limit and skip cannot apply
+func validatePartitions() error {
+	if partitions < 2 {
+		return nil
+	}
+	if pipelineSpec != "" || pipelineFile != "" {
+		return fmt.Errorf("--partitions cannot be used along with an aggregation pipeline")
+	}
+	for _, value := range []struct {
+		flag string
+		set  bool
+	}{{"sort", sortBy != ""}, {"limit", limit != 0}, {"skip", skip != 0}} {
+		if value.set {
+			return fmt.Errorf("--partitions cannot be used along with --%s", value.flag)
+		}
+	}
+	switch splitMethod {
+	case mongo.SplitSample, mongo.SplitBucketAuto, mongo.SplitVector:
+	default:
+		return fmt.Errorf("invalid --split-method: %s (%s, %s or %s)", splitMethod, mongo.SplitSample, mongo.SplitBucketAuto, mongo.SplitVector)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRangeFilters(t *testing.T) {
+	query := bson.D{{Key: "status", Value: "active"}}
+	ranges := rangeFilters(query, "seq", []interface{}{int64(10), int64(20)})
+	expected := []string{
+		`{"$and":[{"status":"active"},{"seq":{"$not":{"$gte":{"$numberLong":"10"}}}}]}`,
+		`{"$and":[{"status":"active"},{"seq":{"$gte":{"$numberLong":"10"},"$lt":{"$numberLong":"20"}}}]}`,
+		`{"$and":[{"status":"active"},{"seq":{"$gte":{"$numberLong":"20"}}}]}`,
+	}
+	if len(ranges) != len(expected) {
+		t.Fatalf("Expected %d ranges, got %d", len(expected), len(ranges))
+	}
+	for i, partition := range ranges {
+		data, err := bson.MarshalExtJSON(partition, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected[i] {
+			t.Errorf("Range %d: expected %s, got %s", i, expected[i], data)
+		}
+	}
+
+	// Without query, ranges only hold key conditions
+	single := rangeFilters(nil, "_id", []interface{}{"m"})
+	data, _ := bson.MarshalExtJSON(single[1], true, false)
+	if len(single) != 2 || string(data) != `{"_id":{"$gte":"m"}}` {
+		t.Errorf("Unexpected ranges: %v", single)
+	}
+}
+
+func TestValidatePartitions(t *testing.T) {
+	defer resetQueryFlags()
+	defer func() { partitions, splitMethod = 0, "sample" }()
+	resetQueryFlags()
+
+	partitions, splitMethod = 8, "sample"
+	if err := validatePartitions(); err != nil {
+		t.Fatal(err)
+	}
+	limit = 10
+	if err := validatePartitions(); err == nil {
+		t.Error("Expected --limit to be rejected along with --partitions")
+	}
+	limit = 0
+	splitMethod = "random"
+	if err := validatePartitions(); err == nil {
+		t.Error("Expected unknown split method to be rejected")
+	}
+	// Partitioning off, nothing is checked
+	partitions = 0
+	if err := validatePartitions(); err != nil {
+		t.Error(err)
+	}
+}
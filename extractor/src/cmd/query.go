@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collation fields accepted by --collation
+type collation struct {
+	Locale          string `bson:"locale"`
+	CaseLevel       bool   `bson:"caseLevel"`
+	CaseFirst       string `bson:"caseFirst"`
+	Strength        int    `bson:"strength"`
+	NumericOrdering bool   `bson:"numericOrdering"`
+	Alternate       string `bson:"alternate"`
+	MaxVariable     string `bson:"maxVariable"`
+	Normalization   bool   `bson:"normalization"`
+	Backwards       bool   `bson:"backwards"`
+}
+
+var collationFields = map[string]bool{
+	"locale": true, "caseLevel": true, "caseFirst": true, "strength": true, "numericOrdering": true,
+	"alternate": true, "maxVariable": true, "normalization": true, "backwards": true,
+}
+
+// Find options taken from query flags, errors name the offending flag
+func findOptions() (*options.FindOptions, error) {
+	opts := options.Find().SetBatchSize(batchSize)
+
+	if projection != "" {
+		doc, err := parseDocument(projection)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --projection: %w", err)
+		}
+		opts.SetProjection(doc)
+	}
+	if sortBy != "" {
+		doc, err := parseSort(sortBy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --sort: %w", err)
+		}
+		opts.SetSort(doc)
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("invalid --limit: %d is negative", limit)
+	} else if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	if skip < 0 {
+		return nil, fmt.Errorf("invalid --skip: %d is negative", skip)
+	} else if skip > 0 {
+		opts.SetSkip(skip)
+	}
+	if hint != "" {
+		index, err := parseHint(hint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --hint: %w", err)
+		}
+		opts.SetHint(index)
+	}
+	if collationSpec != "" {
+		c, err := parseCollation(collationSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --collation: %w", err)
+		}
+		opts.SetCollation(c)
+	}
+	if maxTime < 0 {
+		return nil, fmt.Errorf("invalid --max-time: %s is negative", maxTime)
+	} else if maxTime > 0 {
+		opts.SetMaxTime(maxTime)
+	}
+	if comment != "" {
+		opts.SetComment(comment)
+	}
+	return opts, nil
+}
+
+// Checks --page-size: pages are successive _id ranges read in _id order, so documents must hold their _id
+// and cursor order, limit and skip cannot apply
+func validatePaging() error {
+	if pageSize < 0 {
+		return fmt.Errorf("invalid --page-size: %d is negative", pageSize)
+	}
+	if pageSize == 0 {
+		return nil
+	}
+	if pipelineSpec != "" || pipelineFile != "" {
+		return fmt.Errorf("--page-size cannot be used along with an aggregation pipeline")
+	}
+	if partitions >= 2 {
+		return fmt.Errorf("--page-size cannot be used along with --partitions")
+	}
+	if archivePath != "" || outputFormat == constants.FormatBson {
+		return fmt.Errorf("--page-size cannot be used with bson or archive output")
+	}
+	for _, value := range []struct {
+		flag string
+		set  bool
+	}{{"sort", sortBy != ""}, {"limit", limit != 0}, {"skip", skip != 0}} {
+		if value.set {
+			return fmt.Errorf("--page-size cannot be used along with --%s", value.flag)
+		}
+	}
+	if projection != "" {
+		doc, err := parseDocument(projection)
+		if err != nil {
+			return fmt.Errorf("invalid --projection: %w", err)
+		}
+		for _, elem := range doc {
+			if elem.Key == "_id" && !included(elem.Value) {
+				return fmt.Errorf("--page-size cannot be used with a projection excluding _id")
+			}
+		}
+	}
+	return nil
+}
+
+// Checks if a projection value keeps its field (0 and false exclude it)
+func included(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case int32:
+		return v != 0
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	}
+	return true
+}
+
+// Aggregate options taken from flags: disk use is always allowed, since pipelines may
+// hold $group or $sort stages larger than the server memory limit
+func aggregateOptions() (*options.AggregateOptions, error) {
+	opts := options.Aggregate().SetAllowDiskUse(true).SetBatchSize(batchSize)
+
+	if hint != "" {
+		index, err := parseHint(hint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --hint: %w", err)
+		}
+		opts.SetHint(index)
+	}
+	if collationSpec != "" {
+		c, err := parseCollation(collationSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --collation: %w", err)
+		}
+		opts.SetCollation(c)
+	}
+	if maxTime < 0 {
+		return nil, fmt.Errorf("invalid --max-time: %s is negative", maxTime)
+	} else if maxTime > 0 {
+		opts.SetMaxTime(maxTime)
+	}
+	if comment != "" {
+		opts.SetComment(comment)
+	}
+	return opts, nil
+}
+
+// Aggregation pipeline taken from --pipeline or --pipeline-file (nil when none is set)
+func aggregatePipeline() ([]bson.D, error) {
+	if pipelineFile != "" {
+		data, err := os.ReadFile(pipelineFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --pipeline-file: %w", err)
+		}
+		stages, err := parsePipeline(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid --pipeline-file %s: %w", pipelineFile, err)
+		}
+		return stages, nil
+	}
+	if pipelineSpec != "" {
+		stages, err := parsePipeline(pipelineSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --pipeline: %w", err)
+		}
+		return stages, nil
+	}
+	return nil, nil
+}
+
+// Pipeline as given in flags, recorded into the manifest
+func pipelineText() string {
+	if pipelineFile != "" {
+		data, _ := os.ReadFile(pipelineFile)
+		return strings.TrimSpace(string(data))
+	}
+	return pipelineSpec
+}
+
+// Parses an extended json array of stages, every stage holding a single $operator
+func parsePipeline(value string) ([]bson.D, error) {
+	// Extended json parser only accepts documents at top level.
+	// Relaxed mode accepts both {"$date":"2023-01-01T00:00:00Z"} and canonical values.
+	var wrapper struct {
+		Pipeline []bson.D `bson:"pipeline"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(`{"pipeline":`+value+`}`), false, &wrapper); err != nil {
+		return nil, fmt.Errorf("expected an array of stages: %w", err)
+	}
+	if len(wrapper.Pipeline) == 0 {
+		return nil, fmt.Errorf("pipeline has no stages")
+	}
+	for i, stage := range wrapper.Pipeline {
+		if len(stage) != 1 || !strings.HasPrefix(stage[0].Key, "$") {
+			return nil, fmt.Errorf("stage %d must hold a single $ operator", i)
+		}
+	}
+	return wrapper.Pipeline, nil
+}
+
+// Either an index specification ({"age":1}) or an index name (age_1)
+func parseHint(value string) (interface{}, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "{") {
+		return parseDocument(value)
+	}
+	return value, nil
+}
+
+// Parses a non empty extended json document, keeping key order
+func parseDocument(value string) (bson.D, error) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(value), true, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("%s is an empty document", value)
+	}
+	return doc, nil
+}
+
+// Sort directions must be 1, -1 or a {"$meta": ...} document
+func parseSort(value string) (bson.D, error) {
+	doc, err := parseDocument(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, elem := range doc {
+		switch direction := elem.Value.(type) {
+		case int32:
+			if direction == 1 || direction == -1 {
+				continue
+			}
+		case int64:
+			if direction == 1 || direction == -1 {
+				continue
+			}
+		case float64:
+			if direction == 1 || direction == -1 {
+				continue
+			}
+		case bson.D:
+			if len(direction) == 1 && direction[0].Key == "$meta" {
+				continue
+			}
+		}
+		return nil, fmt.Errorf("direction of %s must be 1, -1 or {\"$meta\": ...}, got %v", elem.Key, elem.Value)
+	}
+	return doc, nil
+}
+
+func parseCollation(value string) (*options.Collation, error) {
+	doc, err := parseDocument(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, elem := range doc {
+		if !collationFields[elem.Key] {
+			return nil, fmt.Errorf("unknown collation field %s", elem.Key)
+		}
+	}
+
+	var c collation
+	if err := bson.UnmarshalExtJSON([]byte(value), true, &c); err != nil {
+		return nil, err
+	}
+	if c.Locale == "" {
+		return nil, fmt.Errorf("locale is required")
+	}
+	return &options.Collation{
+		Locale:          c.Locale,
+		CaseLevel:       c.CaseLevel,
+		CaseFirst:       c.CaseFirst,
+		Strength:        c.Strength,
+		NumericOrdering: c.NumericOrdering,
+		Alternate:       c.Alternate,
+		MaxVariable:     c.MaxVariable,
+		Normalization:   c.Normalization,
+		Backwards:       c.Backwards,
+	}, nil
+}
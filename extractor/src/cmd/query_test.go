@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func resetQueryFlags() {
+	batchSize = 100
+	projection, sortBy, hint, collationSpec, comment = "", "", "", "", ""
+	limit, skip, maxTime = 0, 0, 0
+	pipelineSpec, pipelineFile = "", ""
+}
+
+func TestFindOptions(t *testing.T) {
+	defer resetQueryFlags()
+	resetQueryFlags()
+	projection = `{"name":1,"_id":0}`
+	sortBy = `{"created":-1,"score":{"$meta":"textScore"}}`
+	limit, skip = 50, 10
+	hint = "created_-1"
+	collationSpec = `{"locale":"fr","strength":2,"caseLevel":true}`
+	maxTime = 30 * time.Second
+	comment = "nightly"
+
+	opts, err := findOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *opts.BatchSize != 100 || *opts.Limit != 50 || *opts.Skip != 10 || *opts.MaxTime != maxTime || *opts.Comment != "nightly" {
+		t.Errorf("Unexpected scalar options: %+v", opts)
+	}
+	if sort := opts.Sort.(bson.D); sort[0].Key != "created" || sort[1].Key != "score" {
+		t.Errorf("Sort order not kept: %v", sort)
+	}
+	if opts.Hint != "created_-1" {
+		t.Errorf("Expected hint by index name, got %v", opts.Hint)
+	}
+	if opts.Collation.Locale != "fr" || opts.Collation.Strength != 2 || !opts.Collation.CaseLevel {
+		t.Errorf("Unexpected collation: %+v", opts.Collation)
+	}
+
+	hint = `{"created":-1}`
+	if opts, err = findOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := opts.Hint.(bson.D); !ok {
+		t.Errorf("Expected hint by index specification, got %v", opts.Hint)
+	}
+}
+
+func TestFindOptionsErrors(t *testing.T) {
+	defer resetQueryFlags()
+
+	cases := map[string]func(){
+		"--projection": func() { projection = `{"name":` },
+		"--sort":       func() { sortBy = `{"created":"desc"}` },
+		"--limit":      func() { limit = -1 },
+		"--skip":       func() { skip = -5 },
+		"--hint":       func() { hint = `{}` },
+		"--collation":  func() { collationSpec = `{"strength":2}` },
+		"--max-time":   func() { maxTime = -time.Second },
+	}
+	for flag, set := range cases {
+		resetQueryFlags()
+		set()
+		_, err := findOptions()
+		if err == nil || !strings.Contains(err.Error(), flag) {
+			t.Errorf("%s: expected an error naming the flag, got %v", flag, err)
+		}
+	}
+
+	resetQueryFlags()
+	collationSpec = `{"locale":"en","caselevel":true}`
+	if _, err := findOptions(); err == nil {
+		t.Error("Expected an error for unknown collation field")
+	}
+}
+
+func TestValidatePaging(t *testing.T) {
+	defer resetQueryFlags()
+	defer func() { pageSize = 0 }()
+	resetQueryFlags()
+
+	pageSize, projection = 5000, `{"name":1}`
+	if err := validatePaging(); err != nil {
+		t.Fatal(err)
+	}
+	projection = `{"name":1,"_id":0}`
+	if err := validatePaging(); err == nil {
+		t.Error("Expected a projection excluding _id to be rejected")
+	}
+	projection, sortBy = "", `{"created":-1}`
+	if err := validatePaging(); err == nil {
+		t.Error("Expected --sort to be rejected along with --page-size")
+	}
+	sortBy, pageSize = "", -1
+	if err := validatePaging(); err == nil {
+		t.Error("Expected a negative page size to be rejected")
+	}
+}
+
+func TestAggregatePipeline(t *testing.T) {
+	defer resetQueryFlags()
+	resetQueryFlags()
+
+	stages := `[{"$match":{"created":{"$gte":{"$date":"2023-01-01T00:00:00Z"}}}},{"$lookup":{"from":"orders","localField":"_id","foreignField":"user","as":"orders"}},{"$unwind":"$orders"}]`
+	pipelineFile = filepath.Join(t.TempDir(), "pipeline.json")
+	if err := os.WriteFile(pipelineFile, []byte(stages), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := aggregatePipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipeline) != 3 || pipeline[1][0].Key != "$lookup" || pipeline[2][0].Value != "$orders" {
+		t.Errorf("Unexpected pipeline: %v", pipeline)
+	}
+
+	pipelineFile = ""
+	maxTime = time.Minute
+	opts, err := aggregateOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*opts.AllowDiskUse || *opts.MaxTime != time.Minute {
+		t.Errorf("Unexpected aggregate options: %+v", opts)
+	}
+
+	for _, spec := range []string{`{"$match":{}}`, `[]`, `[{"$match":{},"$limit":1}]`, `[{"match":{}}]`, `[1]`} {
+		pipelineSpec = spec
+		if _, err := aggregatePipeline(); err == nil || !strings.Contains(err.Error(), "--pipeline") {
+			t.Errorf("%s: expected an error naming the flag, got %v", spec, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+
+	mongo "github.com/farovictor/MongodbDriver"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Documents to extract: either a find (filter and options) or an aggregation pipeline.
+// A find may be split into ranges (partitions), each one read by its own cursor, or read by pages (--page-size).
+type source struct {
+	coll       *mongodb.Collection
+	filter     bson.D
+	find       *options.FindOptions
+	partitions []interface{}
+	pipeline   []bson.D
+	aggregate  *options.AggregateOptions
+}
+
+// Streams documents into a pool of workers
+func (s source) stream(handler *mongo.ConnectionHandler,
+	process func(ctx context.Context, batchData <-chan []*bson.M, mapping string, filePrefix string, folder string) error) error {
+	if s.pipeline != nil {
+		return handler.StreamingAggregateResults(mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, process, s.coll, s.pipeline, s.aggregate)
+	}
+	if s.partitions != nil {
+		return handler.StreamingPartitionedResults(mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, process, s.coll, s.partitions, s.find)
+	}
+	if pageSize > 0 {
+		return handler.StreamingPagedResults(mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, pageSize, process, s.coll, s.filter, s.find)
+	}
+	return handler.StreamingResults(mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, process, s.coll, s.filter, s.find)
+}
+
+// Streams raw documents into a pool of workers
+func (s source) streamRaw(handler *mongo.ConnectionHandler,
+	process func(ctx context.Context, batchData <-chan []bson.Raw, mapping string, filePrefix string, folder string) error) error {
+	if s.pipeline != nil {
+		return handler.StreamingRawAggregateResults(mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, process, s.coll, s.pipeline, s.aggregate)
+	}
+	if s.partitions != nil {
+		return handler.StreamingPartitionedRawResults(mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, process, s.coll, s.partitions, s.find)
+	}
+	return handler.StreamingRawResults(mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, process, s.coll, s.filter, s.find)
+}
+
+// Picks a random sample of the documents extracted
+func (s source) sample(handler *mongo.ConnectionHandler, size int32) ([]*bson.M, error) {
+	if s.pipeline != nil {
+		return handler.SamplePipeline(s.coll, s.pipeline, size)
+	}
+	// Sampled documents must hold the same fields as extracted ones
+	var fields interface{}
+	if s.find != nil && s.find.Projection != nil {
+		fields = s.find.Projection
+	}
+	return handler.SampleDocuments(s.coll, s.filter, fields, size)
+}
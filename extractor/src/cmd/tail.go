@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/farovictor/MongoDbExtractor/src/files"
+	logger "github.com/farovictor/MongoDbExtractor/src/logging"
+	"github.com/farovictor/MongoDbExtractor/src/state"
+	mongo "github.com/farovictor/MongodbDriver"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Execution logic for tail command, runs until interrupted (SIGINT or SIGTERM)
+func tailCollection(cmd *cobra.Command, args []string) {
+	if mapping == "" || mapping == constants.MappingDefault {
+		logger.ErrorLogger.Fatalln("Please set a valid mapping")
+	}
+	logger.InfoLogger.Println("Mapping:", mapping)
+	if !streamFormats[outputFormat] {
+		logger.ErrorLogger.Fatalf("Streamed documents cannot be written as %s, use a json or ndjson format", outputFormat)
+	}
+	if batchSize <= 0 || rollInterval <= 0 {
+		logger.ErrorLogger.Fatalln("--chunk-size and --roll-interval must be positive")
+	}
+	startRun(true)
+
+	var filter bson.D
+	if query != "" {
+		if err := bson.UnmarshalExtJSON([]byte(query), true, &filter); err != nil {
+			logger.ErrorLogger.Fatalln("Error handling query argument:", err)
+		}
+	}
+	// Waiting for new documents never delays a file roll much
+	maxAwait := time.Second
+	if rollInterval < maxAwait {
+		maxAwait = rollInterval
+	}
+	opts := options.Find().SetMaxAwaitTime(maxAwait).SetBatchSize(batchSize)
+	logger.InfoLogger.Println("Options set!")
+
+	handler, disconnect := mongo.NewConnectionHandler(connUri, dbName, appName)
+	defer disconnect()
+
+	coll := handler.GetCollection(collectionName)
+	logger.InfoLogger.Println("Collection retrieved")
+
+	store := stateStore(handler)
+	previous, err := store.Load(mapping)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error loading last position:", err)
+	}
+	var from any
+	if previous != nil {
+		if previous.Field != tailField || previous.Database != dbName || previous.Collection != collectionName {
+			logger.ErrorLogger.Fatalf("State of mapping %s was saved for %s of %s.%s, remove it to start over",
+				mapping, previous.Field, previous.Database, previous.Collection)
+		}
+		from = previous.Value
+		logger.InfoLogger.Printf("Resuming after %s %v (run %s)", tailField, from, previous.RunId)
+	} else {
+		logger.InfoLogger.Printf("No position saved for mapping %s, starting from the oldest document", mapping)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	checkpoint := &resumeCheckpoint{
+		next: 1,
+		done: map[int64]files.ManifestChunk{},
+		stop: cancel,
+		save: func(last any, _ []files.ManifestChunk) error {
+			return store.Save(mapping, state.Watermark{
+				Database:   dbName,
+				Collection: collectionName,
+				Field:      tailField,
+				Value:      last,
+				RunId:      run.RunId,
+				UpdatedAt:  time.Now().UTC(),
+			})
+		},
+	}
+
+	dumper, err := files.NewDumper(files.Options{
+		Format:      outputFormat,
+		Compression: outputCompression(),
+		Run:         run,
+		OnChunk:     checkpoint.written,
+	})
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error handling output arguments:", err)
+	}
+
+	logger.InfoLogger.Println("Tailing collection")
+	err = handler.StreamingTailableResults(ctx, mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, rollInterval,
+		dumper.DumpStreams, checkpoint.sent, coll, filter, tailField, from, opts)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error tailing collection:", err)
+	}
+	if checkpoint.err != nil {
+		logger.ErrorLogger.Fatalln(checkpoint.err)
+	}
+	logger.InfoLogger.Printf("%d documents written into %d files", checkpoint.events, checkpoint.files)
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/farovictor/MongoDbExtractor/src/files"
+	logger "github.com/farovictor/MongoDbExtractor/src/logging"
+	"github.com/farovictor/MongoDbExtractor/src/state"
+	mongo "github.com/farovictor/MongodbDriver"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Output formats of tail command (documents have no fixed schema)
+var streamFormats = map[string]bool{
+	constants.FormatJson:            true,
+	constants.FormatCanonical:       true,
+	constants.FormatRelaxed:         true,
+	constants.FormatNdjson:          true,
+	constants.FormatNdjsonCanonical: true,
+}
+
+// Output formats of watch command: change events are replayed by the loader, so bson types must survive
+// (relaxed json reads small int64 back as int32, plain json writes ObjectIds as strings)
+var changeFormats = map[string]bool{
+	constants.FormatCanonical:       true,
+	constants.FormatNdjsonCanonical: true,
+}
+
+// Execution logic for watch command, runs until interrupted (SIGINT or SIGTERM)
+func watchChanges(cmd *cobra.Command, args []string) {
+	if mapping == "" || mapping == constants.MappingDefault {
+		logger.ErrorLogger.Fatalln("Please set a valid mapping")
+	}
+	logger.InfoLogger.Println("Mapping:", mapping)
+	if !changeFormats[outputFormat] {
+		logger.ErrorLogger.Fatalf("Change events cannot be written as %s, use canonical or ndjson-canonical", outputFormat)
+	}
+	if batchSize <= 0 || rollInterval <= 0 {
+		logger.ErrorLogger.Fatalln("--chunk-size and --roll-interval must be positive")
+	}
+	startRun(true)
+
+	opts, err := changeStreamOptions()
+	if err != nil {
+		logger.ErrorLogger.Fatalln(err)
+	}
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: bson.D{{Key: "operationType", Value: bson.D{{Key: "$in", Value: operationTypes}}}}}},
+	}
+	logger.InfoLogger.Println("Options set!")
+
+	handler, disconnect := mongo.NewConnectionHandler(connUri, dbName, appName)
+	defer disconnect()
+
+	// Namespace of the stream, as recorded along with its resume token
+	database, collection := dbName, collectionName
+	if watchCluster {
+		database = ""
+	}
+
+	store := stateStore(handler)
+	previous, err := store.Load(mapping)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error loading resume token:", err)
+	}
+	if previous != nil {
+		if previous.Field != state.ResumeTokenField || previous.Database != database || previous.Collection != collection {
+			logger.ErrorLogger.Fatalf("State of mapping %s was saved for another stream (%s of %s.%s), remove it to start over",
+				mapping, previous.Field, previous.Database, previous.Collection)
+		}
+		// Unlike resumeAfter, startAfter also resumes after an invalidate event
+		opts.SetStartAfter(previous.Value)
+		logger.InfoLogger.Printf("Resuming change stream after run %s (%s)", previous.RunId, previous.UpdatedAt.Format(time.RFC3339))
+	} else {
+		logger.InfoLogger.Printf("No resume token saved for mapping %s, starting from now", mapping)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	checkpoint := &resumeCheckpoint{
+		next: 1,
+		done: map[int64]files.ManifestChunk{},
+		stop: cancel,
+		save: func(token any, _ []files.ManifestChunk) error {
+			return store.Save(mapping, state.Watermark{
+				Database:   database,
+				Collection: collection,
+				Field:      state.ResumeTokenField,
+				Value:      token,
+				RunId:      run.RunId,
+				UpdatedAt:  time.Now().UTC(),
+			})
+		},
+	}
+
+	dumper, err := files.NewDumper(files.Options{
+		Format:      outputFormat,
+		Compression: outputCompression(),
+		Run:         run,
+		OnChunk:     checkpoint.written,
+	})
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error handling output arguments:", err)
+	}
+
+	logger.InfoLogger.Println("Watching changes")
+	err = handler.StreamingChanges(ctx, mapping, outputFilePrefix, outputPath, batchSize, numConcurrentFiles, rollInterval,
+		dumper.DumpStreams, func(token bson.Raw) { checkpoint.sent(append(bson.Raw(nil), token...)) }, handler.GetWatcher(collectionName, watchCluster), pipeline, opts)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error watching changes:", err)
+	}
+	if checkpoint.err != nil {
+		logger.ErrorLogger.Fatalln(checkpoint.err)
+	}
+	logger.InfoLogger.Printf("%d events written into %d files", checkpoint.events, checkpoint.files)
+}
+
+// Change stream options taken from flags
+func changeStreamOptions() (*options.ChangeStreamOptions, error) {
+	if len(operationTypes) == 0 {
+		return nil, fmt.Errorf("invalid --operation-types: no operation type")
+	}
+
+	// Waiting for new events never delays a file roll much
+	maxAwait := time.Second
+	if rollInterval < maxAwait {
+		maxAwait = rollInterval
+	}
+	opts := options.ChangeStream().SetMaxAwaitTime(maxAwait)
+
+	switch fullDocument {
+	case "":
+	case string(options.UpdateLookup), string(options.WhenAvailable), string(options.Required):
+		opts.SetFullDocument(options.FullDocument(fullDocument))
+	default:
+		return nil, fmt.Errorf("invalid --full-document: %s (updateLookup, whenAvailable or required)", fullDocument)
+	}
+	switch fullDocumentBefore {
+	case "":
+	case string(options.WhenAvailable), string(options.Required):
+		opts.SetFullDocumentBeforeChange(options.FullDocument(fullDocumentBefore))
+	default:
+		return nil, fmt.Errorf("invalid --full-document-before-change: %s (whenAvailable or required)", fullDocumentBefore)
+	}
+	return opts, nil
+}
+
+// Saves the position (resume token, last value of tailed field, sort key of last document) following a batch
+// once it and every batch sent before it were written, so a restart never skips documents (batches written
+// after the last position saved are written again). Chunk ids are given in the order batches are sent, so the
+// n-th position sent belongs to chunk n.
+type resumeCheckpoint struct {
+	mu sync.Mutex
+	// Called with the position reached and every chunk written up to it
+	save func(position any, chunks []files.ManifestChunk) error
+	// Stops the stream when a chunk or a save failed
+	stop context.CancelFunc
+	// Positions of batches sent and not saved yet, the one of chunk next first
+	positions []any
+	next      int64
+	done      map[int64]files.ManifestChunk
+	chunks    []files.ManifestChunk
+	events    int64
+	files     int64
+	err       error
+}
+
+// Records the position following a batch, before it is sent to workers
+func (c *resumeCheckpoint) sent(position any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.positions = append(c.positions, position)
+}
+
+// Records a chunk written by a worker, and saves the last position every chunk before was written for
+func (c *resumeCheckpoint) written(chunk files.ManifestChunk) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if chunk.Error != "" {
+		c.fail(fmt.Errorf("chunk %d failed: %s", chunk.ChunkId, chunk.Error))
+		return
+	}
+	logger.InfoLogger.Printf("%s written (%d documents)", chunk.File, chunk.Documents)
+	c.events += chunk.Documents
+	c.files++
+
+	c.done[chunk.ChunkId] = chunk
+	var position any
+	for len(c.positions) > 0 {
+		next, ok := c.done[c.next]
+		if !ok {
+			break
+		}
+		delete(c.done, c.next)
+		c.chunks = append(c.chunks, next)
+		position, c.positions = c.positions[0], c.positions[1:]
+		c.next++
+	}
+	if position == nil || c.err != nil {
+		return
+	}
+	if err := c.save(position, c.chunks); err != nil {
+		c.fail(fmt.Errorf("error saving position: %w", err))
+	}
+}
+
+func (c *resumeCheckpoint) fail(err error) {
+	if c.err == nil {
+		c.err = err
+		c.stop()
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/farovictor/MongoDbExtractor/src/files"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestResumeCheckpoint(t *testing.T) {
+	var saved []string
+	stopped := false
+	checkpoint := &resumeCheckpoint{
+		next: 1,
+		done: map[int64]files.ManifestChunk{},
+		stop: func() { stopped = true },
+		save: func(token any, _ []files.ManifestChunk) error {
+			saved = append(saved, token.(bson.Raw).Lookup("_data").StringValue())
+			return nil
+		},
+	}
+	token := func(data string) bson.Raw {
+		raw, _ := bson.Marshal(bson.D{{Key: "_data", Value: data}})
+		return raw
+	}
+	for _, data := range []string{"a", "b", "c", "d"} {
+		checkpoint.sent(token(data))
+	}
+
+	// Chunk 2 is written first, its token waits for chunk 1
+	checkpoint.written(files.ManifestChunk{ChunkId: 2, Documents: 10})
+	if len(saved) != 0 {
+		t.Fatalf("Expected no token saved, got %v", saved)
+	}
+	checkpoint.written(files.ManifestChunk{ChunkId: 1, Documents: 10})
+	if len(saved) != 1 || saved[0] != "b" {
+		t.Fatalf("Expected token of chunk 2 saved, got %v", saved)
+	}
+
+	// A failed chunk stops the stream, later chunks do not move the token
+	checkpoint.written(files.ManifestChunk{ChunkId: 3, Error: "disk full"})
+	checkpoint.written(files.ManifestChunk{ChunkId: 4, Documents: 5})
+	if !stopped || checkpoint.err == nil {
+		t.Error("Expected a failed chunk to stop the stream")
+	}
+	if len(saved) != 1 {
+		t.Errorf("Expected no token saved after a failed chunk, got %v", saved)
+	}
+	if checkpoint.events != 25 || checkpoint.files != 3 {
+		t.Errorf("Unexpected counters: %d events, %d files", checkpoint.events, checkpoint.files)
+	}
+}
@@ -1,3 +1,67 @@
 package constants
 
 const MappingDefault string = "{mapping}_{chunk_id}"
+
+// Output formats accepted by --output-format
+const (
+	// Plain json, encoded with encoding/json (lossy for bson types)
+	FormatJson string = "json"
+	// MongoDB Extended JSON v2, canonical mode (keeps every bson type)
+	FormatCanonical string = "canonical"
+	// MongoDB Extended JSON v2, relaxed mode (native json numbers and dates)
+	FormatRelaxed string = "relaxed"
+	// Newline delimited json (one relaxed extended json document per line)
+	FormatNdjson string = "ndjson"
+	// Newline delimited json (one canonical extended json document per line)
+	FormatNdjsonCanonical string = "ndjson-canonical"
+	// Comma separated values, nested documents are flattened into dotted columns
+	FormatCsv string = "csv"
+	// Apache Parquet, nested documents are flattened into dotted columns
+	FormatParquet string = "parquet"
+	// Apache Avro object container files, schema inferred from data
+	FormatAvro string = "avro"
+	// Apache Arrow IPC file (Feather v2), a single file holding one record batch per chunk
+	FormatArrow string = "arrow"
+	// Raw bson documents and metadata using mongodump directory layout (<db>/<collection>.bson)
+	FormatBson string = "bson"
+)
+
+// How csv output renders arrays
+const (
+	// Array is written as a relaxed extended json string
+	ArrayModeJson string = "json"
+	// Array items are joined with a separator
+	ArrayModeJoin string = "join"
+	// Every array item gets its own column (field.0, field.1, ...)
+	ArrayModeExpand string = "expand"
+)
+
+// Output compression accepted by --compress
+const (
+	// gzip (.gz), levels 1 (fastest) to 9 (best)
+	CompressGzip string = "gzip"
+	// Zstandard (.zst), levels 1 (fastest) to 22 (best)
+	CompressZstd string = "zstd"
+	// Snappy framing format (.sz), no levels
+	CompressSnappy string = "snappy"
+)
+
+// Types accepted by field conversions of mapping files
+const (
+	// String (ObjectIds as hex, dates as RFC 3339)
+	ConvertString string = "string"
+	// 32-bit integer
+	ConvertInt string = "int"
+	// 64-bit integer (dates as epoch milliseconds)
+	ConvertLong string = "long"
+	// 64-bit float
+	ConvertDouble string = "double"
+	// 128-bit decimal
+	ConvertDecimal string = "decimal"
+	// Boolean (numbers are true when not zero)
+	ConvertBool string = "bool"
+	// Date (strings in RFC 3339 or YYYY-MM-DD, numbers as epoch milliseconds)
+	ConvertDate string = "date"
+	// ObjectId (strings in hex)
+	ConvertObjectId string = "objectid"
+)
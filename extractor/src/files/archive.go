@@ -0,0 +1,263 @@
+package files
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mongodump archive constants
+// Check mongo-tools implementation: https://github.com/mongodb/mongo-tools/tree/master/common/archive
+const (
+	archiveMagicNumber   uint32 = 0x8199e26d
+	archiveFormatVersion string = "0.1"
+)
+
+// Marks the end of the prelude and of every namespace block
+var archiveTerminator = []byte{0xFF, 0xFF, 0xFF, 0xFF}
+
+// Archive level information, written right after the magic number
+type archiveHeader struct {
+	ConcurrentCollections int32  `bson:"concurrent_collections"`
+	FormatVersion         string `bson:"version"`
+	ServerVersion         string `bson:"server_version"`
+	ToolVersion           string `bson:"tool_version"`
+}
+
+// One per collection in archive prelude, metadata holds <collection>.metadata.json content
+type archiveCollectionMetadata struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Metadata   string `bson:"metadata"`
+	Size       int    `bson:"size"`
+	Type       string `bson:"type"`
+}
+
+// Announces the namespace of the following documents, or its end (EOF) with a checksum of every document written
+type archiveNamespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// Writes raw bson documents into a single stream using mongodump --archive format,
+// so the output can be restored with mongorestore --archive.
+// All workers write to the same stream, optionally compressed as a whole (gzip matches mongodump --gzip --archive).
+type ArchiveWriter struct {
+	database   string
+	collection string
+
+	path string
+
+	mu        sync.Mutex
+	closers   []io.Closer
+	digest    *fileDigest
+	writer    *bufio.Writer
+	crc       hash.Hash64
+	started   bool
+	documents int64
+	// First error met by workers, returned by Close
+	err error
+}
+
+// Creates the archive and writes its prelude. Path "-" writes to stdout.
+func NewArchiveWriter(path string, compression Compression, database, collection string, metadata bson.D, serverVersion, toolVersion string) (*ArchiveWriter, error) {
+	if err := compression.Validate(); err != nil {
+		return nil, err
+	}
+
+	a := &ArchiveWriter{
+		path:       path,
+		database:   database,
+		collection: collection,
+		crc:        crc64.New(crc64.MakeTable(crc64.ECMA)),
+	}
+
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		a.closers = append(a.closers, file)
+		out = file
+	}
+	a.digest = newFileDigest(out)
+	out = a.digest
+	if compression.Codec != "" {
+		compressor, err := compression.NewWriter(out)
+		if err != nil {
+			a.closeAll()
+			return nil, err
+		}
+		// Compressed stream must be closed before the file
+		a.closers = append([]io.Closer{compressor}, a.closers...)
+		out = compressor
+	}
+	a.writer = bufio.NewWriter(out)
+
+	if err := a.writePrelude(metadata, serverVersion, toolVersion); err != nil {
+		a.closeAll()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Magic number, header, collection metadata and terminator
+func (a *ArchiveWriter) writePrelude(metadata bson.D, serverVersion, toolVersion string) error {
+	magic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magic, archiveMagicNumber)
+	if _, err := a.writer.Write(magic); err != nil {
+		return err
+	}
+
+	metadataJson, err := bson.MarshalExtJSON(metadata, true, false)
+	if err != nil {
+		return err
+	}
+
+	prelude := []any{
+		archiveHeader{
+			ConcurrentCollections: 1,
+			FormatVersion:         archiveFormatVersion,
+			ServerVersion:         serverVersion,
+			ToolVersion:           toolVersion,
+		},
+		archiveCollectionMetadata{
+			Database:   a.database,
+			Collection: a.collection,
+			Metadata:   string(metadataJson),
+			Type:       "collection",
+		},
+	}
+	for _, item := range prelude {
+		if err := a.writeDocument(item); err != nil {
+			return err
+		}
+	}
+
+	_, err = a.writer.Write(archiveTerminator)
+	return err
+}
+
+func (a *ArchiveWriter) writeDocument(value any) error {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = a.writer.Write(data)
+	return err
+}
+
+// Appends a batch of documents to the archive.
+// Remaining params are kept to match the process function signature.
+func (a *ArchiveWriter) DumpToFile(results []bson.Raw, mapping string, filePrefix string, fileLocation string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// A single namespace is written, so its header shows up only once
+	if !a.started {
+		a.started = true
+		if err := a.writeDocument(archiveNamespaceHeader{Database: a.database, Collection: a.collection}); err != nil {
+			return err
+		}
+	}
+
+	for _, doc := range results {
+		if _, err := a.writer.Write(doc); err != nil {
+			return err
+		}
+		a.crc.Write(doc)
+		a.documents++
+	}
+	return nil
+}
+
+// Concurrent batch dumper appending raw documents to the archive, stops on the first error
+func (a *ArchiveWriter) DumpStreams(ctx context.Context, dataChannel <-chan []bson.Raw, mapping string, filePrefix string, fileLocation string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case batch, ok := <-dataChannel:
+			if !ok {
+				return nil
+			}
+			if err := a.DumpToFile(batch, mapping, filePrefix, fileLocation); err != nil {
+				a.fail(err)
+				return err
+			}
+		}
+	}
+}
+
+func (a *ArchiveWriter) fail(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+// Describes the archive for the run manifest (call it once closed)
+func (a *ArchiveWriter) Chunk() ManifestChunk {
+	return ManifestChunk{ChunkId: 1, File: a.path, Documents: a.documents, Bytes: a.digest.size, Sha256: a.digest.Sum()}
+}
+
+// Ends the namespace (EOF header with checksum), flushes and closes the stream.
+// Returns the first error met by workers.
+func (a *ArchiveWriter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.err
+	if err == nil {
+		err = a.writeEOF()
+	}
+	if flushErr := a.writer.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := a.closeAll(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Closes compressed stream and file (stdout is never closed)
+func (a *ArchiveWriter) closeAll() error {
+	var err error
+	for _, closer := range a.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	a.closers = nil
+	return err
+}
+
+func (a *ArchiveWriter) writeEOF() error {
+	if a.started {
+		if _, err := a.writer.Write(archiveTerminator); err != nil {
+			return err
+		}
+	}
+	eof := archiveNamespaceHeader{
+		Database:   a.database,
+		Collection: a.collection,
+		EOF:        true,
+		CRC:        int64(a.crc.Sum64()),
+	}
+	if err := a.writeDocument(eof); err != nil {
+		return err
+	}
+	_, err := a.writer.Write(archiveTerminator)
+	return err
+}
@@ -0,0 +1,102 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestArchiveWriterLayout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.archive.gz")
+	metadata := bson.D{{Key: "indexes", Value: bson.A{}}}
+
+	writer, err := NewArchiveWriter(path, Compression{Codec: constants.CompressGzip}, "db", "records", metadata, "6.0.0", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCrc := crc64.New(crc64.MakeTable(crc64.ECMA))
+	for i := 0; i < 3; i++ {
+		doc, _ := bson.Marshal(bson.D{{Key: "i", Value: i}})
+		expectedCrc.Write(doc)
+		if err := writer.DumpToFile([]bson.Raw{doc}, "", "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(gz)
+
+	magic := make([]byte, 4)
+	io.ReadFull(reader, magic)
+	if binary.LittleEndian.Uint32(magic) != archiveMagicNumber {
+		t.Fatalf("Unexpected magic number %x", magic)
+	}
+
+	readDoc := func(value any) {
+		doc, err := bson.ReadDocument(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bson.Unmarshal(doc, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectTerminator := func() {
+		next := make([]byte, 4)
+		io.ReadFull(reader, next)
+		if !bytes.Equal(next, archiveTerminator) {
+			t.Fatalf("Expected terminator, got %x", next)
+		}
+	}
+
+	var header archiveHeader
+	readDoc(&header)
+	var collection archiveCollectionMetadata
+	readDoc(&collection)
+	if header.FormatVersion != archiveFormatVersion || collection.Collection != "records" || collection.Metadata == "" {
+		t.Errorf("Unexpected prelude %+v %+v", header, collection)
+	}
+	expectTerminator()
+
+	var ns archiveNamespaceHeader
+	readDoc(&ns)
+	if ns.EOF || ns.Collection != "records" {
+		t.Errorf("Unexpected namespace header %+v", ns)
+	}
+	for i := 0; i < 3; i++ {
+		var doc bson.M
+		readDoc(&doc)
+	}
+	expectTerminator()
+
+	var eof archiveNamespaceHeader
+	readDoc(&eof)
+	if !eof.EOF || eof.CRC != int64(expectedCrc.Sum64()) {
+		t.Errorf("Unexpected EOF header %+v", eof)
+	}
+	expectTerminator()
+
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Error("Expected end of archive")
+	}
+}
@@ -0,0 +1,448 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/decimal128"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Arrow types used for bson values. Nested documents are flattened into dotted
+// columns and arrays are written as relaxed extended json strings.
+var (
+	objectIdType  = &arrow.FixedSizeBinaryType{ByteWidth: 12}
+	timestampType = &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}
+)
+
+// Max precision of a 128 bits decimal
+const maxDecimalPrecision = 38
+
+// Holds an arrow schema shared by every worker, so all chunk files are written with the same columns.
+// Schema is either set up front (user supplied) or inferred from sampled documents.
+// When no sample is given, the first batch encoded is used as sample.
+// Fields missing from an inferred schema and values their column cannot hold are reported, not written.
+type arrowSchema struct {
+	dropped  *fieldReport
+	inferred bool
+
+	mu     sync.Mutex
+	schema *arrow.Schema
+	sample []*bson.M
+}
+
+// Loads schema file or keeps the sample to infer it later
+func newArrowSchema(schemaFile string, sample []*bson.M, onDropped func(field string, reason string)) (*arrowSchema, error) {
+	if schemaFile == "" {
+		return &arrowSchema{sample: sample, dropped: newFieldReport(onDropped), inferred: true}, nil
+	}
+	schema, err := readArrowSchema(schemaFile)
+	if err != nil {
+		return nil, err
+	}
+	return &arrowSchema{schema: schema, dropped: newFieldReport(onDropped)}, nil
+}
+
+// Returns the schema, inferring it when not set yet
+func (s *arrowSchema) get(rows []map[string]any) *arrow.Schema {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.schema == nil {
+		if len(s.sample) > 0 {
+			rows = flattenAll(s.sample)
+			s.sample = nil
+		}
+		s.schema = inferArrowSchema(rows)
+	}
+	return s.schema
+}
+
+// Flattens every document of a batch
+func flattenAll(results []*bson.M) []map[string]any {
+	rows := make([]map[string]any, len(results))
+	for i, doc := range results {
+		rows[i] = flatten(*doc, false)
+	}
+	return rows
+}
+
+// Reads a schema file: a json object mapping columns to type names, in order.
+// e.g.: {"_id": "objectid", "createdAt": "timestamp", "amount": "decimal(38,2)", "address.city": "string"}
+func readArrowSchema(schemaFile string) (*arrow.Schema, error) {
+	data, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// bson.D keeps columns order
+	var spec bson.D
+	if err = bson.UnmarshalExtJSON(data, false, &spec); err != nil {
+		return nil, fmt.Errorf("invalid schema file %s: %w", schemaFile, err)
+	}
+
+	fields := make([]arrow.Field, len(spec))
+	for i, elem := range spec {
+		name, ok := elem.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid schema file %s: type of %q must be a string", schemaFile, elem.Key)
+		}
+		dataType, err := arrowTypeFromName(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema file %s: column %q: %w", schemaFile, elem.Key, err)
+		}
+		fields[i] = arrow.Field{Name: elem.Key, Type: dataType, Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// Parses a type name used in schema files
+func arrowTypeFromName(name string) (arrow.DataType, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "string", "json":
+		return arrow.BinaryTypes.String, nil
+	case "bool", "boolean":
+		return arrow.FixedWidthTypes.Boolean, nil
+	case "int32", "int":
+		return arrow.PrimitiveTypes.Int32, nil
+	case "int64", "long":
+		return arrow.PrimitiveTypes.Int64, nil
+	case "double", "float64":
+		return arrow.PrimitiveTypes.Float64, nil
+	case "timestamp", "date":
+		return timestampType, nil
+	case "objectid":
+		return objectIdType, nil
+	case "binary":
+		return arrow.BinaryTypes.Binary, nil
+	case "decimal":
+		return &arrow.Decimal128Type{Precision: maxDecimalPrecision, Scale: 0}, nil
+	}
+
+	// decimal(precision,scale)
+	if strings.HasPrefix(name, "decimal(") && strings.HasSuffix(name, ")") {
+		params := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, "decimal("), ")"), ",")
+		if len(params) == 2 {
+			precision, perr := strconv.Atoi(strings.TrimSpace(params[0]))
+			scale, serr := strconv.Atoi(strings.TrimSpace(params[1]))
+			if perr == nil && serr == nil && precision > 0 && precision <= maxDecimalPrecision && scale >= 0 && scale <= precision {
+				return &arrow.Decimal128Type{Precision: int32(precision), Scale: int32(scale)}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+// Value kinds used during schema inference
+type valueKind int
+
+const (
+	kindNull valueKind = iota
+	kindBool
+	kindInt32
+	kindInt64
+	kindDouble
+	kindDecimal
+	kindTimestamp
+	kindObjectId
+	kindBinary
+	kindString
+)
+
+func kindOf(value any) valueKind {
+	switch value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return kindNull
+	case bool:
+		return kindBool
+	case int32:
+		return kindInt32
+	case int64, int:
+		return kindInt64
+	case float64:
+		return kindDouble
+	case primitive.Decimal128:
+		return kindDecimal
+	case primitive.DateTime, primitive.Timestamp:
+		return kindTimestamp
+	case primitive.ObjectID:
+		return kindObjectId
+	case primitive.Binary:
+		return kindBinary
+	}
+	return kindString
+}
+
+// Widens two kinds into one able to hold both, falling back to string
+func mergeKinds(a, b valueKind) valueKind {
+	switch {
+	case a == b || b == kindNull:
+		return a
+	case a == kindNull:
+		return b
+	case isInteger(a) && isInteger(b):
+		return kindInt64
+	case (isInteger(a) || a == kindDouble) && (isInteger(b) || b == kindDouble):
+		return kindDouble
+	case (isInteger(a) || a == kindDecimal) && (isInteger(b) || b == kindDecimal):
+		return kindDecimal
+	}
+	return kindString
+}
+
+func isInteger(k valueKind) bool {
+	return k == kindInt32 || k == kindInt64
+}
+
+// Number of decimal places of a Decimal128
+func decimalScale(value primitive.Decimal128) int {
+	_, exp, err := value.BigInt()
+	if err != nil || exp >= 0 {
+		return 0
+	}
+	return -exp
+}
+
+// Infers a schema from flattened rows: columns follow csv ordering (sorted, _id first)
+func inferArrowSchema(rows []map[string]any) *arrow.Schema {
+	columns := inferColumns(rows)
+	fields := make([]arrow.Field, len(columns))
+
+	for i, col := range columns {
+		kind := kindNull
+		scale := 0
+		for _, row := range rows {
+			value := row[col]
+			kind = mergeKinds(kind, kindOf(value))
+			if dec, ok := value.(primitive.Decimal128); ok {
+				if s := decimalScale(dec); s > scale {
+					scale = s
+				}
+			}
+		}
+		fields[i] = arrow.Field{Name: col, Type: kindToArrowType(kind, scale), Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+func kindToArrowType(kind valueKind, scale int) arrow.DataType {
+	switch kind {
+	case kindBool:
+		return arrow.FixedWidthTypes.Boolean
+	case kindInt32:
+		return arrow.PrimitiveTypes.Int32
+	case kindInt64:
+		return arrow.PrimitiveTypes.Int64
+	case kindDouble:
+		return arrow.PrimitiveTypes.Float64
+	case kindDecimal:
+		if scale > maxDecimalPrecision {
+			scale = maxDecimalPrecision
+		}
+		return &arrow.Decimal128Type{Precision: maxDecimalPrecision, Scale: int32(scale)}
+	case kindTimestamp:
+		return timestampType
+	case kindObjectId:
+		return objectIdType
+	case kindBinary:
+		return arrow.BinaryTypes.Binary
+	}
+	return arrow.BinaryTypes.String
+}
+
+// Builds an arrow record out of flattened rows. Values a column cannot hold are written as null and
+// reported, along with fields missing from an inferred schema. Caller must release the record.
+func (s *arrowSchema) build(schema *arrow.Schema, rows []map[string]any) (arrow.Record, error) {
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	dropped := s.dropped
+	if dropped != nil && s.inferred {
+		for _, row := range rows {
+			for key := range row {
+				if _, found := schema.FieldsByName(key); !found {
+					dropped.drop(key, "not found in sampled documents, set --schema-file to write it")
+				}
+			}
+		}
+	}
+	for i, field := range schema.Fields() {
+		column := builder.Field(i)
+		column.Reserve(len(rows))
+		for _, row := range rows {
+			value := row[field.Name]
+			err := appendValue(column, value)
+			if errors.Is(err, errCannotConvert) {
+				dropped.drop(field.Name, fmt.Sprintf("%T values do not fit its %s column, they are written as null", value, column.Type()))
+				column.AppendNull()
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("column %q: %w", field.Name, err)
+			}
+		}
+	}
+	return builder.NewRecord(), nil
+}
+
+// Appends a bson value into a column builder, converting it to column's type
+func appendValue(column array.Builder, value any) error {
+	if kindOf(value) == kindNull {
+		column.AppendNull()
+		return nil
+	}
+
+	switch b := column.(type) {
+	case *array.StringBuilder:
+		formatted, err := formatValue(value)
+		if err != nil {
+			return err
+		}
+		b.Append(formatted)
+		return nil
+	case *array.BooleanBuilder:
+		if v, ok := value.(bool); ok {
+			b.Append(v)
+			return nil
+		}
+	case *array.Int32Builder:
+		if v, ok := toInt64(value); ok && v >= -1<<31 && v < 1<<31 {
+			b.Append(int32(v))
+			return nil
+		}
+	case *array.Int64Builder:
+		if v, ok := toInt64(value); ok {
+			b.Append(v)
+			return nil
+		}
+	case *array.Float64Builder:
+		if v, ok := toInt64(value); ok {
+			b.Append(float64(v))
+			return nil
+		}
+		switch v := value.(type) {
+		case float64:
+			b.Append(v)
+			return nil
+		case primitive.Decimal128:
+			if f, err := strconv.ParseFloat(v.String(), 64); err == nil {
+				b.Append(f)
+				return nil
+			}
+		}
+	case *array.TimestampBuilder:
+		switch v := value.(type) {
+		case primitive.DateTime:
+			b.Append(arrow.Timestamp(v))
+			return nil
+		case primitive.Timestamp:
+			b.Append(arrow.Timestamp(int64(v.T) * 1000))
+			return nil
+		case time.Time:
+			b.Append(arrow.Timestamp(v.UnixMilli()))
+			return nil
+		}
+	case *array.Decimal128Builder:
+		scale := b.Type().(*arrow.Decimal128Type).Scale
+		if n, err := toDecimal128(value, scale); err == nil {
+			b.Append(n)
+			return nil
+		} else if err != errNotDecimal {
+			return err
+		}
+	case *array.FixedSizeBinaryBuilder:
+		if v, ok := value.(primitive.ObjectID); ok {
+			b.Append(v[:])
+			return nil
+		}
+	case *array.BinaryBuilder:
+		switch v := value.(type) {
+		case primitive.Binary:
+			b.Append(v.Data)
+			return nil
+		case primitive.ObjectID:
+			b.Append(v[:])
+			return nil
+		case []byte:
+			b.Append(v)
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported column type %s", column.Type())
+	}
+	return fmt.Errorf("%w %T to %s", errCannotConvert, value, column.Type())
+}
+
+var errCannotConvert = errors.New("cannot convert")
+
+// Renders any value as a string: scalars like csv cells, containers as relaxed extended json
+func formatValue(value any) (string, error) {
+	switch value.(type) {
+	case primitive.A, primitive.M, primitive.D:
+		return toRelaxedJson(value)
+	}
+	return formatScalar(value)
+}
+
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+var errNotDecimal = errors.New("not a decimal")
+
+// Converts integers and Decimal128 values into a decimal with the given scale (rounding half away from zero)
+func toDecimal128(value any, scale int32) (decimal128.Num, error) {
+	var unscaled *big.Int
+	var exp int
+
+	switch v := value.(type) {
+	case primitive.Decimal128:
+		var err error
+		if unscaled, exp, err = v.BigInt(); err != nil {
+			return decimal128.Num{}, err
+		}
+	default:
+		n, ok := toInt64(value)
+		if !ok {
+			return decimal128.Num{}, errNotDecimal
+		}
+		unscaled = big.NewInt(n)
+	}
+
+	// value = unscaled * 10^exp, target = value * 10^scale
+	shift := exp + int(scale)
+	if shift >= 0 {
+		unscaled.Mul(unscaled, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(shift)), nil))
+	} else {
+		divisor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-shift)), nil)
+		quotient, remainder := new(big.Int).QuoRem(unscaled, divisor, new(big.Int))
+		if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(divisor) >= 0 {
+			quotient.Add(quotient, big.NewInt(int64(unscaled.Sign())))
+		}
+		unscaled = quotient
+	}
+
+	if unscaled.BitLen() > 127 {
+		return decimal128.Num{}, fmt.Errorf("decimal %v overflows 128 bits", value)
+	}
+	return decimal128.FromBigInt(unscaled), nil
+}
@@ -0,0 +1,527 @@
+package files
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Block codecs accepted by avro output
+const (
+	AvroCodecNull    string = goavro.CompressionNullLabel
+	AvroCodecDeflate string = goavro.CompressionDeflateLabel
+	AvroCodecSnappy  string = goavro.CompressionSnappyLabel
+)
+
+// Name of the top level avro record
+const avroRecordName = "Document"
+
+// Avro branches a value can be written to. Names match goavro union type names.
+const (
+	avroBoolean   = "boolean"
+	avroInt       = "int"
+	avroLong      = "long"
+	avroDouble    = "double"
+	avroDecimal   = "bytes.decimal"
+	avroTimestamp = "long.timestamp-millis"
+	avroString    = "string"
+	avroBytes     = "bytes"
+	avroRecord    = "record"
+	avroArray     = "array"
+)
+
+// Order of branches inside unions (null always comes first)
+var avroBranchOrder = []string{avroBoolean, avroInt, avroLong, avroDouble, avroDecimal, avroTimestamp, avroString, avroBytes, avroRecord, avroArray}
+
+// Apache Avro object container files, one file per chunk, each one embedding the writer schema.
+// Nested documents become nested records, arrays become avro arrays and fields holding values of
+// different types become unions. Every field is nullable (null branch first, defaults to null).
+// ObjectIds are written as hex strings, dates as timestamp-millis and Decimal128 as decimal.
+// Check the specs: https://avro.apache.org/docs/1.11.1/specification/
+type avroEncoder struct {
+	codec   string
+	dropped *fieldReport
+
+	mu     sync.Mutex
+	schema *avroSchema
+	sample []*bson.M
+}
+
+// Schema shared by every worker, so all chunk files are written with the same schema
+type avroSchema struct {
+	root  *avroShape
+	codec *goavro.Codec
+}
+
+func newAvroEncoder(codec string, sample []*bson.M, onDropped func(field string, reason string)) (*avroEncoder, error) {
+	switch codec {
+	case "":
+		codec = AvroCodecNull
+	case AvroCodecNull, AvroCodecDeflate, AvroCodecSnappy:
+	default:
+		return nil, fmt.Errorf("unknown avro codec: %q", codec)
+	}
+	return &avroEncoder{codec: codec, sample: sample, dropped: newFieldReport(onDropped)}, nil
+}
+
+func (e *avroEncoder) Extension() string {
+	return "avro"
+}
+
+func (e *avroEncoder) Encode(w io.Writer, results []*bson.M) error {
+	stream, _ := e.NewStream(w)
+	if err := stream.Write(results); err != nil {
+		return err
+	}
+	return stream.Close()
+}
+
+func (e *avroEncoder) NewStream(w io.Writer) (StreamWriter, error) {
+	return &avroStream{encoder: e, w: w}, nil
+}
+
+// Avro file written batch after batch, every batch is appended as a block
+type avroStream struct {
+	encoder *avroEncoder
+	w       io.Writer
+	schema  *avroSchema
+	writer  *goavro.OCFWriter
+}
+
+func (s *avroStream) Write(results []*bson.M) error {
+	if err := s.start(results); err != nil {
+		return err
+	}
+
+	records := make([]any, len(results))
+	for i, doc := range results {
+		var err error
+		if records[i], err = s.schema.root.record(*doc, "", s.encoder.dropped); err != nil {
+			return err
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return s.writer.Append(records)
+}
+
+// Writes the file header, schema is inferred from the first batch when not set yet
+func (s *avroStream) start(results []*bson.M) error {
+	if s.writer != nil {
+		return nil
+	}
+	schema, err := s.encoder.getSchema(results)
+	if err != nil {
+		return err
+	}
+	// w must not be an *os.File, goavro would append to an existing file instead of writing a new header
+	writer, err := goavro.NewOCFWriter(goavro.OCFConfig{W: s.w, Codec: schema.codec, CompressionName: s.encoder.codec})
+	if err != nil {
+		return err
+	}
+	s.schema, s.writer = schema, writer
+	return nil
+}
+
+// Blocks are written as they are appended, only a file without documents still needs its header
+func (s *avroStream) Close() error {
+	return s.start(nil)
+}
+
+// Returns the schema, inferring it from sample (or from the first batch) when not set yet
+func (e *avroEncoder) getSchema(results []*bson.M) (*avroSchema, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.schema != nil {
+		return e.schema, nil
+	}
+	if len(e.sample) > 0 {
+		results = e.sample
+		e.sample = nil
+	}
+
+	schema, err := inferAvroSchema(results)
+	if err != nil {
+		return nil, err
+	}
+	e.schema = schema
+	return schema, nil
+}
+
+// Infers a record schema out of documents and compiles it
+func inferAvroSchema(results []*bson.M) (*avroSchema, error) {
+	root := newAvroShape()
+	for _, doc := range results {
+		root.observeFields(*doc)
+	}
+
+	definition := root.recordSchema(avroRecordName, map[string]bool{})
+	data, err := json.Marshal(definition)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := goavro.NewCodec(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %w", err)
+	}
+	return &avroSchema{root: root, codec: codec}, nil
+}
+
+// Inferred shape of a value: avro types seen for it, fields of nested documents and shape of array items
+type avroShape struct {
+	types  map[string]bool
+	scale  int
+	fields map[string]*avroShape
+	items  *avroShape
+
+	// Filled when the schema is built
+	branches []string
+	name     string
+	keys     []string
+	names    map[string]string
+}
+
+func newAvroShape() *avroShape {
+	return &avroShape{types: map[string]bool{}}
+}
+
+// Records the type of a value
+func (s *avroShape) observe(value any) {
+	switch v := value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return
+	case primitive.M:
+		s.observeFields(v)
+	case map[string]any:
+		s.observeFields(v)
+	case primitive.D:
+		s.observeFields(v.Map())
+	case primitive.A:
+		s.types[avroArray] = true
+		if s.items == nil {
+			s.items = newAvroShape()
+		}
+		for _, item := range v {
+			s.items.observe(item)
+		}
+	default:
+		s.types[avroScalarType(value)] = true
+		if dec, ok := value.(primitive.Decimal128); ok {
+			if scale := decimalScale(dec); scale > s.scale {
+				s.scale = scale
+			}
+		}
+	}
+}
+
+func (s *avroShape) observeFields(doc map[string]any) {
+	s.types[avroRecord] = true
+	if s.fields == nil {
+		s.fields = map[string]*avroShape{}
+	}
+	for key, value := range doc {
+		field, ok := s.fields[key]
+		if !ok {
+			field = newAvroShape()
+			s.fields[key] = field
+		}
+		field.observe(value)
+	}
+}
+
+// Avro type a scalar is written to. Types without an avro counterpart are written as strings.
+func avroScalarType(value any) string {
+	switch value.(type) {
+	case bool:
+		return avroBoolean
+	case int32:
+		return avroInt
+	case int64, int:
+		return avroLong
+	case float64:
+		return avroDouble
+	case primitive.Decimal128:
+		return avroDecimal
+	case primitive.DateTime, primitive.Timestamp, time.Time:
+		return avroTimestamp
+	case primitive.Binary, []byte:
+		return avroBytes
+	}
+	return avroString
+}
+
+// Builds the union of every type seen for a value (null first).
+// Branches avro does not allow together in a union are merged: int into long,
+// dates into long (epoch millis) and decimals into string when bytes are also seen.
+func (s *avroShape) unionSchema(path string, names map[string]bool) []any {
+	types := make(map[string]bool, len(s.types))
+	for t := range s.types {
+		types[t] = true
+	}
+	if types[avroInt] && types[avroLong] {
+		delete(types, avroInt)
+	}
+	if types[avroTimestamp] && types[avroLong] {
+		delete(types, avroTimestamp)
+	}
+	if types[avroDecimal] && types[avroBytes] {
+		delete(types, avroDecimal)
+		types[avroString] = true
+	}
+	// Only nulls seen (or empty arrays)
+	if len(types) == 0 {
+		types[avroString] = true
+	}
+
+	union := []any{"null"}
+	s.branches = nil
+	for _, t := range avroBranchOrder {
+		if !types[t] {
+			continue
+		}
+		s.branches = append(s.branches, t)
+		switch t {
+		case avroDecimal:
+			scale := s.scale
+			if scale > maxDecimalPrecision {
+				scale = maxDecimalPrecision
+			}
+			union = append(union, map[string]any{"type": "bytes", "logicalType": "decimal", "precision": maxDecimalPrecision, "scale": scale})
+		case avroTimestamp:
+			union = append(union, map[string]any{"type": "long", "logicalType": "timestamp-millis"})
+		case avroRecord:
+			union = append(union, s.recordSchema(path, names))
+		case avroArray:
+			union = append(union, map[string]any{"type": "array", "items": s.items.unionSchema(path+"_item", names)})
+		default:
+			union = append(union, t)
+		}
+	}
+	return union
+}
+
+// Builds a record out of document fields (sorted, _id first). Record names must be unique in a schema.
+func (s *avroShape) recordSchema(path string, names map[string]bool) map[string]any {
+	s.name = uniqueName(avroName(path), names)
+
+	s.keys = make([]string, 0, len(s.fields))
+	for key := range s.fields {
+		s.keys = append(s.keys, key)
+	}
+	sort.Slice(s.keys, func(i, j int) bool {
+		if s.keys[i] == "_id" || s.keys[j] == "_id" {
+			return s.keys[i] == "_id"
+		}
+		return s.keys[i] < s.keys[j]
+	})
+
+	fieldNames := map[string]bool{}
+	s.names = make(map[string]string, len(s.keys))
+	fields := make([]any, len(s.keys))
+	for i, key := range s.keys {
+		name := uniqueName(avroName(key), fieldNames)
+		s.names[key] = name
+		fields[i] = map[string]any{
+			"name":    name,
+			"type":    s.fields[key].unionSchema(s.name+"_"+name, names),
+			"default": nil,
+		}
+	}
+	return map[string]any{"type": "record", "name": s.name, "fields": fields}
+}
+
+// Replaces characters not allowed in avro names ([A-Za-z_][A-Za-z0-9_]*) with underscores
+func avroName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// Appends a numeric suffix to names already taken
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = name + "_" + strconv.Itoa(i)
+	}
+	taken[unique] = true
+	return unique
+}
+
+// Converts a document into goavro native record. Fields missing from schema are dropped and reported
+// (path is the dotted path of the document).
+func (s *avroShape) record(doc map[string]any, path string, dropped *fieldReport) (map[string]any, error) {
+	record := make(map[string]any, len(s.keys))
+	for _, key := range s.keys {
+		value, err := s.fields[key].native(doc[key], joinKey(path, key), dropped)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", key, err)
+		}
+		record[s.names[key]] = value
+	}
+	if dropped != nil {
+		for key := range doc {
+			if _, found := s.fields[key]; !found {
+				dropped.drop(joinKey(path, key), "not found in sampled documents")
+			}
+		}
+	}
+	return record, nil
+}
+
+// Converts a value into a union value, picking the first branch able to hold it.
+// Values no branch can hold are written as null and reported.
+func (s *avroShape) native(value any, path string, dropped *fieldReport) (any, error) {
+	var candidates []string
+	switch value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return nil, nil
+	case primitive.M, map[string]any, primitive.D:
+		candidates = []string{avroRecord, avroString}
+	case primitive.A:
+		candidates = []string{avroArray, avroString}
+	case bool:
+		candidates = []string{avroBoolean, avroString}
+	case int32:
+		candidates = []string{avroInt, avroLong, avroDouble, avroDecimal, avroString}
+	case int64, int:
+		candidates = []string{avroLong, avroDecimal, avroDouble, avroString}
+	case float64:
+		candidates = []string{avroDouble, avroString}
+	case primitive.Decimal128:
+		candidates = []string{avroDecimal, avroString}
+	case primitive.DateTime, primitive.Timestamp, time.Time:
+		candidates = []string{avroTimestamp, avroLong, avroString}
+	case primitive.Binary, []byte:
+		candidates = []string{avroBytes, avroString}
+	default:
+		candidates = []string{avroString}
+	}
+
+	for _, branch := range candidates {
+		if !s.hasBranch(branch) {
+			continue
+		}
+		converted, err := s.convert(branch, value, path, dropped)
+		if err != nil {
+			return nil, err
+		}
+		name := branch
+		if branch == avroRecord {
+			name = s.name
+		}
+		return goavro.Union(name, converted), nil
+	}
+	dropped.drop(path, fmt.Sprintf("%T values do not fit its avro union %v, they are written as null", value, s.branches))
+	return nil, nil
+}
+
+func (s *avroShape) hasBranch(branch string) bool {
+	for _, b := range s.branches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// Converts a value into goavro native type of a branch
+func (s *avroShape) convert(branch string, value any, path string, dropped *fieldReport) (any, error) {
+	switch branch {
+	case avroString:
+		return formatValue(value)
+	case avroRecord:
+		switch v := value.(type) {
+		case primitive.M:
+			return s.record(v, path, dropped)
+		case map[string]any:
+			return s.record(v, path, dropped)
+		case primitive.D:
+			return s.record(v.Map(), path, dropped)
+		}
+	case avroArray:
+		items := value.(primitive.A)
+		converted := make([]any, len(items))
+		for i, item := range items {
+			var err error
+			if converted[i], err = s.items.native(item, path, dropped); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	case avroInt:
+		return value, nil
+	case avroLong:
+		if v, ok := toInt64(value); ok {
+			return v, nil
+		}
+		return toEpochMillis(value), nil
+	case avroDouble:
+		if v, ok := toInt64(value); ok {
+			return float64(v), nil
+		}
+		return value, nil
+	case avroDecimal:
+		scale := s.scale
+		if scale > maxDecimalPrecision {
+			scale = maxDecimalPrecision
+		}
+		n, err := toDecimal128(value, int32(scale))
+		if err != nil {
+			return nil, err
+		}
+		denominator := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+		return new(big.Rat).SetFrac(n.BigInt(), denominator), nil
+	case avroTimestamp:
+		return toEpochMillis(value), nil
+	case avroBytes:
+		switch v := value.(type) {
+		case primitive.Binary:
+			return v.Data, nil
+		case []byte:
+			return v, nil
+		}
+	case avroBoolean:
+		return value, nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to avro %s", value, branch)
+}
+
+// Milliseconds since epoch of a date value
+func toEpochMillis(value any) int64 {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return int64(v)
+	case primitive.Timestamp:
+		return int64(v.T) * 1000
+	case time.Time:
+		return v.UnixMilli()
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+package files
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/linkedin/goavro/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func readAvro(t testing.TB, data []byte) (*goavro.OCFReader, []map[string]any) {
+	reader, err := goavro.NewOCFReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]any
+	for reader.Scan() {
+		record, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record.(map[string]any))
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return reader, records
+}
+
+func TestAvroInferredSchema(t *testing.T) {
+	for _, codec := range []string{AvroCodecNull, AvroCodecDeflate, AvroCodecSnappy} {
+		t.Run(codec, func(t *testing.T) {
+			encoder, err := NewEncoder(Options{Format: constants.FormatAvro, AvroCodec: codec})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			docs := sampleDocuments(t)
+			if err := encoder.Encode(&buf, docs); err != nil {
+				t.Fatal(err)
+			}
+
+			reader, records := readAvro(t, buf.Bytes())
+			if got := reader.CompressionName(); got != codec {
+				t.Errorf("Expected codec %s, got %s", codec, got)
+			}
+			if len(records) != len(docs) {
+				t.Fatalf("Expected %d records, got %d", len(docs), len(records))
+			}
+
+			first := records[0]
+			id := (*docs[0])["_id"].(primitive.ObjectID)
+			if got := first["_id"].(map[string]any)["string"]; got != id.Hex() {
+				t.Errorf("Expected _id %v, got %v", id, got)
+			}
+			created := first["created"].(map[string]any)["long.timestamp-millis"].(time.Time)
+			if !created.Equal(time.Date(2023, 9, 16, 10, 0, 0, 0, time.UTC)) {
+				t.Errorf("Unexpected created date %v", created)
+			}
+			amount := first["amount"].(map[string]any)["bytes.decimal"].(*big.Rat)
+			if got := amount.FloatString(4); got != "1234.5678" {
+				t.Errorf("Expected amount 1234.5678, got %s", got)
+			}
+			nested := first["nested"].(map[string]any)["Document_nested"].(map[string]any)
+			if got := nested["word"].(map[string]any)["string"]; got != "quaerat" {
+				t.Errorf("Expected nested word quaerat, got %v", got)
+			}
+			if first["tags"] != nil {
+				t.Errorf("Expected null tags, got %v", first["tags"])
+			}
+
+			tags := records[1]["tags"].(map[string]any)["array"].([]any)
+			if len(tags) != 2 || tags[1].(map[string]any)["string"] != "b" {
+				t.Errorf("Unexpected tags %v", tags)
+			}
+		})
+	}
+}
+
+func TestAvroMixedTypes(t *testing.T) {
+	encoder, err := NewEncoder(Options{Format: constants.FormatAvro})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []*bson.M{
+		{"value": int32(1), "bad-name": true},
+		{"value": "text"},
+		{"value": 1.5},
+	}
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	_, records := readAvro(t, buf.Bytes())
+	expected := []map[string]any{{"int": int32(1)}, {"string": "text"}, {"double": 1.5}}
+	for i, record := range records {
+		value := record["value"].(map[string]any)
+		for branch, v := range expected[i] {
+			if value[branch] != v {
+				t.Errorf("Record %d: expected %v, got %v", i, expected[i], value)
+			}
+		}
+	}
+	if records[0]["bad_name"] == nil {
+		t.Errorf("Expected field bad-name to be renamed bad_name, got %v", records[0])
+	}
+
+	// Schema is kept for later chunks, values not matching a branch are written as strings
+	buf.Reset()
+	if err := encoder.Encode(&buf, []*bson.M{{"value": true, "other": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	_, records = readAvro(t, buf.Bytes())
+	if got := records[0]["value"].(map[string]any)["string"]; got != "true" {
+		t.Errorf("Expected value written as string, got %v", records[0]["value"])
+	}
+	if _, ok := records[0]["other"]; ok {
+		t.Errorf("Expected field other to be dropped, got %v", records[0])
+	}
+}
+
+func TestAvroOutOfSample(t *testing.T) {
+	dropped := map[string]bool{}
+	sample := []*bson.M{{"age": int32(30), "address": primitive.M{"city": "Porto"}}}
+	encoder, err := NewEncoder(Options{Format: constants.FormatAvro, SchemaSample: sample, OnDroppedField: func(field string, reason string) {
+		dropped[field] = true
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A string has no branch in the int union, nested and top level fields were not sampled
+	var buf bytes.Buffer
+	docs := []*bson.M{{"age": "unknown", "address": primitive.M{"city": "Lisbon", "zip": "1000"}, "extra": true}}
+	if err := encoder.Encode(&buf, docs); err != nil {
+		t.Fatal(err)
+	}
+	_, records := readAvro(t, buf.Bytes())
+	if len(records) != 1 || records[0]["age"] != nil {
+		t.Errorf("Expected age written as null, got %v", records)
+	}
+	for _, field := range []string{"age", "address.zip", "extra"} {
+		if !dropped[field] {
+			t.Errorf("Expected %s to be reported, got %v", field, dropped)
+		}
+	}
+}
+
+func TestAvroUnknownCodec(t *testing.T) {
+	if _, err := NewEncoder(Options{Format: constants.FormatAvro, AvroCodec: "lzma"}); err == nil {
+		t.Error("Expected an error for an unknown codec")
+	}
+}
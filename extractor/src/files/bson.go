@@ -0,0 +1,164 @@
+package files
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Writes raw bson documents using mongodump directory layout:
+//
+//	<fileLocation>/<database>/<collection>.bson
+//	<fileLocation>/<database>/<collection>.metadata.json
+//
+// so the output folder can be restored with mongorestore.
+// All workers append to the same .bson file, documents are written as they come from the cursor.
+// When compressed, both files get the compression extension (gzip matches mongodump --gzip layout).
+type BsonDumper struct {
+	folder      string
+	collection  string
+	compression Compression
+
+	mu         sync.Mutex
+	file       *os.File
+	digest     *fileDigest
+	compressor io.WriteCloser
+	writer     *bufio.Writer
+	documents  int64
+	// First error met by workers, returned by Close
+	err error
+}
+
+// Creates <fileLocation>/<database>/<collection>.bson
+func NewBsonDumper(fileLocation, database, collection string, compression Compression) (*BsonDumper, error) {
+	if err := compression.Validate(); err != nil {
+		return nil, err
+	}
+
+	folder := filepath.Join(fileLocation, database)
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(filepath.Join(folder, collection+".bson"+compression.Extension()))
+	if err != nil {
+		return nil, err
+	}
+	digest := newFileDigest(file)
+	compressor, err := compression.NewWriter(digest)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &BsonDumper{
+		folder:      folder,
+		collection:  collection,
+		compression: compression,
+		file:        file,
+		digest:      digest,
+		compressor:  compressor,
+		writer:      bufio.NewWriter(compressor),
+	}, nil
+}
+
+// Writes <collection>.metadata.json (canonical extended json, as mongodump does)
+func (d *BsonDumper) WriteMetadata(metadata bson.D) error {
+	data, err := bson.MarshalExtJSON(metadata, true, false)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(d.folder, d.collection+".metadata.json"+d.compression.Extension()))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	compressor, err := d.compression.NewWriter(file)
+	if err != nil {
+		return err
+	}
+	if _, err = compressor.Write(data); err != nil {
+		return err
+	}
+	if err = compressor.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Appends a batch of documents to the .bson file.
+// Remaining params are kept to match the process function signature, output file name is fixed.
+func (d *BsonDumper) DumpToFile(results []bson.Raw, mapping string, filePrefix string, fileLocation string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, doc := range results {
+		if _, err := d.writer.Write(doc); err != nil {
+			return err
+		}
+		d.documents++
+	}
+	return nil
+}
+
+// Concurrent batch dumper appending raw documents to the .bson file, stops on the first error
+func (d *BsonDumper) DumpStreams(ctx context.Context, dataChannel <-chan []bson.Raw, mapping string, filePrefix string, fileLocation string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case batch, ok := <-dataChannel:
+			if !ok {
+				return nil
+			}
+			if err := d.DumpToFile(batch, mapping, filePrefix, fileLocation); err != nil {
+				d.fail(err)
+				return err
+			}
+		}
+	}
+}
+
+func (d *BsonDumper) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// Describes the .bson file for the run manifest (call it once closed)
+func (d *BsonDumper) Chunk() ManifestChunk {
+	return ManifestChunk{
+		ChunkId:   1,
+		File:      filepath.Join(filepath.Base(d.folder), filepath.Base(d.file.Name())),
+		Documents: d.documents,
+		Bytes:     d.digest.size,
+		Sha256:    d.digest.Sum(),
+	}
+}
+
+// Flushes and closes the .bson file, returns the first error met by workers
+func (d *BsonDumper) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.err
+	if err == nil {
+		err = d.writer.Flush()
+	}
+	if err == nil {
+		err = d.compressor.Close()
+	}
+	if closeErr := d.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
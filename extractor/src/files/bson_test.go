@@ -0,0 +1,88 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestBsonDumperLayout(t *testing.T) {
+	tempDir := t.TempDir()
+	dumper, err := NewBsonDumper(tempDir, "db", "records", Compression{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := bson.D{{Key: "indexes", Value: bson.A{}}, {Key: "collectionName", Value: "records"}}
+	if err := dumper.WriteMetadata(metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two workers appending to the same file
+	pipe := make(chan []bson.Raw)
+	var workers errgroup.Group
+	for i := 0; i < 2; i++ {
+		workers.Go(func() error {
+			return dumper.DumpStreams(context.Background(), pipe, "record", "", tempDir)
+		})
+	}
+	expected := 0
+	for i := 0; i < 10; i++ {
+		doc, err := bson.Marshal(bson.D{{Key: "b", Value: i}, {Key: "a", Value: "x"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pipe <- []bson.Raw{doc, doc}
+		expected += 2
+	}
+	close(pipe)
+	if err := workers.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dumper.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "db", "records.bson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bytes.NewReader(data)
+	count := 0
+	for {
+		doc, err := bson.ReadDocument(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Fields order is kept
+		if elems, _ := doc.Elements(); elems[0].Key() != "b" {
+			t.Errorf("Unexpected fields order: %s", doc)
+		}
+		count++
+	}
+	if count != expected {
+		t.Errorf("Expected %d documents, got %d", expected, count)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(tempDir, "db", "records.metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded bson.D
+	if err := bson.UnmarshalExtJSON(raw, true, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded[1].Value != "records" {
+		t.Errorf("Unexpected metadata: %v", decoded)
+	}
+}
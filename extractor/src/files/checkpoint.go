@@ -0,0 +1,118 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// File name of the checkpoint written next to extracted files
+const CheckpointFile = "checkpoint.json"
+
+// Suffix of files being written, renamed once complete
+const partialSuffix = ".partial"
+
+// Progress of a run, saved after chunks are written so a run that died can be resumed.
+// Documents are read in sort order, every document up to Last was written into Chunks.
+type Checkpoint struct {
+	RunId            string `json:"run_id"`
+	Mapping          string `json:"mapping"`
+	Database         string `json:"database"`
+	Collection       string `json:"collection"`
+	Query            string `json:"query"`
+	Projection       string `json:"projection,omitempty"`
+	Collation        string `json:"collation,omitempty"`
+	Hint             string `json:"hint,omitempty"`
+	IncrementalField string `json:"incremental_field,omitempty"`
+	// Filter run, watermark range included
+	Filter       string    `json:"filter"`
+	Sort         string    `json:"sort"`
+	Format       string    `json:"format"`
+	Compression  string    `json:"compression,omitempty"`
+	OutputPrefix string    `json:"output_prefix"`
+	ChunkSize    int32     `json:"chunk_size"`
+	PageSize     int32     `json:"page_size,omitempty"`
+	StartTime    time.Time `json:"start_time"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	// Extended json of the incremental field upper bound, saved once the run completes
+	Watermark string `json:"watermark,omitempty"`
+	// Extended json of the sort key values of the last document written (empty before the first chunk)
+	Last   string          `json:"last,omitempty"`
+	Chunks []ManifestChunk `json:"chunks"`
+}
+
+// Run values the checkpoint was written with
+func (c *Checkpoint) Run() RunInfo {
+	return RunInfo{RunId: c.RunId, Collection: c.Collection, Database: c.Database, Start: c.StartTime}
+}
+
+// Id of the last chunk written (0 before the first chunk)
+func (c *Checkpoint) LastChunkId() int64 {
+	if len(c.Chunks) == 0 {
+		return 0
+	}
+	return c.Chunks[len(c.Chunks)-1].ChunkId
+}
+
+// Writes checkpoint.json into fileLocation, replacing the previous one at once
+func (c *Checkpoint) Write(fileLocation string) error {
+	c.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(fileLocation, CheckpointFile)
+	if err = os.WriteFile(path+partialSuffix, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+partialSuffix, path)
+}
+
+// Reads checkpoint.json from fileLocation (nil when there is none)
+func ReadCheckpoint(fileLocation string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(fileLocation, CheckpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{}
+	if err = json.Unmarshal(data, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// Removes checkpoint.json from fileLocation, once a run completed
+func RemoveCheckpoint(fileLocation string) error {
+	err := os.Remove(filepath.Join(fileLocation, CheckpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Removes files a run was writing when it died (chunk files are renamed once complete)
+func RemovePartialFiles(fileLocation string) error {
+	entries, err := os.ReadDir(fileLocation)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), partialSuffix) {
+			if err = os.Remove(filepath.Join(fileLocation, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Path a chunk file is written under until complete, hidden so loaders matching a prefix skip it
+func partialPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+partialSuffix)
+}
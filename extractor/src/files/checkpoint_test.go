@@ -0,0 +1,115 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCheckpointFile(t *testing.T) {
+	tempDir := t.TempDir()
+	if checkpoint, err := ReadCheckpoint(tempDir); err != nil || checkpoint != nil {
+		t.Fatalf("Expected no checkpoint, got %v (%v)", checkpoint, err)
+	}
+
+	run := NewRunInfo("people", "crm")
+	checkpoint := &Checkpoint{RunId: run.RunId, Database: run.Database, Collection: run.Collection, StartTime: run.Start}
+	if checkpoint.LastChunkId() != 0 {
+		t.Errorf("Expected no chunk written, got %d", checkpoint.LastChunkId())
+	}
+	checkpoint.Last = `{"_id":{"$numberInt":"42"}}`
+	checkpoint.Chunks = []ManifestChunk{{ChunkId: 1, File: "users_000001.json"}, {ChunkId: 2, File: "users_000002.json"}}
+	if err := checkpoint.Write(tempDir); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadCheckpoint(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Run() != run {
+		t.Errorf("Expected run %v, got %v", run, read.Run())
+	}
+	if read.Last != checkpoint.Last || read.LastChunkId() != 2 {
+		t.Errorf("Unexpected position: %s after chunk %d", read.Last, read.LastChunkId())
+	}
+
+	if err := RemoveCheckpoint(tempDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveCheckpoint(tempDir); err != nil {
+		t.Errorf("Removing a missing checkpoint must not fail: %s", err)
+	}
+}
+
+func TestRemovePartialFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, name := range []string{".users_000003.json.partial", "users_000002.json"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := RemovePartialFiles(tempDir); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(tempDir)
+	if len(entries) != 1 || entries[0].Name() != "users_000002.json" {
+		t.Errorf("Expected only complete files left, got %v", entries)
+	}
+}
+
+func TestDumpStreamsResumed(t *testing.T) {
+	tempDir := t.TempDir()
+	var mu sync.Mutex
+	received := map[int64]int32{}
+	dumper, err := NewDumper(Options{
+		Format:      constants.FormatNdjson,
+		Run:         NewRunInfo("people", "crm"),
+		ChunkOffset: 5,
+		OnReceive: func(chunkId int64, batch []*bson.M) {
+			mu.Lock()
+			defer mu.Unlock()
+			received[chunkId] = (*batch[0])["batch"].(int32)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataChannel := make(chan []*bson.M)
+	var workers errgroup.Group
+	for i := 0; i < 3; i++ {
+		workers.Go(func() error {
+			return dumper.DumpStreams(context.Background(), dataChannel, "users", constants.MappingDefault, tempDir)
+		})
+	}
+	for i := 1; i <= 10; i++ {
+		dataChannel <- []*bson.M{{"batch": int32(i)}}
+	}
+	close(dataChannel)
+	if err := workers.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks are numbered after the ones already written, every batch is received along with its chunk id
+	for i := int64(1); i <= 10; i++ {
+		if received[i+5] != int32(i) {
+			t.Errorf("Chunk %d received batch %d", i+5, received[i+5])
+		}
+	}
+	entries, _ := os.ReadDir(tempDir)
+	if len(entries) != 10 {
+		t.Fatalf("Expected 10 files, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Name() < "users_000006" || filepath.Ext(entry.Name()) != ".ndjson" {
+			t.Errorf("Unexpected file %s", entry.Name())
+		}
+	}
+}
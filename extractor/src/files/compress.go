@@ -0,0 +1,88 @@
+package files
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression applied to output files, whatever their format
+type Compression struct {
+	// Codec name: gzip, zstd or snappy (check constants package). Files are not compressed when empty.
+	Codec string
+	// Compression level, 0 uses codec default
+	Level int
+}
+
+// Checks codec name and level
+func (c Compression) Validate() error {
+	switch c.Codec {
+	case "":
+		return nil
+	case constants.CompressGzip:
+		if c.Level < 0 || c.Level > gzip.BestCompression {
+			return fmt.Errorf("gzip compression level must be between 1 and %d, got %d", gzip.BestCompression, c.Level)
+		}
+		return nil
+	case constants.CompressZstd:
+		if c.Level < 0 || c.Level > 22 {
+			return fmt.Errorf("zstd compression level must be between 1 and 22, got %d", c.Level)
+		}
+		return nil
+	case constants.CompressSnappy:
+		if c.Level != 0 {
+			return fmt.Errorf("snappy compression has no levels, got %d", c.Level)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown compression: %q", c.Codec)
+}
+
+// Suffix appended to file names (e.g.: users.json.gz, users.ndjson.zst)
+func (c Compression) Extension() string {
+	switch c.Codec {
+	case constants.CompressGzip:
+		return ".gz"
+	case constants.CompressZstd:
+		return ".zst"
+	case constants.CompressSnappy:
+		return ".sz"
+	}
+	return ""
+}
+
+// Wraps w with a compressing writer. Closing it flushes compressed data but leaves w open.
+func (c Compression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c.Codec {
+	case "":
+		return nopWriteCloser{w}, nil
+	case constants.CompressGzip:
+		level := c.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case constants.CompressZstd:
+		level := zstd.SpeedDefault
+		if c.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	case constants.CompressSnappy:
+		// Framing format, so streams can be told apart from raw snappy blocks
+		return snappy.NewBufferedWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown compression: %q", c.Codec)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
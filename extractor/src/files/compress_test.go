@@ -0,0 +1,83 @@
+package files
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDumperCompression(t *testing.T) {
+	cases := []struct {
+		compression Compression
+		extension   string
+		reader      func(r io.Reader) (io.Reader, error)
+	}{
+		{Compression{Codec: constants.CompressGzip, Level: 9}, ".json.gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{Compression{Codec: constants.CompressZstd, Level: 19}, ".json.zst", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{Compression{Codec: constants.CompressSnappy}, ".json.sz", func(r io.Reader) (io.Reader, error) { return snappy.NewReader(r), nil }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.compression.Codec, func(t *testing.T) {
+			tempDir := t.TempDir()
+			dumper, err := NewDumper(Options{Format: constants.FormatJson, Compression: c.compression})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := dumper.DumpToFile(sampleDocuments(t), "records", constants.MappingDefault, tempDir); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), c.extension) {
+				t.Fatalf("Expected a single %s file, got %v", c.extension, entries)
+			}
+
+			file, err := os.Open(filepath.Join(tempDir, entries[0].Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			reader, err := c.reader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var docs []map[string]any
+			if err := json.NewDecoder(reader).Decode(&docs); err != nil {
+				t.Fatal(err)
+			}
+			if len(docs) != 2 {
+				t.Errorf("Expected 2 documents, got %d", len(docs))
+			}
+		})
+	}
+}
+
+func TestCompressionValidate(t *testing.T) {
+	invalid := []Compression{
+		{Codec: "lz4"},
+		{Codec: constants.CompressGzip, Level: 10},
+		{Codec: constants.CompressZstd, Level: 23},
+		{Codec: constants.CompressSnappy, Level: 1},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected an error for %+v", c)
+		}
+	}
+	if _, err := NewDumper(Options{Compression: Compression{Codec: "lz4"}}); err == nil {
+		t.Error("Expected an error for an unknown compression")
+	}
+}
@@ -0,0 +1,359 @@
+package files
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Comma separated values with a header row.
+// Nested documents are flattened into dotted columns (e.g.: address.city).
+// The encoder is shared by all workers, so every chunk file gets the same header:
+// either the columns set by the user or the ones inferred from sampled documents (from the first batch encoded
+// when nothing was sampled). Fields missing from inferred columns are reported.
+type csvEncoder struct {
+	arrayMode      string
+	arraySeparator string
+	dropped        *fieldReport
+
+	mu       sync.Mutex
+	columns  []string
+	inferred bool
+}
+
+func newCsvEncoder(columns []string, sample []*bson.M, arrayMode, arraySeparator string, onDropped func(field string, reason string)) (*csvEncoder, error) {
+	switch arrayMode {
+	case "":
+		arrayMode = constants.ArrayModeJson
+	case constants.ArrayModeJson, constants.ArrayModeJoin, constants.ArrayModeExpand:
+	default:
+		return nil, fmt.Errorf("unknown array mode: %q", arrayMode)
+	}
+	if arraySeparator == "" {
+		arraySeparator = "|"
+	}
+
+	// Ignoring blank entries (e.g.: trailing comma in flag)
+	var cols []string
+	for _, col := range columns {
+		if col = strings.TrimSpace(col); col != "" {
+			cols = append(cols, col)
+		}
+	}
+
+	if cols == nil && len(sample) > 0 {
+		rows := make([]map[string]any, len(sample))
+		for i, doc := range sample {
+			rows[i] = flatten(*doc, arrayMode == constants.ArrayModeExpand)
+		}
+		cols = inferColumns(rows)
+	}
+
+	return &csvEncoder{
+		arrayMode:      arrayMode,
+		arraySeparator: arraySeparator,
+		dropped:        newFieldReport(onDropped),
+		columns:        cols,
+		inferred:       len(columns) == 0,
+	}, nil
+}
+
+func (e *csvEncoder) Extension() string {
+	return "csv"
+}
+
+func (e *csvEncoder) Encode(w io.Writer, results []*bson.M) error {
+	stream, _ := e.NewStream(w)
+	if err := stream.Write(results); err != nil {
+		return err
+	}
+	return stream.Close()
+}
+
+func (e *csvEncoder) NewStream(w io.Writer) (StreamWriter, error) {
+	return &csvStream{encoder: e, writer: csv.NewWriter(w)}, nil
+}
+
+// Csv file written batch after batch, header is written along with the first batch
+type csvStream struct {
+	encoder *csvEncoder
+	writer  *csv.Writer
+	columns []string
+	known   map[string]bool
+}
+
+func (s *csvStream) Write(results []*bson.M) error {
+	e := s.encoder
+	rows := make([]map[string]any, len(results))
+	for i, doc := range results {
+		rows[i] = flatten(*doc, e.arrayMode == constants.ArrayModeExpand)
+	}
+	if err := s.writeHeader(rows); err != nil {
+		return err
+	}
+	s.checkDropped(rows)
+
+	record := make([]string, len(s.columns))
+	for r, row := range rows {
+		for i, col := range s.columns {
+			value, ok := row[col]
+			if !ok {
+				// Column may point to a whole nested document or array
+				value = lookupPath(*results[r], col)
+			}
+			formatted, err := e.format(value)
+			if err != nil {
+				return err
+			}
+			record[i] = formatted
+		}
+		if err := s.writer.Write(record); err != nil {
+			return err
+		}
+	}
+	// Rows do not pile up in the csv writer buffer
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *csvStream) writeHeader(rows []map[string]any) error {
+	if s.columns != nil {
+		return nil
+	}
+	s.columns = s.encoder.header(rows)
+	if s.columns == nil {
+		s.columns = []string{}
+	}
+	return s.writer.Write(s.columns)
+}
+
+// Reports fields of rows not covered by inferred columns (neither the field nor a parent document is a column)
+func (s *csvStream) checkDropped(rows []map[string]any) {
+	e := s.encoder
+	if !e.inferred || e.dropped == nil {
+		return
+	}
+	if s.known == nil {
+		s.known = make(map[string]bool, len(s.columns))
+		for _, col := range s.columns {
+			s.known[col] = true
+		}
+	}
+	for _, row := range rows {
+		for key := range row {
+			if !s.covered(key) {
+				e.dropped.drop(key, "not found in sampled documents, set --csv-columns to write it")
+			}
+		}
+	}
+}
+
+func (s *csvStream) covered(key string) bool {
+	for {
+		if s.known[key] {
+			return true
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return false
+		}
+		key = key[:i]
+	}
+}
+
+func (s *csvStream) Close() error {
+	if err := s.writeHeader(nil); err != nil {
+		return err
+	}
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// Returns the header, inferring it from rows when not set yet
+func (e *csvEncoder) header(rows []map[string]any) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.columns == nil {
+		e.columns = inferColumns(rows)
+	}
+	return e.columns
+}
+
+// Renders a single value into a csv cell
+func (e *csvEncoder) format(value any) (string, error) {
+	switch v := value.(type) {
+	case primitive.A:
+		if e.arrayMode == constants.ArrayModeJoin {
+			items := make([]string, len(v))
+			for i, item := range v {
+				formatted, err := e.format(item)
+				if err != nil {
+					return "", err
+				}
+				items[i] = formatted
+			}
+			return strings.Join(items, e.arraySeparator), nil
+		}
+		return toRelaxedJson(v)
+	case primitive.M, primitive.D:
+		return toRelaxedJson(v)
+	}
+	return formatScalar(value)
+}
+
+// Flattens a document into a single level map, nested documents keys are joined by dots.
+// Arrays are kept as values unless expandArrays is set, in which case each item
+// gets its own key using its index (e.g.: tags.0, tags.1).
+func flatten(doc map[string]any, expandArrays bool) map[string]any {
+	flat := make(map[string]any, len(doc))
+	flattenInto(flat, "", doc, expandArrays)
+	return flat
+}
+
+func flattenInto(flat map[string]any, prefix string, value any, expandArrays bool) {
+	switch v := value.(type) {
+	case primitive.M:
+		flattenMap(flat, prefix, v, expandArrays)
+	case map[string]any:
+		flattenMap(flat, prefix, v, expandArrays)
+	case primitive.D:
+		if len(v) == 0 && prefix != "" {
+			flat[prefix] = v
+		}
+		for _, elem := range v {
+			flattenInto(flat, joinKey(prefix, elem.Key), elem.Value, expandArrays)
+		}
+	case primitive.A:
+		if !expandArrays || len(v) == 0 {
+			flat[prefix] = v
+			return
+		}
+		for i, item := range v {
+			flattenInto(flat, joinKey(prefix, strconv.Itoa(i)), item, expandArrays)
+		}
+	default:
+		flat[prefix] = v
+	}
+}
+
+func flattenMap(flat map[string]any, prefix string, m map[string]any, expandArrays bool) {
+	// Empty documents are kept so the column still shows up
+	if len(m) == 0 && prefix != "" {
+		flat[prefix] = primitive.M{}
+	}
+	for key, item := range m {
+		flattenInto(flat, joinKey(prefix, key), item, expandArrays)
+	}
+}
+
+// Returns the value found at a dotted path (nil when missing)
+func lookupPath(doc map[string]any, path string) any {
+	var current any = doc
+	for _, key := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case primitive.M:
+			current = v[key]
+		case map[string]any:
+			current = v[key]
+		case primitive.D:
+			current = v.Map()[key]
+		case primitive.A:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil
+			}
+			current = v[index]
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// Sorted union of every key, _id always comes first
+func inferColumns(rows []map[string]any) []string {
+	seen := map[string]bool{}
+	columns := []string{}
+	for _, row := range rows {
+		for key := range row {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		if columns[i] == "_id" || columns[j] == "_id" {
+			return columns[i] == "_id"
+		}
+		return columns[i] < columns[j]
+	})
+	return columns
+}
+
+// Renders a non container bson value as a string.
+// ObjectIds are written in hex, dates in RFC3339 (UTC), binaries in base64.
+func formatScalar(value any) (string, error) {
+	switch v := value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case primitive.ObjectID:
+		return v.Hex(), nil
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano), nil
+	case primitive.Timestamp:
+		return time.Unix(int64(v.T), 0).UTC().Format(time.RFC3339), nil
+	case primitive.Decimal128:
+		return v.String(), nil
+	case primitive.Binary:
+		return base64.StdEncoding.EncodeToString(v.Data), nil
+	case primitive.Symbol:
+		return string(v), nil
+	case primitive.JavaScript:
+		return string(v), nil
+	case primitive.Regex:
+		return v.String(), nil
+	}
+	return toRelaxedJson(value)
+}
+
+// Relaxed extended json representation of any value
+func toRelaxedJson(value any) (string, error) {
+	// MarshalExtJSON only accepts documents, so value is wrapped and unwrapped
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+	if err != nil {
+		return "", err
+	}
+	s := string(data)
+	return strings.TrimSuffix(strings.TrimPrefix(s, `{"v":`), "}"), nil
+}
@@ -0,0 +1,156 @@
+package files
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func readCsv(t testing.TB, data []byte) [][]string {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func csvDocuments() []*bson.M {
+	id, _ := primitive.ObjectIDFromHex("65063a2c27b6b3d5da64db70")
+	return []*bson.M{
+		{"_id": id, "name": "Bob", "address": primitive.M{"city": "Lisbon", "zip": int32(1000)}, "tags": primitive.A{"a", "b"}},
+		{"_id": id, "name": "Ann", "extra": true},
+	}
+}
+
+func TestCsvInferredHeader(t *testing.T) {
+	encoder, err := NewEncoder(Options{Format: constants.FormatCsv})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, csvDocuments()); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readCsv(t, buf.Bytes())
+	expected := [][]string{
+		{"_id", "address.city", "address.zip", "extra", "name", "tags"},
+		{"65063a2c27b6b3d5da64db70", "Lisbon", "1000", "", "Bob", `["a","b"]`},
+		{"65063a2c27b6b3d5da64db70", "", "", "true", "Ann", ""},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %v, got %v", expected, records)
+	}
+}
+
+func TestCsvSameHeaderAcrossChunks(t *testing.T) {
+	encoder, err := NewEncoder(Options{Format: constants.FormatCsv})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first, second bytes.Buffer
+	if err := encoder.Encode(&first, []*bson.M{{"a": 1, "b": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(&second, []*bson.M{{"c": 3, "a": 4}}); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readCsv(t, second.Bytes())
+	if !reflect.DeepEqual(records, [][]string{{"a", "b"}, {"4", ""}}) {
+		t.Errorf("Unexpected second chunk: %v", records)
+	}
+}
+
+func TestCsvColumnsAndArrayModes(t *testing.T) {
+	cases := map[string][]string{
+		constants.ArrayModeJoin:   {"Bob", "a;b", "b", `{"city":"Lisbon"}`},
+		constants.ArrayModeExpand: {"Bob", `["a","b"]`, "b", `{"city":"Lisbon"}`},
+	}
+	for mode, expected := range cases {
+		encoder, err := NewEncoder(Options{
+			Format:         constants.FormatCsv,
+			Columns:        []string{"name", "tags", "tags.1", "address"},
+			ArrayMode:      mode,
+			ArraySeparator: ";",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		doc := bson.M{"name": "Bob", "address": primitive.M{"city": "Lisbon"}, "tags": primitive.A{"a", "b"}}
+		if err := encoder.Encode(&buf, []*bson.M{&doc}); err != nil {
+			t.Fatal(err)
+		}
+		records := readCsv(t, buf.Bytes())
+		if !reflect.DeepEqual(records[1], expected) {
+			t.Errorf("%s: expected %v, got %v", mode, expected, records[1])
+		}
+	}
+}
+
+func TestCsvUnknownArrayMode(t *testing.T) {
+	if _, err := NewEncoder(Options{Format: constants.FormatCsv, ArrayMode: "nope"}); err == nil {
+		t.Error("Expected an error for unknown array mode")
+	}
+}
+
+func TestCsvStreamSampledHeader(t *testing.T) {
+	// Field only found in the second batch shows up in the sample
+	sample := []*bson.M{{"a": 1}, {"a": 2, "b": true}}
+	encoder, err := NewEncoder(Options{Format: constants.FormatCsv, SchemaSample: sample})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	stream, err := encoder.NewStream(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, batch := range [][]*bson.M{{{"a": 1}}, {{"a": 2, "b": true}}} {
+		if err := stream.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readCsv(t, buf.Bytes())
+	if !reflect.DeepEqual(records, [][]string{{"a", "b"}, {"1", ""}, {"2", "true"}}) {
+		t.Errorf("Unexpected records: %v", records)
+	}
+}
+
+func TestCsvReportsDroppedFields(t *testing.T) {
+	var dropped []string
+	encoder, err := NewEncoder(Options{Format: constants.FormatCsv, OnDroppedField: func(field string, reason string) {
+		dropped = append(dropped, field)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first, second bytes.Buffer
+	if err := encoder.Encode(&first, []*bson.M{{"a": 1, "b": primitive.M{"c": 2}}}); err != nil {
+		t.Fatal(err)
+	}
+	// Later chunks are fixed to the first header
+	for i := 0; i < 2; i++ {
+		if err := encoder.Encode(&second, []*bson.M{{"a": 3, "b": primitive.M{"c": 4}, "d": 5}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(dropped, []string{"d"}) {
+		t.Errorf("Expected d to be reported once, got %v", dropped)
+	}
+}
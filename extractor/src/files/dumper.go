@@ -1,63 +1,332 @@
 package files
 
 import (
+	"bufio"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"os"
 	"sync"
+	"sync/atomic"
 
-	constants "github.com/farovictor/MongoDbExtractor/src/constants"
-	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+// Dumper holds the output settings shared by every worker of an extraction
+type Dumper struct {
+	encoder     Encoder
+	compression Compression
+	run         RunInfo
+	manifest    *Manifest
+	transform   *Transform
+	onChunk     func(chunk ManifestChunk)
+	onReceive   func(chunkId int64, batch []*bson.M)
+
+	// Serializes channel receives, so chunk ids follow the order batches were sent
+	mu      sync.Mutex
+	chunks  int64
+	workers int32
+}
+
+// Dumper used by package level functions (plain json)
+var defaultDumper = &Dumper{encoder: jsonEncoder{}, run: NewRunInfo("", "")}
+
+// Creates a dumper that writes files using the given output settings
+func NewDumper(opts Options) (*Dumper, error) {
+	if err := opts.Compression.Validate(); err != nil {
+		return nil, err
+	}
+	encoder, err := NewEncoder(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Dumper{
+		encoder:     encoder,
+		compression: opts.Compression,
+		run:         opts.Run,
+		manifest:    opts.Manifest,
+		transform:   opts.Transform,
+		onChunk:     opts.OnChunk,
+		onReceive:   opts.OnReceive,
+		chunks:      opts.ChunkOffset,
+	}, nil
+}
+
 // Simple dumper to write json files
 func DumpToJsonFile(results []*bson.M, mapping string, filePrefix string, fileLocation string) error {
-	// Defining final file name
-	var fP string
-	fileId, err := uuid.NewUUID()
-	if filePrefix == constants.MappingDefault {
-		if err != nil {
-			return err
-		}
-		fP = fmt.Sprintf("%s_%s", mapping, fileId)
-	} else {
-		fP = fmt.Sprintf("%s_%s", filePrefix, fileId)
+	return defaultDumper.DumpToFile(results, mapping, filePrefix, fileLocation)
+}
+
+// Writes a batch of documents into a new file using dumper's encoder (and compression).
+// File name is rendered from filePrefix template, with the next chunk id.
+func (d *Dumper) DumpToFile(results []*bson.M, mapping string, filePrefix string, fileLocation string) error {
+	vars := NameVars{Mapping: mapping, ChunkId: atomic.AddInt64(&d.chunks, 1), Run: d.run}
+	return d.writeChunk(results, vars, filePrefix, fileLocation)
+}
+
+// Checks if batches can be written as they come from the cursor (bson.Raw): the format converts raw
+// documents and no hook needs them decoded (transform, checkpoint positions)
+func (d *Dumper) Raw() bool {
+	_, ok := d.encoder.(RawEncoder)
+	return ok && d.transform == nil && d.onReceive == nil
+}
+
+// Same as DumpToFile, documents are converted from their raw bytes (check Raw)
+func (d *Dumper) DumpRawToFile(results []bson.Raw, mapping string, filePrefix string, fileLocation string) error {
+	vars := NameVars{Mapping: mapping, ChunkId: atomic.AddInt64(&d.chunks, 1), Run: d.run}
+	return d.writeRawChunk(results, vars, filePrefix, fileLocation)
+}
+
+// Writes a chunk file and records it into the manifest (failed chunks are recorded with their error)
+func (d *Dumper) writeChunk(results []*bson.M, vars NameVars, filePrefix string, fileLocation string) error {
+	chunk := ManifestChunk{ChunkId: vars.ChunkId, Documents: int64(len(results))}
+	err := d.transform.Apply(results)
+	if err == nil {
+		err = d.encodeChunk(&chunk, vars, filePrefix, fileLocation, func(w io.Writer) error {
+			return d.encoder.Encode(w, results)
+		})
 	}
+	return d.recordChunk(chunk, err)
+}
+
+// Same as writeChunk, for raw documents
+func (d *Dumper) writeRawChunk(results []bson.Raw, vars NameVars, filePrefix string, fileLocation string) error {
+	chunk := ManifestChunk{ChunkId: vars.ChunkId, Documents: int64(len(results))}
+	err := d.encodeChunk(&chunk, vars, filePrefix, fileLocation, func(w io.Writer) error {
+		return d.encoder.(RawEncoder).EncodeRaw(w, results)
+	})
+	return d.recordChunk(chunk, err)
+}
 
-	// Turn into json
-	jsonData, err := json.Marshal(results)
+func (d *Dumper) recordChunk(chunk ManifestChunk, err error) error {
 	if err != nil {
-		return err
+		chunk.Error = err.Error()
+	}
+	d.manifest.AddChunk(chunk)
+	if d.onChunk != nil {
+		d.onChunk(chunk)
 	}
+	return err
+}
 
-	outputFile := fmt.Sprintf("%s/%s.json", fileLocation, fP)
+func (d *Dumper) encodeChunk(chunk *ManifestChunk, vars NameVars, filePrefix string, fileLocation string, encode func(w io.Writer) error) error {
+	file, err := d.createChunk(chunk, vars, filePrefix, fileLocation)
+	if err != nil {
+		return err
+	}
+	defer file.discard()
 
-	if err = ioutil.WriteFile(outputFile, jsonData, 0644); err != nil {
+	if err = encode(file.writer); err != nil {
 		return err
 	}
+	return file.complete(chunk)
+}
+
+// Chunk file being written: encoded data goes through compression and digest, under a temporary name
+// until complete, so a file named after a chunk is always complete
+type chunkFile struct {
+	path       string
+	file       *os.File
+	digest     *fileDigest
+	compressor io.WriteCloser
+	writer     *bufio.Writer
+}
+
+// Creates the file of a chunk, named after filePrefix template
+func (d *Dumper) createChunk(chunk *ManifestChunk, vars NameVars, filePrefix string, fileLocation string) (*chunkFile, error) {
+	name, err := RenderName(filePrefix, vars)
+	if err != nil {
+		return nil, err
+	}
+	chunk.File = fmt.Sprintf("%s.%s%s", name, d.encoder.Extension(), d.compression.Extension())
 
+	path := fmt.Sprintf("%s/%s", fileLocation, chunk.File)
+	file, err := os.Create(partialPath(path))
+	if err != nil {
+		return nil, err
+	}
+	digest := newFileDigest(file)
+	compressor, err := d.compression.NewWriter(digest)
+	if err != nil {
+		file.Close()
+		os.Remove(partialPath(path))
+		return nil, err
+	}
+	return &chunkFile{path: path, file: file, digest: digest, compressor: compressor, writer: bufio.NewWriter(compressor)}, nil
+}
+
+// Flushes and closes the file, then gives it its final name
+func (f *chunkFile) complete(chunk *ManifestChunk) error {
+	if err := f.writer.Flush(); err != nil {
+		return err
+	}
+	if err := f.compressor.Close(); err != nil {
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(partialPath(f.path), f.path); err != nil {
+		return err
+	}
+	chunk.Bytes = f.digest.size
+	chunk.Sha256 = f.digest.Sum()
 	return nil
 }
 
+// Removes the file unless it was completed
+func (f *chunkFile) discard() {
+	f.file.Close()
+	os.Remove(partialPath(f.path))
+}
+
+// Writes a single file batch after batch (extract command): documents are encoded as they come, so memory
+// does not grow with the number of documents. The file is recorded as a single chunk once closed.
+type FileWriter struct {
+	dumper *Dumper
+	file   *chunkFile
+	stream StreamWriter
+	chunk  ManifestChunk
+	// First error met, no batch is written after it
+	err error
+}
+
+// Starts a single file, named after filePrefix template with the next chunk id
+func (d *Dumper) NewFileWriter(mapping string, filePrefix string, fileLocation string) (*FileWriter, error) {
+	w := &FileWriter{dumper: d, chunk: ManifestChunk{ChunkId: atomic.AddInt64(&d.chunks, 1)}}
+	file, err := d.createChunk(&w.chunk, NameVars{Mapping: mapping, ChunkId: w.chunk.ChunkId, Run: d.run}, filePrefix, fileLocation)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := d.encoder.NewStream(file.writer)
+	if err != nil {
+		file.discard()
+		return nil, err
+	}
+	w.file, w.stream = file, stream
+	return w, nil
+}
+
+// Appends a batch to the file (mapping, filePrefix and fileLocation were set when the file was created)
+func (w *FileWriter) DumpToFile(results []*bson.M, mapping string, filePrefix string, fileLocation string) error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.err = w.dumper.transform.Apply(results); w.err == nil {
+		w.err = w.stream.Write(results)
+	}
+	w.chunk.Documents += int64(len(results))
+	return w.err
+}
+
+// Same as DumpToFile, documents are converted from their raw bytes (check Raw)
+func (w *FileWriter) DumpRawToFile(results []bson.Raw, mapping string, filePrefix string, fileLocation string) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.stream.(RawStreamWriter).WriteRaw(results)
+	w.chunk.Documents += int64(len(results))
+	return w.err
+}
+
+// Ends the file and records it into the manifest. A file that failed is removed.
+func (w *FileWriter) Close() error {
+	err := w.err
+	if err == nil {
+		err = w.stream.Close()
+	}
+	if err == nil {
+		err = w.file.complete(&w.chunk)
+	}
+	w.file.discard()
+	if err != nil {
+		w.chunk.Error = err.Error()
+	}
+	w.dumper.manifest.AddChunk(w.chunk)
+	if w.dumper.onChunk != nil {
+		w.dumper.onChunk(w.chunk)
+	}
+	return err
+}
+
+// Removes the file written so far, when reading documents failed
+func (w *FileWriter) Abort() {
+	w.file.discard()
+}
+
 // Concurrent batch dumper to write json files through a channel
 // Params:
 //
-//	context: Context in which will run
+//	context: Context in which will run, the worker stops once it is done
 //	dataSource: Channel that will pass the data
 //	mapping: name for data contextualization, used in file name.
-//	wg: Waiting Group manager
 //	filePrefix: filePrefix name
 //	fileLocation: output path where file will be saved
 //
+// Returns the first error met writing a file, the worker stops on it.
+//
 // Further reading about concurrency patterns.
 // Concurrent design patterns: https://levelup.gitconnected.com/concurrency-design-patterns-in-golang-f0843f570689
 // secondary reading: https://blog.devgenius.io/5-useful-concurrency-patterns-in-golang-8dc90ad1ea61
-func DumpStreams(ctx context.Context, dataChannel <-chan []*bson.M, mapping string, wg *sync.WaitGroup, filePrefix string, fileLocation string) {
-	defer wg.Done()
-	for batch := range dataChannel {
-		// TODO: Implement this properly
-		DumpToJsonFile(batch, mapping, filePrefix, fileLocation)
+func DumpStreams(ctx context.Context, dataChannel <-chan []*bson.M, mapping string, filePrefix string, fileLocation string) error {
+	return defaultDumper.DumpStreams(ctx, dataChannel, mapping, filePrefix, fileLocation)
+}
+
+// Same as DumpStreams, using dumper's encoder
+func (d *Dumper) DumpStreams(ctx context.Context, dataChannel <-chan []*bson.M, mapping string, filePrefix string, fileLocation string) error {
+	workerId := int(atomic.AddInt32(&d.workers, 1))
+	for {
+		batch, chunkId, ok := d.receive(ctx, dataChannel)
+		if !ok {
+			return ctx.Err()
+		}
+		// Failed chunks are also recorded into the manifest
+		if err := d.writeChunk(batch, NameVars{Mapping: mapping, ChunkId: chunkId, WorkerId: workerId, Run: d.run}, filePrefix, fileLocation); err != nil {
+			return err
+		}
+	}
+}
+
+// Same as DumpStreams, documents are converted from their raw bytes (check Raw)
+func (d *Dumper) DumpRawStreams(ctx context.Context, dataChannel <-chan []bson.Raw, mapping string, filePrefix string, fileLocation string) error {
+	workerId := int(atomic.AddInt32(&d.workers, 1))
+	for {
+		d.mu.Lock()
+		batch, chunkId, ok := receiveChunk(ctx, &d.chunks, dataChannel)
+		d.mu.Unlock()
+		if !ok {
+			return ctx.Err()
+		}
+		// Failed chunks are also recorded into the manifest
+		if err := d.writeRawChunk(batch, NameVars{Mapping: mapping, ChunkId: chunkId, WorkerId: workerId, Run: d.run}, filePrefix, fileLocation); err != nil {
+			return err
+		}
+	}
+}
+
+// Receives the next batch along with its chunk id, unless ctx is done.
+// Only one worker waits on the channel at a time, so ids are given in the order batches were sent (cursor order).
+func (d *Dumper) receive(ctx context.Context, dataChannel <-chan []*bson.M) ([]*bson.M, int64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	batch, chunkId, ok := receiveChunk(ctx, &d.chunks, dataChannel)
+	if ok && d.onReceive != nil {
+		d.onReceive(chunkId, batch)
+	}
+	return batch, chunkId, ok
+}
+
+// Receives a batch and numbers it after chunks (the caller holds the dumper lock)
+func receiveChunk[T any](ctx context.Context, chunks *int64, dataChannel <-chan T) (T, int64, bool) {
+	var batch T
+	var ok bool
+	select {
+	case <-ctx.Done():
+	case batch, ok = <-dataChannel:
+	}
+	if !ok {
+		return batch, 0, false
 	}
+	return batch, atomic.AddInt64(chunks, 1), true
 }
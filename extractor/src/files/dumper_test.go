@@ -0,0 +1,435 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/sync/errgroup"
+)
+
+func sampleDocuments(t testing.TB) []*bson.M {
+	dec, err := primitive.ParseDecimal128("1234.5678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*bson.M{
+		{
+			"_id":     primitive.NewObjectID(),
+			"created": primitive.NewDateTimeFromTime(time.Date(2023, 9, 16, 10, 0, 0, 0, time.UTC)),
+			"amount":  dec,
+			"payload": primitive.Binary{Subtype: 0x00, Data: []byte("some bytes")},
+			"counter": int64(1) << 40,
+			"nested":  bson.M{"word": "quaerat", "latitude": 79.68},
+		},
+		{
+			"_id":  primitive.NewObjectID(),
+			"tags": bson.A{"a", "b"},
+		},
+	}
+}
+
+func TestExtJsonRoundTrip(t *testing.T) {
+	for _, format := range []string{constants.FormatCanonical, constants.FormatRelaxed} {
+		t.Run(format, func(t *testing.T) {
+			docs := sampleDocuments(t)
+			encoder, err := NewEncoder(Options{Format: format})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := encoder.Encode(&buf, docs); err != nil {
+				t.Fatal(err)
+			}
+
+			var raws []json.RawMessage
+			if err := json.Unmarshal(buf.Bytes(), &raws); err != nil {
+				t.Fatalf("Output is not a json array: %s", err)
+			}
+			if len(raws) != len(docs) {
+				t.Fatalf("Expected %d documents, got %d", len(docs), len(raws))
+			}
+
+			for i, raw := range raws {
+				decoded := bson.M{}
+				if err := bson.UnmarshalExtJSON(raw, false, &decoded); err != nil {
+					t.Fatal(err)
+				}
+				for key, expected := range *docs[i] {
+					if key == "nested" || key == "tags" {
+						continue
+					}
+					if !reflect.DeepEqual(decoded[key], expected) {
+						t.Errorf("Field %s: expected %#v (%T), got %#v (%T)", key, expected, expected, decoded[key], decoded[key])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDumperWritesFile(t *testing.T) {
+	tempDir := t.TempDir()
+	dumper, err := NewDumper(Options{Format: constants.FormatCanonical})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dumper.DumpToFile(sampleDocuments(t), "record", "test", tempDir); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(tempDir, "test_*.json"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected one file, found %v", matches)
+	}
+	if _, err := os.Stat(matches[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewDumper(Options{Format: "xml"}); err == nil {
+		t.Error("Expected an error for unknown format")
+	}
+}
+
+func TestNdjsonOneDocumentPerLine(t *testing.T) {
+	docs := sampleDocuments(t)
+	encoder, err := NewEncoder(Options{Format: constants.FormatNdjson})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(bytes.TrimRight(buf.Bytes(), "\n"), []byte("\n"))
+	if len(lines) != len(docs) {
+		t.Fatalf("Expected %d lines, got %d", len(docs), len(lines))
+	}
+	for i, line := range lines {
+		decoded := bson.M{}
+		if err := bson.UnmarshalExtJSON(line, false, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded["_id"] != (*docs[i])["_id"] {
+			t.Errorf("Line %d: expected _id %v, got %v", i, (*docs[i])["_id"], decoded["_id"])
+		}
+	}
+}
+
+func TestFileWriterFormats(t *testing.T) {
+	countRows := map[string]func(data []byte) int{
+		constants.FormatJson: func(data []byte) int {
+			var docs []map[string]any
+			if err := json.Unmarshal(data, &docs); err != nil {
+				t.Fatalf("Output is not a json array: %s", err)
+			}
+			return len(docs)
+		},
+		constants.FormatCanonical: func(data []byte) int {
+			var docs []json.RawMessage
+			if err := json.Unmarshal(data, &docs); err != nil {
+				t.Fatalf("Output is not a json array: %s", err)
+			}
+			return len(docs)
+		},
+		constants.FormatNdjson: func(data []byte) int { return bytes.Count(data, []byte("\n")) },
+		constants.FormatCsv:    func(data []byte) int { return len(readCsv(t, data)) - 1 },
+		constants.FormatParquet: func(data []byte) int {
+			return int(readParquet(t, data).NumRows())
+		},
+		constants.FormatAvro: func(data []byte) int {
+			_, records := readAvro(t, data)
+			return len(records)
+		},
+	}
+
+	for format, count := range countRows {
+		t.Run(format, func(t *testing.T) {
+			tempDir := t.TempDir()
+			dumper, err := NewDumper(Options{Format: format, RowGroupSize: 4})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writer, err := dumper.NewFileWriter("users", "users", tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Batches are appended to the same file
+			for batch := 0; batch < 3; batch++ {
+				docs := []*bson.M{{"n": int64(batch * 2), "name": "a"}, {"n": int64(batch*2 + 1), "name": "b"}}
+				if err := writer.DumpToFile(docs, "users", "users", tempDir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			matches, _ := filepath.Glob(filepath.Join(tempDir, "*"))
+			if len(matches) != 1 {
+				t.Fatalf("Expected a single file, found %v", matches)
+			}
+			data, err := os.ReadFile(matches[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows := count(data); rows != 6 {
+				t.Errorf("Expected 6 documents, got %d", rows)
+			}
+		})
+	}
+}
+
+func TestFileWriterEmptyAndAborted(t *testing.T) {
+	tempDir := t.TempDir()
+	manifest := NewManifest(NewRunInfo("people", "crm"), "users", "", constants.FormatCanonical, Compression{})
+	dumper, err := NewDumper(Options{Format: constants.FormatCanonical, Manifest: manifest})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No document still gives a valid file
+	writer, err := dumper.NewFileWriter("users", "empty", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(tempDir, "empty_000001.json"))
+	if err != nil || string(data) != "[]" {
+		t.Errorf("Expected an empty array, got %q (%v)", data, err)
+	}
+	if len(manifest.Chunks) != 1 || manifest.Chunks[0].Sha256 == "" {
+		t.Errorf("Expected the file to be recorded, got %v", manifest.Chunks)
+	}
+
+	// An aborted file leaves nothing behind
+	writer, err = dumper.NewFileWriter("users", "aborted", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.DumpToFile(sampleDocuments(t), "users", "aborted", tempDir); err != nil {
+		t.Fatal(err)
+	}
+	writer.Abort()
+	if matches, _ := filepath.Glob(filepath.Join(tempDir, "*aborted*")); len(matches) != 0 {
+		t.Errorf("Expected aborted file to be removed, found %v", matches)
+	}
+}
+
+func rawDocuments(t testing.TB, docs []*bson.M) []bson.Raw {
+	raws := make([]bson.Raw, len(docs))
+	for i, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raws[i] = raw
+	}
+	return raws
+}
+
+// Documents of a json array or ndjson output
+func extJsonDocuments(t testing.TB, data []byte) []bson.M {
+	var items [][]byte
+	if bytes.HasPrefix(data, []byte("[")) {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(data, &raws); err != nil {
+			t.Fatalf("Output is not a json array: %s", err)
+		}
+		for _, raw := range raws {
+			items = append(items, raw)
+		}
+	} else {
+		items = bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	}
+	docs := make([]bson.M, len(items))
+	for i, item := range items {
+		if err := bson.UnmarshalExtJSON(item, false, &docs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return docs
+}
+
+func TestEncodeRawMatchesDecoded(t *testing.T) {
+	formats := []string{constants.FormatCanonical, constants.FormatRelaxed, constants.FormatNdjson, constants.FormatNdjsonCanonical}
+	for _, format := range formats {
+		t.Run(format, func(t *testing.T) {
+			docs := sampleDocuments(t)
+			encoder, err := NewEncoder(Options{Format: format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rawEncoder, ok := encoder.(RawEncoder)
+			if !ok {
+				t.Fatalf("Expected %s encoder to convert raw documents", format)
+			}
+
+			var decoded, raw bytes.Buffer
+			if err := encoder.Encode(&decoded, docs); err != nil {
+				t.Fatal(err)
+			}
+			if err := rawEncoder.EncodeRaw(&raw, rawDocuments(t, docs)); err != nil {
+				t.Fatal(err)
+			}
+			// Field order of decoded documents is lost, values must match
+			if expected, got := extJsonDocuments(t, decoded.Bytes()), extJsonDocuments(t, raw.Bytes()); !reflect.DeepEqual(expected, got) {
+				t.Errorf("Expected %v, got %v", expected, got)
+			}
+		})
+	}
+
+	// Raw documents keep their field order
+	raw, _ := bson.Marshal(bson.D{{Key: "z", Value: int32(1)}, {Key: "a", Value: "x"}})
+	var buf bytes.Buffer
+	if err := (extJsonEncoder{canonical: true}).EncodeRaw(&buf, []bson.Raw{raw}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[{"z":{"$numberInt":"1"},"a":"x"}]`; buf.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, buf.String())
+	}
+}
+
+func TestDumperRaw(t *testing.T) {
+	transform, _ := NewTransform(map[string]string{"a": "b"}, nil)
+	for _, value := range []struct {
+		opts Options
+		raw  bool
+	}{
+		{Options{Format: constants.FormatCanonical}, true},
+		{Options{Format: constants.FormatNdjson}, true},
+		{Options{Format: constants.FormatJson}, false},
+		{Options{Format: constants.FormatCsv}, false},
+		{Options{Format: constants.FormatNdjson, Transform: transform}, false},
+		{Options{Format: constants.FormatNdjson, OnReceive: func(int64, []*bson.M) {}}, false},
+	} {
+		dumper, err := NewDumper(value.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dumper.Raw() != value.raw {
+			t.Errorf("Expected Raw() %v for %+v", value.raw, value.opts)
+		}
+	}
+}
+
+func TestDumpRawStreams(t *testing.T) {
+	tempDir := t.TempDir()
+	manifest := NewManifest(NewRunInfo("people", "crm"), "users", "", constants.FormatNdjson, Compression{})
+	dumper, err := NewDumper(Options{Format: constants.FormatNdjson, Manifest: manifest})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pipe := make(chan []bson.Raw, 3)
+	for i := 0; i < 3; i++ {
+		pipe <- rawDocuments(t, sampleDocuments(t))
+	}
+	close(pipe)
+	var workers errgroup.Group
+	for i := 0; i < 2; i++ {
+		workers.Go(func() error {
+			return dumper.DumpRawStreams(context.Background(), pipe, "users", "users_{chunk_id}", tempDir)
+		})
+	}
+	if err := workers.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Chunks) != 3 || manifest.Failed() {
+		t.Fatalf("Expected 3 chunks written, got %v", manifest.Chunks)
+	}
+	for _, chunk := range manifest.Chunks {
+		data, err := os.ReadFile(filepath.Join(tempDir, chunk.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if docs := extJsonDocuments(t, data); len(docs) != 2 {
+			t.Errorf("Expected 2 documents in %s, got %d", chunk.File, len(docs))
+		}
+	}
+
+	// Extract writes raw batches into a single file
+	writer, err := dumper.NewFileWriter("users", "single", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := writer.DumpRawToFile(rawDocuments(t, sampleDocuments(t)), "users", "single", tempDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(tempDir, "single_000004.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docs := extJsonDocuments(t, data); len(docs) != 4 {
+		t.Errorf("Expected 4 documents, got %d", len(docs))
+	}
+}
+
+// Cost of writing a batch read from the cursor: decoded into maps then encoded, or converted from raw bytes
+func BenchmarkEncodeBatch(b *testing.B) {
+	var docs []*bson.M
+	for len(docs) < 1000 {
+		docs = append(docs, sampleDocuments(b)...)
+	}
+	raws := rawDocuments(b, docs)
+	var size int64
+	for _, raw := range raws {
+		size += int64(len(raw))
+	}
+
+	for _, format := range []string{constants.FormatCanonical, constants.FormatNdjson} {
+		encoder, err := NewEncoder(Options{Format: format})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(format+"/decoded", func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				batch := make([]*bson.M, len(raws))
+				for j, raw := range raws {
+					var doc bson.M
+					if err := bson.Unmarshal(raw, &doc); err != nil {
+						b.Fatal(err)
+					}
+					batch[j] = &doc
+				}
+				if err := encoder.Encode(io.Discard, batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(format+"/raw", func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := encoder.(RawEncoder).EncodeRaw(io.Discard, raws); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
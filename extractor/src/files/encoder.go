@@ -0,0 +1,136 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Encoder serializes a batch of documents into a writer using a given file format
+type Encoder interface {
+	// File extension (without the dot) used for files written by this encoder
+	Extension() string
+	// Writes the whole batch into w
+	Encode(w io.Writer, results []*bson.M) error
+	// Starts a file written into w batch after batch, so the file never needs all its documents at once
+	NewStream(w io.Writer) (StreamWriter, error)
+}
+
+// Writes the batches of a single file
+type StreamWriter interface {
+	// Appends documents to the file
+	Write(results []*bson.M) error
+	// Ends the file (closing bracket, footer...), without closing w
+	Close() error
+}
+
+// Implemented by encoders converting raw bson documents straight into their format (bson.Raw as read from
+// the cursor), so documents are never decoded into maps
+type RawEncoder interface {
+	// Writes the whole batch into w
+	EncodeRaw(w io.Writer, results []bson.Raw) error
+}
+
+// Implemented by streams of raw encoders
+type RawStreamWriter interface {
+	// Appends raw documents to the file
+	WriteRaw(results []bson.Raw) error
+}
+
+// Buffers documents are marshalled into, shared by every worker so batches do not allocate their own
+var bufferPool = sync.Pool{New: func() any {
+	buf := make([]byte, 0, 4096)
+	return &buf
+}}
+
+// Output settings used to build encoders
+type Options struct {
+	// Output format name (check constants package)
+	Format string
+	// Csv columns (dotted paths for nested fields). Inferred from data when empty.
+	Columns []string
+	// How csv renders arrays: json, join or expand
+	ArrayMode string
+	// Separator used by join array mode
+	ArraySeparator string
+	// Schema file used by parquet output (inferred from data when empty)
+	SchemaFile string
+	// Documents used to infer parquet and avro schemas and csv columns (first batch is used when empty)
+	SchemaSample []*bson.M
+	// Max number of rows per parquet row group
+	RowGroupSize int64
+	// Avro block codec: null, deflate or snappy
+	AvroCodec string
+	// Compression applied to every file written
+	Compression Compression
+	// Run values used by file name templates
+	Run RunInfo
+	// Records every chunk written (optional)
+	Manifest *Manifest
+	// Field renames and conversions applied before encoding (optional)
+	Transform *Transform
+	// Called by workers once a chunk was written, or failed (optional)
+	OnChunk func(chunk ManifestChunk)
+	// Called once per field whose values are left out of files: not found in inferred csv columns or schemas,
+	// or of a type its column cannot hold (optional)
+	OnDroppedField func(field string, reason string)
+	// Called with every batch received by workers, in chunk id order, before it is written (optional)
+	OnReceive func(chunkId int64, batch []*bson.M)
+	// Chunk ids already used by the run, new chunks are numbered after them (resumed runs)
+	ChunkOffset int64
+}
+
+// Returns the encoder matching output format
+func NewEncoder(opts Options) (Encoder, error) {
+	switch opts.Format {
+	case constants.FormatJson, "":
+		return jsonEncoder{}, nil
+	case constants.FormatCanonical:
+		return extJsonEncoder{canonical: true}, nil
+	case constants.FormatRelaxed:
+		return extJsonEncoder{canonical: false}, nil
+	case constants.FormatNdjson:
+		return ndjsonEncoder{canonical: false}, nil
+	case constants.FormatNdjsonCanonical:
+		return ndjsonEncoder{canonical: true}, nil
+	case constants.FormatCsv:
+		return newCsvEncoder(opts.Columns, opts.SchemaSample, opts.ArrayMode, opts.ArraySeparator, opts.OnDroppedField)
+	case constants.FormatParquet:
+		return newParquetEncoder(opts.SchemaFile, opts.SchemaSample, opts.RowGroupSize, opts.OnDroppedField)
+	case constants.FormatAvro:
+		return newAvroEncoder(opts.AvroCodec, opts.SchemaSample, opts.OnDroppedField)
+	}
+	return nil, fmt.Errorf("unknown output format: %q", opts.Format)
+}
+
+// Reports fields left out of files, once per field (no op on a nil report)
+type fieldReport struct {
+	report func(field string, reason string)
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func newFieldReport(report func(field string, reason string)) *fieldReport {
+	if report == nil {
+		return nil
+	}
+	return &fieldReport{report: report, seen: map[string]bool{}}
+}
+
+func (r *fieldReport) drop(field string, reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	first := !r.seen[field]
+	r.seen[field] = true
+	r.mu.Unlock()
+
+	if first {
+		r.report(field, reason)
+	}
+}
@@ -0,0 +1,203 @@
+package files
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Writes every batch as a record batch of a single Apache Arrow IPC file (Feather v2):
+//
+//	<fileLocation>/<mapping or rendered filePrefix>.arrow
+//
+// so the whole extraction can be memory mapped by pandas, Polars or DuckDB.
+// Columns and types are the same used by parquet output, all workers share the same schema.
+// A compressed file can no longer be memory mapped, it must be decompressed first.
+type ArrowDumper struct {
+	schema    *arrowSchema
+	transform *Transform
+
+	mu         sync.Mutex
+	file       *os.File
+	name       string
+	digest     *fileDigest
+	compressor io.WriteCloser
+	buffer     *bufio.Writer
+	writer     *ipc.FileWriter
+	documents  int64
+	// First error met by workers, returned by Close
+	err error
+}
+
+// Creates the arrow file. Schema is read from schemaFile or inferred from sample (first batch when empty).
+// Default file name template gives <mapping>.arrow, other templates are rendered with chunk and worker ids set to 0.
+// Transform (optional) is applied to every batch, sample must be already transformed.
+// Fields left out of the file are reported to onDropped (optional).
+func NewArrowDumper(fileLocation, mapping, filePrefix, schemaFile string, sample []*bson.M, compression Compression, run RunInfo, transform *Transform, onDropped func(field string, reason string)) (*ArrowDumper, error) {
+	if err := compression.Validate(); err != nil {
+		return nil, err
+	}
+	schema, err := newArrowSchema(schemaFile, sample, onDropped)
+	if err != nil {
+		return nil, err
+	}
+
+	// A plain prefix is used as is
+	name := filePrefix
+	switch {
+	case filePrefix == constants.MappingDefault:
+		name = mapping
+	case strings.Contains(filePrefix, "{"):
+		if name, err = RenderName(filePrefix, NameVars{Mapping: mapping, Run: run}); err != nil {
+			return nil, err
+		}
+	}
+	name = fmt.Sprintf("%s.arrow%s", name, compression.Extension())
+	file, err := os.Create(fmt.Sprintf("%s/%s", fileLocation, name))
+	if err != nil {
+		return nil, err
+	}
+	digest := newFileDigest(file)
+	compressor, err := compression.NewWriter(digest)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ArrowDumper{
+		schema:     schema,
+		transform:  transform,
+		file:       file,
+		name:       name,
+		digest:     digest,
+		compressor: compressor,
+		buffer:     bufio.NewWriter(compressor),
+	}, nil
+}
+
+// Appends a batch of documents as a record batch.
+// Remaining params are kept to match the process function signature, output file name is fixed.
+func (d *ArrowDumper) DumpToFile(results []*bson.M, mapping string, filePrefix string, fileLocation string) error {
+	if len(results) == 0 {
+		return nil
+	}
+	if err := d.transform.Apply(results); err != nil {
+		return err
+	}
+
+	rows := flattenAll(results)
+	record, err := d.schema.build(d.schema.get(rows), rows)
+	if err != nil {
+		return err
+	}
+	defer record.Release()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err = d.start(); err != nil {
+		return err
+	}
+	if err = d.writer.Write(record); err != nil {
+		return err
+	}
+	d.documents += int64(len(results))
+	return nil
+}
+
+// Arrow file header holds the schema, so writer is created along with the first record batch
+func (d *ArrowDumper) start() error {
+	if d.writer != nil {
+		return nil
+	}
+	writer, err := ipc.NewFileWriter(&positionWriter{Writer: d.buffer}, ipc.WithSchema(d.schema.get(nil)))
+	if err != nil {
+		return err
+	}
+	d.writer = writer
+	return nil
+}
+
+// Concurrent batch dumper appending record batches to the arrow file, stops on the first error
+func (d *ArrowDumper) DumpStreams(ctx context.Context, dataChannel <-chan []*bson.M, mapping string, filePrefix string, fileLocation string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case batch, ok := <-dataChannel:
+			if !ok {
+				return nil
+			}
+			if err := d.DumpToFile(batch, mapping, filePrefix, fileLocation); err != nil {
+				d.fail(err)
+				return err
+			}
+		}
+	}
+}
+
+func (d *ArrowDumper) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// Describes the arrow file for the run manifest (call it once closed)
+func (d *ArrowDumper) Chunk() ManifestChunk {
+	return ManifestChunk{ChunkId: 1, File: d.name, Documents: d.documents, Bytes: d.digest.size, Sha256: d.digest.Sum()}
+}
+
+// Writes the file footer and closes it, returns the first error met by workers
+func (d *ArrowDumper) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// An empty extraction still gets a valid file (schema and no record batches)
+	err := d.err
+	if err == nil {
+		err = d.start()
+	}
+	if err == nil {
+		err = d.writer.Close()
+	}
+	if err == nil {
+		err = d.buffer.Flush()
+	}
+	if err == nil {
+		err = d.compressor.Close()
+	}
+	if closeErr := d.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Arrow file writer only seeks to learn its position (blocks offsets are kept in the footer),
+// counting written bytes is enough and allows buffering and compressing the output.
+type positionWriter struct {
+	io.Writer
+	pos int64
+}
+
+func (w *positionWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.pos += int64(n)
+	return n, err
+}
+
+func (w *positionWriter) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekCurrent {
+		return 0, errors.New("arrow output only supports retrieving current position")
+	}
+	return w.pos, nil
+}
@@ -0,0 +1,138 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/errgroup"
+)
+
+func readArrowFile(t testing.TB, path string) *ipc.FileReader {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	reader, err := ipc.NewFileReader(file, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return reader
+}
+
+func TestArrowDumperStreams(t *testing.T) {
+	dir := t.TempDir()
+	dumper, err := NewArrowDumper(dir, "users", constants.MappingDefault, "", sampleDocuments(t), Compression{}, NewRunInfo("users", "db"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataChannel := make(chan []*bson.M)
+	var workers errgroup.Group
+	for i := 0; i < 2; i++ {
+		workers.Go(func() error {
+			return dumper.DumpStreams(context.Background(), dataChannel, "users", constants.MappingDefault, dir)
+		})
+	}
+	batches := 5
+	for i := 0; i < batches; i++ {
+		dataChannel <- sampleDocuments(t)
+	}
+	close(dataChannel)
+	if err := workers.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dumper.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := readArrowFile(t, filepath.Join(dir, "users.arrow"))
+	if reader.NumRecords() != batches {
+		t.Fatalf("Expected %d record batches, got %d", batches, reader.NumRecords())
+	}
+
+	expected := map[string]arrow.DataType{
+		"_id":     objectIdType,
+		"created": timestampType,
+		"amount":  &arrow.Decimal128Type{Precision: 38, Scale: 4},
+		"counter": arrow.PrimitiveTypes.Int64,
+		"tags":    arrow.BinaryTypes.String,
+	}
+	schema := reader.Schema()
+	for name, dataType := range expected {
+		indices := schema.FieldIndices(name)
+		if len(indices) != 1 {
+			t.Errorf("Column %s not found", name)
+			continue
+		}
+		if got := schema.Field(indices[0]).Type; !arrow.TypeEqual(got, dataType) {
+			t.Errorf("Column %s: expected %s, got %s", name, dataType, got)
+		}
+	}
+
+	for i := 0; i < batches; i++ {
+		record, err := reader.Record(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.NumRows() != 2 {
+			t.Errorf("Record batch %d: expected 2 rows, got %d", i, record.NumRows())
+		}
+	}
+}
+
+func TestArrowDumperEmpty(t *testing.T) {
+	dir := t.TempDir()
+	dumper, err := NewArrowDumper(dir, "users", "empty", "", nil, Compression{}, NewRunInfo("users", "db"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dumper.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := readArrowFile(t, filepath.Join(dir, "empty.arrow"))
+	if reader.NumRecords() != 0 {
+		t.Errorf("Expected no record batches, got %d", reader.NumRecords())
+	}
+}
+
+func TestArrowDumperOutOfSample(t *testing.T) {
+	dir := t.TempDir()
+	dropped := map[string]bool{}
+	sample := []*bson.M{{"_id": int32(1), "age": int32(30)}}
+	dumper, err := NewArrowDumper(dir, "users", constants.MappingDefault, "", sample, Compression{}, NewRunInfo("users", "db"), nil, func(field string, reason string) {
+		dropped[field] = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dumper.DumpToFile([]*bson.M{{"_id": int32(2), "age": "unknown", "city": "Porto"}}, "users", constants.MappingDefault, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := dumper.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := readArrowFile(t, filepath.Join(dir, "users.arrow"))
+	record, err := reader.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.NumCols() != 2 || !record.Column(1).IsNull(0) {
+		t.Errorf("Expected sampled columns with a null age, got %v", record)
+	}
+	if !dropped["age"] || !dropped["city"] {
+		t.Errorf("Expected age and city to be reported, got %v", dropped)
+	}
+}
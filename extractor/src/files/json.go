@@ -0,0 +1,129 @@
+package files
+
+import (
+	"encoding/json"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Plain json array, kept for backward compatibility.
+// bson types (ObjectId, dates, decimals, binaries...) are not preserved.
+type jsonEncoder struct{}
+
+func (jsonEncoder) Extension() string {
+	return "json"
+}
+
+func (jsonEncoder) Encode(w io.Writer, results []*bson.M) error {
+	jsonData, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(jsonData)
+	return err
+}
+
+func (jsonEncoder) NewStream(w io.Writer) (StreamWriter, error) {
+	return newJsonArrayStream(w, func(buf []byte, doc any) ([]byte, error) {
+		data, err := json.Marshal(doc)
+		return append(buf, data...), err
+	}), nil
+}
+
+// Json array of MongoDB Extended JSON documents.
+// Check the specs: https://www.mongodb.com/docs/manual/reference/mongodb-extended-json/
+type extJsonEncoder struct {
+	canonical bool
+}
+
+func (extJsonEncoder) Extension() string {
+	return "json"
+}
+
+func (e extJsonEncoder) Encode(w io.Writer, results []*bson.M) error {
+	stream := e.newStream(w)
+	if err := stream.Write(results); err != nil {
+		return err
+	}
+	return stream.Close()
+}
+
+// Converts documents straight from their bson bytes
+func (e extJsonEncoder) EncodeRaw(w io.Writer, results []bson.Raw) error {
+	stream := e.newStream(w)
+	if err := stream.WriteRaw(results); err != nil {
+		return err
+	}
+	return stream.Close()
+}
+
+func (e extJsonEncoder) NewStream(w io.Writer) (StreamWriter, error) {
+	return e.newStream(w), nil
+}
+
+func (e extJsonEncoder) newStream(w io.Writer) *jsonArrayStream {
+	return newJsonArrayStream(w, func(buf []byte, doc any) ([]byte, error) {
+		return bson.MarshalExtJSONAppend(buf, doc, e.canonical, false)
+	})
+}
+
+// Json array written document by document
+type jsonArrayStream struct {
+	w       io.Writer
+	marshal func(buf []byte, doc any) ([]byte, error)
+	// Pooled buffer, reused for every document and given back once the array is closed
+	buf       *[]byte
+	documents int64
+}
+
+func newJsonArrayStream(w io.Writer, marshal func(buf []byte, doc any) ([]byte, error)) *jsonArrayStream {
+	return &jsonArrayStream{w: w, marshal: marshal, buf: bufferPool.Get().(*[]byte)}
+}
+
+func (s *jsonArrayStream) Write(results []*bson.M) error {
+	for _, doc := range results {
+		if err := s.write(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonArrayStream) WriteRaw(results []bson.Raw) error {
+	for _, doc := range results {
+		if err := s.write(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonArrayStream) write(doc any) error {
+	separator := ","
+	if s.documents == 0 {
+		separator = "["
+	}
+	if _, err := io.WriteString(s.w, separator); err != nil {
+		return err
+	}
+	var err error
+	if *s.buf, err = s.marshal((*s.buf)[:0], doc); err != nil {
+		return err
+	}
+	if _, err = s.w.Write(*s.buf); err != nil {
+		return err
+	}
+	s.documents++
+	return nil
+}
+
+func (s *jsonArrayStream) Close() error {
+	end := "]"
+	if s.documents == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(s.w, end)
+	bufferPool.Put(s.buf)
+	return err
+}
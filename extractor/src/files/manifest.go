@@ -0,0 +1,155 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// File names written next to extracted files
+const (
+	ManifestFile  = "manifest.json"
+	SuccessMarker = "_SUCCESS"
+)
+
+// Describes every file written by a run, so loaders can check nothing is missing or corrupted
+type Manifest struct {
+	RunId          string          `json:"run_id"`
+	Mapping        string          `json:"mapping"`
+	Database       string          `json:"database"`
+	Collection     string          `json:"collection"`
+	Query          string          `json:"query"`
+	Pipeline       string          `json:"pipeline,omitempty"`
+	Format         string          `json:"format"`
+	Compression    string          `json:"compression,omitempty"`
+	StartTime      time.Time       `json:"start_time"`
+	EndTime        time.Time       `json:"end_time"`
+	TotalDocuments int64           `json:"total_documents"`
+	TotalChunks    int             `json:"total_chunks"`
+	FailedChunks   int             `json:"failed_chunks"`
+	Error          string          `json:"error,omitempty"`
+	Chunks         []ManifestChunk `json:"chunks"`
+
+	mu sync.Mutex
+}
+
+// A single file written by a run. Failed chunks only hold their id and error.
+type ManifestChunk struct {
+	ChunkId   int64  `json:"chunk_id"`
+	File      string `json:"file"`
+	Documents int64  `json:"documents"`
+	Bytes     int64  `json:"bytes"`
+	Sha256    string `json:"sha256"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Starts the manifest of a run
+func NewManifest(run RunInfo, mapping, query, format string, compression Compression) *Manifest {
+	return &Manifest{
+		RunId:       run.RunId,
+		Mapping:     mapping,
+		Database:    run.Database,
+		Collection:  run.Collection,
+		Query:       query,
+		Format:      format,
+		Compression: compression.Codec,
+		StartTime:   run.Start,
+		Chunks:      []ManifestChunk{},
+	}
+}
+
+// Records a chunk, safe to call from every worker (no op on a nil manifest)
+func (m *Manifest) AddChunk(chunk ManifestChunk) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Chunks = append(m.Chunks, chunk)
+}
+
+// Checks if any chunk failed
+func (m *Manifest) Failed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, chunk := range m.Chunks {
+		if chunk.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Ends the run: writes manifest.json into fileLocation, then _SUCCESS when the run
+// did not fail (runErr) and every chunk was written
+func (m *Manifest) Write(fileLocation string, runErr error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.EndTime = time.Now().UTC()
+	sort.Slice(m.Chunks, func(i, j int) bool { return m.Chunks[i].ChunkId < m.Chunks[j].ChunkId })
+	m.TotalDocuments, m.TotalChunks, m.FailedChunks = 0, len(m.Chunks), 0
+	for _, chunk := range m.Chunks {
+		if chunk.Error != "" {
+			m.FailedChunks++
+			continue
+		}
+		m.TotalDocuments += chunk.Documents
+	}
+	if runErr != nil {
+		m.Error = runErr.Error()
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(fileLocation, ManifestFile), data, 0644); err != nil {
+		return err
+	}
+
+	if runErr != nil || m.FailedChunks > 0 {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(fileLocation, SuccessMarker), nil, 0644)
+}
+
+// Removes the _SUCCESS marker left by a previous run in the same folder
+func RemoveSuccessMarker(fileLocation string) error {
+	err := os.Remove(filepath.Join(fileLocation, SuccessMarker))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Counts and hashes bytes written into a file (after compression)
+type fileDigest struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+func newFileDigest(w io.Writer) *fileDigest {
+	return &fileDigest{w: w, hash: sha256.New()}
+}
+
+func (d *fileDigest) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.hash.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+// Hex encoded SHA-256 of everything written
+func (d *fileDigest) Sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
@@ -0,0 +1,138 @@
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/errgroup"
+)
+
+// Dumps batches with 3 workers, as the driver runs them: sending stops once a worker failed
+func dumpWithManifest(t *testing.T, tempDir string, batches int) (*Manifest, error) {
+	run := NewRunInfo("people", "crm")
+	compression := Compression{Codec: constants.CompressGzip}
+	manifest := NewManifest(run, "users", `{"age":{"$gt":18}}`, constants.FormatNdjson, compression)
+	dumper, err := NewDumper(Options{Format: constants.FormatNdjson, Compression: compression, Run: run, Manifest: manifest})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataChannel := make(chan []*bson.M)
+	workers, ctx := errgroup.WithContext(context.Background())
+	for i := 0; i < 3; i++ {
+		workers.Go(func() error {
+			return dumper.DumpStreams(ctx, dataChannel, "users", constants.MappingDefault, tempDir)
+		})
+	}
+	for i := 1; i <= batches && ctx.Err() == nil; i++ {
+		select {
+		case dataChannel <- sampleDocuments(t)[:1+i%2]:
+		case <-ctx.Done():
+		}
+	}
+	close(dataChannel)
+	return manifest, workers.Wait()
+}
+
+func readManifest(t *testing.T, tempDir string) *Manifest {
+	data, err := os.ReadFile(filepath.Join(tempDir, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestManifestChunks(t *testing.T) {
+	tempDir := t.TempDir()
+	manifest, err := dumpWithManifest(t, tempDir, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Write(tempDir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest = readManifest(t, tempDir)
+	if manifest.TotalChunks != 5 || manifest.TotalDocuments != 8 || manifest.FailedChunks != 0 {
+		t.Errorf("Unexpected totals: %d chunks, %d documents, %d failed", manifest.TotalChunks, manifest.TotalDocuments, manifest.FailedChunks)
+	}
+	if manifest.Collection != "people" || manifest.Query != `{"age":{"$gt":18}}` || manifest.EndTime.Before(manifest.StartTime) {
+		t.Errorf("Unexpected run details: %s %s", manifest.Collection, manifest.Query)
+	}
+
+	for i, chunk := range manifest.Chunks {
+		if chunk.ChunkId != int64(i+1) {
+			t.Errorf("Expected chunk %d, got %d", i+1, chunk.ChunkId)
+		}
+		data, err := os.ReadFile(filepath.Join(tempDir, chunk.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if chunk.Sha256 != hex.EncodeToString(sum[:]) || chunk.Bytes != int64(len(data)) {
+			t.Errorf("Chunk %s: checksum or size does not match file content", chunk.File)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, SuccessMarker)); err != nil {
+		t.Errorf("Expected success marker: %s", err)
+	}
+}
+
+func TestManifestFailedChunk(t *testing.T) {
+	tempDir := t.TempDir()
+	// Chunk 2 cannot be created
+	if err := os.Mkdir(filepath.Join(tempDir, "users_000002.ndjson.gz"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The worker writing chunk 2 stops the others
+	manifest, err := dumpWithManifest(t, tempDir, 3)
+	if err == nil || !manifest.Failed() {
+		t.Fatal("Expected a failed chunk")
+	}
+	if err := manifest.Write(tempDir, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	written := readManifest(t, tempDir)
+	if written.FailedChunks != 1 || written.Chunks[1].Error == "" {
+		t.Errorf("Expected chunk 2 to be recorded as failed: %+v", written.Chunks)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, SuccessMarker)); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Success marker must not be written when a chunk failed")
+	}
+}
+
+func TestManifestRunError(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, SuccessMarker), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveSuccessMarker(tempDir); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := NewManifest(NewRunInfo("people", "crm"), "users", "", constants.FormatJson, Compression{})
+	if err := manifest.Write(tempDir, errors.New("cursor error")); err != nil {
+		t.Fatal(err)
+	}
+	if readManifest(t, tempDir).Error != "cursor error" {
+		t.Error("Expected run error to be recorded")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, SuccessMarker)); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Success marker must not be written when the run failed")
+	}
+}
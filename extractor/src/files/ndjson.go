@@ -0,0 +1,76 @@
+package files
+
+import (
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Newline delimited json (a.k.a. JSON Lines), one extended json document per line.
+// Documents are written one by one, so the chunk is never marshalled as a whole.
+// Check the specs: https://jsonlines.org/
+type ndjsonEncoder struct {
+	canonical bool
+}
+
+func (ndjsonEncoder) Extension() string {
+	return "ndjson"
+}
+
+func (e ndjsonEncoder) Encode(w io.Writer, results []*bson.M) error {
+	buf := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+
+	for _, doc := range results {
+		if err := e.writeLine(w, buf, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Converts documents straight from their bson bytes
+func (e ndjsonEncoder) EncodeRaw(w io.Writer, results []bson.Raw) error {
+	buf := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buf)
+
+	for _, doc := range results {
+		if err := e.writeLine(w, buf, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes a document line, marshalled into buf
+func (e ndjsonEncoder) writeLine(w io.Writer, buf *[]byte, doc any) error {
+	var err error
+	if *buf, err = bson.MarshalExtJSONAppend((*buf)[:0], doc, e.canonical, false); err != nil {
+		return err
+	}
+	*buf = append(*buf, '\n')
+	_, err = w.Write(*buf)
+	return err
+}
+
+func (e ndjsonEncoder) NewStream(w io.Writer) (StreamWriter, error) {
+	return ndjsonStream{w: w, encoder: e}, nil
+}
+
+// Lines are independent, every batch is written as a whole chunk would be
+type ndjsonStream struct {
+	w       io.Writer
+	encoder ndjsonEncoder
+}
+
+func (s ndjsonStream) Write(results []*bson.M) error {
+	return s.encoder.Encode(s.w, results)
+}
+
+func (s ndjsonStream) WriteRaw(results []bson.Raw) error {
+	return s.encoder.EncodeRaw(s.w, results)
+}
+
+func (s ndjsonStream) Close() error {
+	return nil
+}
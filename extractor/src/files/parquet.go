@@ -0,0 +1,111 @@
+package files
+
+import (
+	"io"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/parquet"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Default number of rows per parquet row group
+const DefaultRowGroupSize int64 = 64 * 1024
+
+// Apache Parquet files, one file per chunk.
+// bson types are mapped to parquet logical types: dates to timestamp (millis, UTC),
+// Decimal128 to decimal, ObjectId to a 12 bytes fixed length binary.
+// Check the specs: https://parquet.apache.org/docs/file-format/
+type parquetEncoder struct {
+	schema       *arrowSchema
+	rowGroupSize int64
+}
+
+func newParquetEncoder(schemaFile string, sample []*bson.M, rowGroupSize int64, onDropped func(field string, reason string)) (*parquetEncoder, error) {
+	schema, err := newArrowSchema(schemaFile, sample, onDropped)
+	if err != nil {
+		return nil, err
+	}
+	if rowGroupSize <= 0 {
+		rowGroupSize = DefaultRowGroupSize
+	}
+	return &parquetEncoder{schema: schema, rowGroupSize: rowGroupSize}, nil
+}
+
+func (e *parquetEncoder) Extension() string {
+	return "parquet"
+}
+
+func (e *parquetEncoder) Encode(w io.Writer, results []*bson.M) error {
+	rows := flattenAll(results)
+	schema := e.schema.get(rows)
+
+	record, err := e.schema.build(schema, rows)
+	if err != nil {
+		return err
+	}
+	defer record.Release()
+
+	props := parquet.NewWriterProperties(parquet.WithMaxRowGroupLength(e.rowGroupSize))
+	// Arrow schema is stored so readers get back the exact column types (e.g.: timestamp time zone)
+	writer, err := pqarrow.NewFileWriter(schema, w, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		return err
+	}
+	if err = writer.Write(record); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func (e *parquetEncoder) NewStream(w io.Writer) (StreamWriter, error) {
+	return &parquetStream{encoder: e, w: w}, nil
+}
+
+// Parquet file written batch after batch: rows are buffered into the current row group, which is written
+// once it holds the max number of rows, so memory is bounded by the row group size
+type parquetStream struct {
+	encoder *parquetEncoder
+	w       io.Writer
+	schema  *arrow.Schema
+	writer  *pqarrow.FileWriter
+}
+
+func (s *parquetStream) Write(results []*bson.M) error {
+	rows := flattenAll(results)
+	if err := s.start(rows); err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	record, err := s.encoder.schema.build(s.schema, rows)
+	if err != nil {
+		return err
+	}
+	defer record.Release()
+	return s.writer.WriteBuffered(record)
+}
+
+// Opens the file writer, schema is inferred from the first batch when not set yet
+func (s *parquetStream) start(rows []map[string]any) error {
+	if s.writer != nil {
+		return nil
+	}
+	s.schema = s.encoder.schema.get(rows)
+	props := parquet.NewWriterProperties(parquet.WithMaxRowGroupLength(s.encoder.rowGroupSize))
+	writer, err := pqarrow.NewFileWriter(s.schema, s.w, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		return err
+	}
+	s.writer = writer
+	return nil
+}
+
+func (s *parquetStream) Close() error {
+	if err := s.start(nil); err != nil {
+		return err
+	}
+	return s.writer.Close()
+}
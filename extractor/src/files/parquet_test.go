@@ -0,0 +1,169 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/apache/arrow/go/v14/parquet/pqarrow"
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func readParquet(t testing.TB, data []byte) arrow.Table {
+	table, err := pqarrow.ReadTable(context.Background(), bytes.NewReader(data), nil, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestParquetInferredTypes(t *testing.T) {
+	encoder, err := NewEncoder(Options{Format: constants.FormatParquet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	docs := sampleDocuments(t)
+	if err := encoder.Encode(&buf, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	table := readParquet(t, buf.Bytes())
+	defer table.Release()
+
+	if table.NumRows() != int64(len(docs)) {
+		t.Errorf("Expected %d rows, got %d", len(docs), table.NumRows())
+	}
+
+	expected := map[string]arrow.DataType{
+		"_id":             objectIdType,
+		"created":         timestampType,
+		"amount":          &arrow.Decimal128Type{Precision: 38, Scale: 4},
+		"payload":         arrow.BinaryTypes.Binary,
+		"counter":         arrow.PrimitiveTypes.Int64,
+		"nested.word":     arrow.BinaryTypes.String,
+		"nested.latitude": arrow.PrimitiveTypes.Float64,
+		"tags":            arrow.BinaryTypes.String,
+	}
+	schema := table.Schema()
+	for name, dataType := range expected {
+		indices := schema.FieldIndices(name)
+		if len(indices) != 1 {
+			t.Errorf("Column %s not found", name)
+			continue
+		}
+		if got := schema.Field(indices[0]).Type; !arrow.TypeEqual(got, dataType) {
+			t.Errorf("Column %s: expected %s, got %s", name, dataType, got)
+		}
+	}
+
+	amount := table.Column(schema.FieldIndices("amount")[0]).Data().Chunk(0).(*array.Decimal128)
+	if got := amount.Value(0).ToString(4); got != "1234.5678" {
+		t.Errorf("Expected amount 1234.5678, got %s", got)
+	}
+}
+
+func TestParquetSchemaFile(t *testing.T) {
+	schemaFile := filepath.Join(t.TempDir(), "schema.json")
+	spec := `{"_id": "objectid", "amount": "decimal(10,2)", "counter": "double", "missing": "string"}`
+	if err := os.WriteFile(schemaFile, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	encoder, err := NewEncoder(Options{Format: constants.FormatParquet, SchemaFile: schemaFile, RowGroupSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, sampleDocuments(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	table := readParquet(t, buf.Bytes())
+	defer table.Release()
+
+	names := []string{}
+	for _, field := range table.Schema().Fields() {
+		names = append(names, field.Name)
+	}
+	if len(names) != 4 || names[0] != "_id" || names[1] != "amount" || names[3] != "missing" {
+		t.Errorf("Unexpected columns %v", names)
+	}
+
+	amount := table.Column(1).Data().Chunk(0).(*array.Decimal128)
+	if got := amount.Value(0).ToString(2); got != "1234.57" {
+		t.Errorf("Expected rounded amount 1234.57, got %s", got)
+	}
+}
+
+func TestParquetSchemaMismatch(t *testing.T) {
+	schemaFile := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(schemaFile, []byte(`{"name": "int64"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped := map[string]string{}
+	encoder, err := NewEncoder(Options{Format: constants.FormatParquet, SchemaFile: schemaFile, OnDroppedField: func(field string, reason string) {
+		dropped[field] = reason
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, []*bson.M{{"name": "Bob", "age": int32(3)}, {"name": int64(7)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Value of another type is written as null, fields left out of a schema file are not reported
+	table := readParquet(t, buf.Bytes())
+	defer table.Release()
+	names := table.Column(0).Data().Chunk(0).(*array.Int64)
+	if !names.IsNull(0) || names.Value(1) != 7 {
+		t.Errorf("Expected null then 7, got %v", names)
+	}
+	if len(dropped) != 1 || dropped["name"] == "" {
+		t.Errorf("Expected name to be reported, got %v", dropped)
+	}
+}
+
+func TestParquetStreamOutOfSample(t *testing.T) {
+	var dropped []string
+	sample := []*bson.M{{"_id": int32(1), "age": int32(30)}}
+	encoder, err := NewEncoder(Options{Format: constants.FormatParquet, SchemaSample: sample, OnDroppedField: func(field string, reason string) {
+		dropped = append(dropped, field)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	stream, err := encoder.NewStream(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, batch := range [][]*bson.M{{{"_id": int32(1), "age": int32(30)}}, {{"_id": int32(2), "age": "unknown", "city": "Porto"}}} {
+		if err := stream.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	table := readParquet(t, buf.Bytes())
+	defer table.Release()
+	if table.NumRows() != 2 || table.NumCols() != 2 {
+		t.Errorf("Expected 2 rows of the sampled columns, got %d rows and %d columns", table.NumRows(), table.NumCols())
+	}
+	if len(dropped) != 2 {
+		t.Errorf("Expected age and city to be reported, got %v", dropped)
+	}
+}
@@ -0,0 +1,140 @@
+package files
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Variables accepted by output file name templates, e.g.: {mapping}_{date}_{chunk_id}
+const (
+	// Mapping name (--mapping)
+	VarMapping = "mapping"
+	// Sequential chunk number, starting at 1 and following cursor order
+	VarChunkId = "chunk_id"
+	// Number of the worker that wrote the chunk (0 outside of a worker pool)
+	VarWorkerId = "worker_id"
+	// Id shared by every file of a run
+	VarRunId = "run_id"
+	// Run start date (UTC, YYYYMMDD)
+	VarDate = "date"
+	// Run start time (UTC, HHMMSS)
+	VarTime = "time"
+	// Collection name
+	VarCollection = "collection"
+	// Database name
+	VarDatabase = "database"
+)
+
+// Number of digits numeric variables are padded to, unless the template sets one (e.g.: {chunk_id:8})
+const defaultIdWidth = 6
+
+// {name} or {name:width}
+var templateVariable = regexp.MustCompile(`\{([a-z_]+)(?::([0-9]+))?\}`)
+
+// Values fixed for a whole run
+type RunInfo struct {
+	RunId      string
+	Collection string
+	Database   string
+	Start      time.Time
+}
+
+// Starts a new run: random run id and current time
+func NewRunInfo(collection, database string) RunInfo {
+	return RunInfo{
+		RunId:      uuid.NewString(),
+		Collection: collection,
+		Database:   database,
+		Start:      time.Now().UTC(),
+	}
+}
+
+// Values a file name template is rendered with
+type NameVars struct {
+	Mapping  string
+	ChunkId  int64
+	WorkerId int
+	Run      RunInfo
+}
+
+// Checks template only holds known variables and balanced braces
+func ValidateTemplate(template string) error {
+	for _, match := range templateVariable.FindAllStringSubmatch(template, -1) {
+		if !isTemplateVariable(match[1]) {
+			return fmt.Errorf("unknown variable %s in file name template %q", match[0], template)
+		}
+		if match[2] != "" && match[1] != VarChunkId && match[1] != VarWorkerId {
+			return fmt.Errorf("variable %s in file name template %q does not accept a width", match[0], template)
+		}
+	}
+	if rest := templateVariable.ReplaceAllString(template, ""); strings.ContainsAny(rest, "{}/") {
+		return fmt.Errorf("invalid file name template %q", template)
+	}
+	return nil
+}
+
+// Checks if template holds variable (plain prefixes hold {chunk_id})
+func TemplateUses(template string, variable string) bool {
+	if !strings.Contains(template, "{") {
+		return variable == VarChunkId
+	}
+	for _, match := range templateVariable.FindAllStringSubmatch(template, -1) {
+		if match[1] == variable {
+			return true
+		}
+	}
+	return false
+}
+
+func isTemplateVariable(name string) bool {
+	switch name {
+	case VarMapping, VarChunkId, VarWorkerId, VarRunId, VarDate, VarTime, VarCollection, VarDatabase:
+		return true
+	}
+	return false
+}
+
+// Renders a file name template (without extension).
+// A template without variables is taken as a prefix and gets the chunk id appended (prefix_{chunk_id}).
+func RenderName(template string, vars NameVars) (string, error) {
+	if err := ValidateTemplate(template); err != nil {
+		return "", err
+	}
+	if !strings.Contains(template, "{") {
+		template += "_{" + VarChunkId + "}"
+	}
+
+	name := templateVariable.ReplaceAllStringFunc(template, func(variable string) string {
+		match := templateVariable.FindStringSubmatch(variable)
+		width := defaultIdWidth
+		if match[2] != "" {
+			width, _ = strconv.Atoi(match[2])
+		}
+
+		switch match[1] {
+		case VarMapping:
+			return vars.Mapping
+		case VarChunkId:
+			return fmt.Sprintf("%0*d", width, vars.ChunkId)
+		case VarWorkerId:
+			return fmt.Sprintf("%0*d", width, vars.WorkerId)
+		case VarRunId:
+			return vars.Run.RunId
+		case VarDate:
+			return vars.Run.Start.UTC().Format("20060102")
+		case VarTime:
+			return vars.Run.Start.UTC().Format("150405")
+		case VarCollection:
+			return vars.Run.Collection
+		case VarDatabase:
+			return vars.Run.Database
+		}
+		return variable
+	})
+	return name, nil
+}
@@ -0,0 +1,102 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRenderName(t *testing.T) {
+	vars := NameVars{
+		Mapping:  "users",
+		ChunkId:  42,
+		WorkerId: 3,
+		Run: RunInfo{
+			RunId:      "run",
+			Collection: "people",
+			Database:   "crm",
+			Start:      time.Date(2023, 9, 16, 10, 5, 9, 0, time.UTC),
+		},
+	}
+
+	cases := map[string]string{
+		constants.MappingDefault:                       "users_000042",
+		"{database}.{collection}_{date}T{time}":        "crm.people_20230916T100509",
+		"{run_id}_{worker_id:2}_{chunk_id:3}":          "run_03_042",
+		"export":                                       "export_000042",
+		"{mapping}-{chunk_id:1}":                       "users-42",
+		"{collection}_{chunk_id}_of_run_{run_id}_done": "people_000042_of_run_run_done",
+	}
+	for template, expected := range cases {
+		got, err := RenderName(template, vars)
+		if err != nil {
+			t.Fatalf("%s: %s", template, err)
+		}
+		if got != expected {
+			t.Errorf("%s: expected %s, got %s", template, expected, got)
+		}
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	for _, template := range []string{"{unknown}", "{mapping", "{date:4}", "out/{chunk_id}"} {
+		if err := ValidateTemplate(template); err == nil {
+			t.Errorf("Expected an error for %q", template)
+		}
+	}
+}
+
+func TestTemplateUses(t *testing.T) {
+	if !TemplateUses("users", VarChunkId) || TemplateUses("users", VarWorkerId) {
+		t.Error("Plain prefixes only hold {chunk_id}")
+	}
+	if !TemplateUses("{mapping}_{worker_id:3}", VarWorkerId) || TemplateUses("{mapping}_{worker_id:3}", VarChunkId) {
+		t.Error("Expected {worker_id} to be found, not {chunk_id}")
+	}
+}
+
+func TestDumpStreamsChunkIds(t *testing.T) {
+	tempDir := t.TempDir()
+	dumper, err := NewDumper(Options{Format: constants.FormatNdjson, Run: NewRunInfo("people", "crm")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataChannel := make(chan []*bson.M)
+	var workers errgroup.Group
+	for i := 0; i < 4; i++ {
+		workers.Go(func() error {
+			return dumper.DumpStreams(context.Background(), dataChannel, "users", constants.MappingDefault, tempDir)
+		})
+	}
+	chunks := 20
+	for i := 1; i <= chunks; i++ {
+		dataChannel <- []*bson.M{{"batch": int32(i)}}
+	}
+	close(dataChannel)
+	if err := workers.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every chunk file holds the batch sent in the same position
+	for i := 1; i <= chunks; i++ {
+		data, err := os.ReadFile(filepath.Join(tempDir, fmt.Sprintf("users_%06d.ndjson", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := bson.M{}
+		if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc["batch"] != int32(i) {
+			t.Errorf("Chunk %d holds batch %v", i, doc["batch"])
+		}
+	}
+}
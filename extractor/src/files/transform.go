@@ -0,0 +1,301 @@
+package files
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Field renames and type conversions applied to documents before they are written.
+// Paths are dotted (address.city) and go through arrays of documents.
+type Transform struct {
+	// Field path to its new name (in the same document)
+	Rename map[string]string
+	// Field path, after renames, to its output type (constants.Convert*)
+	Convert map[string]string
+
+	// Rename paths in the order they are applied
+	renames []string
+}
+
+// Validates renames and conversions, nil when there is nothing to do
+func NewTransform(rename, convert map[string]string) (*Transform, error) {
+	if len(rename) == 0 && len(convert) == 0 {
+		return nil, nil
+	}
+	renames := make([]string, 0, len(rename))
+	for path, name := range rename {
+		if path == "" || name == "" || strings.Contains(name, ".") {
+			return nil, fmt.Errorf("invalid rename of %q to %q, new name must be a single field name", path, name)
+		}
+		renames = append(renames, path)
+	}
+	if err := checkRenames(rename); err != nil {
+		return nil, err
+	}
+	// Nested fields are renamed before their parents, so their paths are still found
+	sort.Slice(renames, func(i, j int) bool {
+		depthI, depthJ := strings.Count(renames[i], "."), strings.Count(renames[j], ".")
+		if depthI != depthJ {
+			return depthI > depthJ
+		}
+		return renames[i] < renames[j]
+	})
+	for path, kind := range convert {
+		switch kind {
+		case constants.ConvertString, constants.ConvertInt, constants.ConvertLong, constants.ConvertDouble,
+			constants.ConvertDecimal, constants.ConvertBool, constants.ConvertDate, constants.ConvertObjectId:
+		default:
+			return nil, fmt.Errorf("invalid conversion of %s: unknown type %q", path, kind)
+		}
+	}
+	return &Transform{Rename: rename, Convert: convert, renames: renames}, nil
+}
+
+// Rejects renames whose result depends on the order they are applied in: two fields renamed to the same path,
+// or a field renamed to the path of another renamed field
+func checkRenames(rename map[string]string) error {
+	targets := make(map[string]string, len(rename))
+	for path, name := range rename {
+		target := name
+		if i := strings.LastIndex(path, "."); i >= 0 {
+			target = path[:i+1] + name
+		}
+		if other, found := targets[target]; found {
+			first, second := other, path
+			if second < first {
+				first, second = second, first
+			}
+			return fmt.Errorf("renames of %s and %s both target %s", first, second, target)
+		}
+		targets[target] = path
+	}
+	for target, path := range targets {
+		if _, renamed := rename[target]; renamed && target != path {
+			return fmt.Errorf("cannot rename %s to %s: %s is renamed too", path, target, target)
+		}
+	}
+	return nil
+}
+
+// Renames then converts fields of every document, in place (no op on a nil transform)
+func (t *Transform) Apply(results []*bson.M) error {
+	if t == nil {
+		return nil
+	}
+	for _, doc := range results {
+		for _, path := range t.renames {
+			name := t.Rename[path]
+			if err := visit(*doc, strings.Split(path, "."), func(parent bson.M, key string) error {
+				value, found := parent[key]
+				if !found {
+					return nil
+				}
+				if _, exists := parent[name]; exists && name != key {
+					return fmt.Errorf("cannot rename %s to %s: field already exists", path, name)
+				}
+				delete(parent, key)
+				parent[name] = value
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		for path, kind := range t.Convert {
+			if err := visit(*doc, strings.Split(path, "."), func(parent bson.M, key string) error {
+				value, found := parent[key]
+				if !found || value == nil {
+					return nil
+				}
+				converted, err := convertValue(value, kind)
+				if err != nil {
+					return fmt.Errorf("cannot convert %s: %w", path, err)
+				}
+				parent[key] = converted
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Calls fn with the document holding the last key of path, once per array item along the way
+func visit(doc bson.M, path []string, fn func(parent bson.M, key string) error) error {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	switch child := doc[path[0]].(type) {
+	case bson.M:
+		return visit(child, path[1:], fn)
+	case bson.A:
+		for _, item := range child {
+			if nested, ok := item.(bson.M); ok {
+				if err := visit(nested, path[1:], fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Converts a value (or every item of an array) to the given type
+func convertValue(value any, kind string) (any, error) {
+	if items, ok := value.(bson.A); ok && kind != constants.ConvertString {
+		converted := make(bson.A, len(items))
+		for i, item := range items {
+			v, err := convertValue(item, kind)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = v
+		}
+		return converted, nil
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	switch kind {
+	case constants.ConvertString:
+		return formatValue(value)
+	case constants.ConvertInt:
+		n, err := asInt64(value)
+		if err != nil {
+			return nil, err
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("%d overflows int", n)
+		}
+		return int32(n), nil
+	case constants.ConvertLong:
+		return asInt64(value)
+	case constants.ConvertDouble:
+		return asFloat64(value)
+	case constants.ConvertDecimal:
+		return asDecimal(value)
+	case constants.ConvertBool:
+		return asBool(value)
+	case constants.ConvertDate:
+		return asDate(value)
+	case constants.ConvertObjectId:
+		return asObjectId(value)
+	}
+	return nil, fmt.Errorf("unknown type %q", kind)
+}
+
+func asInt64(value any) (int64, error) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v > math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not an integer", v)
+		}
+		return int64(v), nil
+	case primitive.Decimal128:
+		n, ok := new(big.Rat).SetString(v.String())
+		if !ok || !n.IsInt() || !n.Num().IsInt64() {
+			return 0, fmt.Errorf("%s is not an integer", v)
+		}
+		return n.Num().Int64(), nil
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case primitive.DateTime:
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("%T is not a number", value)
+}
+
+func asFloat64(value any) (float64, error) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case primitive.Decimal128:
+		return strconv.ParseFloat(v.String(), 64)
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	return 0, fmt.Errorf("%T is not a number", value)
+}
+
+func asDecimal(value any) (primitive.Decimal128, error) {
+	switch v := value.(type) {
+	case primitive.Decimal128:
+		return v, nil
+	case int32, int64, float64:
+		s, _ := formatScalar(v)
+		return primitive.ParseDecimal128(s)
+	case string:
+		return primitive.ParseDecimal128(strings.TrimSpace(v))
+	}
+	return primitive.Decimal128{}, fmt.Errorf("%T is not a number", value)
+}
+
+func asBool(value any) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int32:
+		return v != 0, nil
+	case int64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(v))
+	}
+	return false, fmt.Errorf("%T is not a boolean", value)
+}
+
+func asDate(value any) (primitive.DateTime, error) {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v, nil
+	case primitive.ObjectID:
+		return primitive.NewDateTimeFromTime(v.Timestamp()), nil
+	case int32, int64, float64:
+		millis, err := asInt64(v)
+		return primitive.DateTime(millis), err
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				return primitive.NewDateTimeFromTime(t), nil
+			}
+		}
+		return 0, fmt.Errorf("%q is not a RFC 3339 or YYYY-MM-DD date", v)
+	}
+	return 0, fmt.Errorf("%T is not a date", value)
+}
+
+func asObjectId(value any) (primitive.ObjectID, error) {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return v, nil
+	case string:
+		return primitive.ObjectIDFromHex(strings.TrimSpace(v))
+	}
+	return primitive.NilObjectID, fmt.Errorf("%T is not an ObjectId", value)
+}
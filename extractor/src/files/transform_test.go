@@ -0,0 +1,114 @@
+package files
+
+import (
+	"testing"
+	"time"
+
+	constants "github.com/farovictor/MongoDbExtractor/src/constants"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTransformApply(t *testing.T) {
+	transform, err := NewTransform(
+		map[string]string{"address.zip": "postal_code", "items.qty": "quantity", "_id": "id"},
+		map[string]string{
+			"id":             constants.ConvertString,
+			"age":            constants.ConvertInt,
+			"amount":         constants.ConvertDecimal,
+			"created":        constants.ConvertDate,
+			"active":         constants.ConvertBool,
+			"items.quantity": constants.ConvertLong,
+			"tags":           constants.ConvertString,
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oid := primitive.NewObjectID()
+	doc := bson.M{
+		"_id":     oid,
+		"age":     "42",
+		"amount":  12.5,
+		"created": "2023-09-16",
+		"active":  int32(1),
+		"address": bson.M{"zip": "01000"},
+		"items":   bson.A{bson.M{"qty": int32(2)}, bson.M{"qty": 3.0}},
+		"tags":    bson.A{"a", "b"},
+		"missing": nil,
+	}
+	if err := transform.Apply([]*bson.M{&doc}); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc["id"] != oid.Hex() || doc["_id"] != nil {
+		t.Errorf("Expected _id renamed and converted to string, got %v", doc)
+	}
+	if doc["age"] != int32(42) || doc["active"] != true {
+		t.Errorf("Unexpected int or bool conversion: %v %v", doc["age"], doc["active"])
+	}
+	if amount, ok := doc["amount"].(primitive.Decimal128); !ok || amount.String() != "12.5" {
+		t.Errorf("Unexpected decimal conversion: %v", doc["amount"])
+	}
+	if doc["created"] != primitive.NewDateTimeFromTime(time.Date(2023, 9, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected date conversion: %v", doc["created"])
+	}
+	if doc["address"].(bson.M)["postal_code"] != "01000" {
+		t.Errorf("Expected nested rename, got %v", doc["address"])
+	}
+	for _, item := range doc["items"].(bson.A) {
+		if _, ok := item.(bson.M)["quantity"].(int64); !ok {
+			t.Errorf("Expected array items renamed and converted, got %v", item)
+		}
+	}
+	if doc["tags"] != `["a","b"]` {
+		t.Errorf("Expected array converted to json string, got %v", doc["tags"])
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	if _, err := NewTransform(nil, map[string]string{"age": "integer"}); err == nil {
+		t.Error("Expected an error for unknown type")
+	}
+	if _, err := NewTransform(map[string]string{"a": "b.c"}, nil); err == nil {
+		t.Error("Expected an error for a dotted new name")
+	}
+	for _, rename := range []map[string]string{
+		{"a": "c", "b": "c"},
+		{"x.a": "c", "x.b": "c"},
+		{"a": "b", "b": "c"},
+	} {
+		if _, err := NewTransform(rename, nil); err == nil {
+			t.Errorf("Expected conflicting renames %v to be rejected", rename)
+		}
+	}
+	if transform, err := NewTransform(nil, nil); transform != nil || err != nil {
+		t.Error("Expected no transform")
+	}
+
+	transform, _ := NewTransform(map[string]string{"a": "b"}, map[string]string{"n": constants.ConvertInt})
+	for _, doc := range []bson.M{{"a": 1, "b": 2}, {"n": 1.5}, {"n": int64(1 << 40)}, {"n": "x"}} {
+		if err := transform.Apply([]*bson.M{&doc}); err == nil {
+			t.Errorf("Expected an error for %v", doc)
+		}
+	}
+}
+
+func TestTransformRenameOrder(t *testing.T) {
+	// Nested field is renamed before its parent, whatever the map order
+	transform, err := NewTransform(map[string]string{"address": "location", "address.zip": "postal_code", "a": "c", "x.a": "c"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		doc := bson.M{"address": bson.M{"zip": "01000"}, "a": 1, "x": bson.M{"a": 2}}
+		if err := transform.Apply([]*bson.M{&doc}); err != nil {
+			t.Fatal(err)
+		}
+		location, ok := doc["location"].(bson.M)
+		if !ok || location["postal_code"] != "01000" || doc["c"] != 1 || doc["x"].(bson.M)["c"] != 2 {
+			t.Fatalf("Unexpected renames: %v", doc)
+		}
+	}
+}
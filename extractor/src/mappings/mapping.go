@@ -0,0 +1,173 @@
+package mappings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Extensions mapping files are looked up with, in order
+var extensions = []string{".yaml", ".yml", ".json"}
+
+// Extraction defined in a mapping file (<mappings dir>/<name>.yaml, .yml or .json).
+// Filter, projection, sort, hint and pipeline are extended json, written either as a
+// json string or as plain yaml/json values (key order is kept).
+type Mapping struct {
+	// Mapping name, taken from file name
+	Name             string            `yaml:"-" json:"-"`
+	Collection       string            `yaml:"collection" json:"collection"`
+	Filter           ExtJSON           `yaml:"filter" json:"filter"`
+	Projection       ExtJSON           `yaml:"projection" json:"projection"`
+	Sort             ExtJSON           `yaml:"sort" json:"sort"`
+	Limit            int64             `yaml:"limit" json:"limit"`
+	Skip             int64             `yaml:"skip" json:"skip"`
+	Hint             ExtJSON           `yaml:"hint" json:"hint"`
+	Pipeline         ExtJSON           `yaml:"pipeline" json:"pipeline"`
+	IncrementalField string            `yaml:"incremental_field" json:"incremental_field"`
+	Rename           map[string]string `yaml:"rename" json:"rename"`
+	Convert          map[string]string `yaml:"convert" json:"convert"`
+	Output           Output            `yaml:"output" json:"output"`
+}
+
+// Output settings of a mapping
+type Output struct {
+	Format    string `yaml:"format" json:"format"`
+	Prefix    string `yaml:"prefix" json:"prefix"`
+	Path      string `yaml:"path" json:"path"`
+	Compress  string `yaml:"compress" json:"compress"`
+	ChunkSize int32  `yaml:"chunk_size" json:"chunk_size"`
+}
+
+// Finds the file of a mapping: name may be a path to a mapping file, otherwise it is
+// looked up in dir. Found is false when there is no such file (mapping is only a name).
+func Resolve(dir, name string) (path string, found bool, err error) {
+	for _, ext := range extensions {
+		if strings.EqualFold(filepath.Ext(name), ext) {
+			if _, err = os.Stat(name); err != nil {
+				return "", false, err
+			}
+			return name, true, nil
+		}
+	}
+	if dir == "" {
+		return "", false, nil
+	}
+	for _, ext := range extensions {
+		path = filepath.Join(dir, name+ext)
+		if _, err = os.Stat(path); err == nil {
+			return path, true, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", false, err
+		}
+	}
+	return "", false, nil
+}
+
+// Reads a mapping file, unknown fields are rejected
+func Load(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := &Mapping{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(mapping)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(mapping)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapping file %s: %w", path, err)
+	}
+	mapping.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return mapping, nil
+}
+
+// Extended json text of a mapping value
+type ExtJSON string
+
+// A json string is taken as is, any other value as its json text
+func (e *ExtJSON) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*e = ExtJSON(s)
+		return nil
+	}
+	*e = ExtJSON(bytes.TrimSpace(data))
+	return nil
+}
+
+// A yaml string is taken as is, any other value is rendered as json, keeping key order
+func (e *ExtJSON) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		*e = ExtJSON(node.Value)
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, node); err != nil {
+		return err
+	}
+	*e = ExtJSON(buf.String())
+	return nil
+}
+
+func writeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return writeJSON(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err = writeJSON(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case yaml.ScalarNode:
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		buf.Write(data)
+	default:
+		return fmt.Errorf("line %d: unsupported yaml value", node.Line)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package mappings
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const yamlMapping = `
+collection: people
+filter: '{"created":{"$gte":{"$date":"2023-01-01T00:00:00Z"}}}'
+projection:
+  name: 1
+  address.city: 1
+  _id: 0
+sort: {created: -1, name: 1}
+limit: 1000
+hint: created_-1
+rename:
+  address.city: town
+convert:
+  age: int
+output:
+  format: ndjson
+  prefix: "{mapping}_{date}_{chunk_id}"
+  compress: zstd
+  chunk_size: 500
+`
+
+const jsonMapping = `{
+  "collection": "orders",
+  "pipeline": [{"$match": {"status": "paid"}}, {"$group": {"_id": "$user", "total": {"$sum": "$amount"}}}],
+  "output": {"format": "parquet"}
+}`
+
+func writeMapping(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadYaml(t *testing.T) {
+	dir := t.TempDir()
+	writeMapping(t, dir, "people.yaml", yamlMapping)
+
+	path, found, err := Resolve(dir, "people")
+	if err != nil || !found {
+		t.Fatalf("Expected mapping file to be found: %v", err)
+	}
+	mapping, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mapping.Name != "people" || mapping.Collection != "people" || mapping.Limit != 1000 || string(mapping.Hint) != "created_-1" {
+		t.Errorf("Unexpected mapping: %+v", mapping)
+	}
+	if mapping.Filter != `{"created":{"$gte":{"$date":"2023-01-01T00:00:00Z"}}}` {
+		t.Errorf("Unexpected filter: %s", mapping.Filter)
+	}
+	// Key order is kept
+	if mapping.Projection != `{"name":1,"address.city":1,"_id":0}` || mapping.Sort != `{"created":-1,"name":1}` {
+		t.Errorf("Unexpected projection or sort: %s %s", mapping.Projection, mapping.Sort)
+	}
+	if mapping.Rename["address.city"] != "town" || mapping.Convert["age"] != "int" {
+		t.Errorf("Unexpected renames or conversions: %v %v", mapping.Rename, mapping.Convert)
+	}
+	if mapping.Output != (Output{Format: "ndjson", Prefix: "{mapping}_{date}_{chunk_id}", Compress: "zstd", ChunkSize: 500}) {
+		t.Errorf("Unexpected output: %+v", mapping.Output)
+	}
+}
+
+func TestLoadJson(t *testing.T) {
+	dir := t.TempDir()
+	path := writeMapping(t, dir, "orders.json", jsonMapping)
+
+	// A path to a mapping file is used as is
+	resolved, found, err := Resolve("", path)
+	if err != nil || !found || resolved != path {
+		t.Fatalf("Expected %s, got %s (%v)", path, resolved, err)
+	}
+	mapping, err := Load(resolved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"$match": {"status": "paid"}}, {"$group": {"_id": "$user", "total": {"$sum": "$amount"}}}]`
+	if mapping.Name != "orders" || string(mapping.Pipeline) != expected || mapping.Output.Format != "parquet" {
+		t.Errorf("Unexpected mapping: %+v", mapping)
+	}
+}
+
+func TestResolveMissing(t *testing.T) {
+	dir := t.TempDir()
+	if _, found, err := Resolve(dir, "unknown"); found || err != nil {
+		t.Errorf("Expected mapping to be a plain name, got %v %v", found, err)
+	}
+	if _, found, err := Resolve(filepath.Join(dir, "missing"), "unknown"); found || err != nil {
+		t.Errorf("A missing mappings folder must not be an error, got %v %v", found, err)
+	}
+	if _, _, err := Resolve(dir, filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("Expected an error for a missing mapping file path")
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"typo.yaml": "collection: people\nfilters: {}\n",
+		"typo.json": `{"collection": "people", "output": {"fromat": "csv"}}`,
+	} {
+		if _, err := Load(writeMapping(t, dir, name, content)); err == nil {
+			t.Errorf("%s: expected an error for unknown field", name)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Field of watermarks holding a change stream resume token (watch command)
+const ResumeTokenField = "$resumeToken"
+
+// High-water mark of an incremental extraction: highest value of Field extracted so far.
+// For change streams, Value is the resume token following the last event written.
+type Watermark struct {
+	Database   string
+	Collection string
+	Field      string
+	Value      any
+	RunId      string
+	UpdatedAt  time.Time
+}
+
+// Persists watermarks of incremental extractions, one per key (mapping name)
+type Store interface {
+	// Returns the watermark saved under key, nil when there is none
+	Load(key string) (*Watermark, error)
+	// Saves the watermark under key, replacing the previous one
+	Save(key string, watermark Watermark) error
+}
+
+// Local json file holding every watermark, values as canonical extended json
+type FileStore struct {
+	path string
+}
+
+// Watermark as written into a state file
+type fileEntry struct {
+	Database   string          `json:"database"`
+	Collection string          `json:"collection"`
+	Field      string          `json:"field"`
+	Value      json.RawMessage `json:"value"`
+	RunId      string          `json:"run_id"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// Store backed by a json file at path (created on first save)
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load(key string) (*Watermark, error) {
+	entries, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	entry, found := entries[key]
+	if !found {
+		return nil, nil
+	}
+
+	// Extended json parser only accepts documents at top level
+	var wrapper struct {
+		Value any `bson:"value"`
+	}
+	if err = bson.UnmarshalExtJSON([]byte(`{"value":`+string(entry.Value)+`}`), true, &wrapper); err != nil {
+		return nil, fmt.Errorf("invalid watermark %s in %s: %w", key, s.path, err)
+	}
+	return &Watermark{
+		Database:   entry.Database,
+		Collection: entry.Collection,
+		Field:      entry.Field,
+		Value:      wrapper.Value,
+		RunId:      entry.RunId,
+		UpdatedAt:  entry.UpdatedAt,
+	}, nil
+}
+
+func (s *FileStore) Save(key string, watermark Watermark) error {
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "value", Value: watermark.Value}}, true, false)
+	if err != nil {
+		return err
+	}
+	var wrapper struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err = json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	entries[key] = fileEntry{
+		Database:   watermark.Database,
+		Collection: watermark.Collection,
+		Field:      watermark.Field,
+		Value:      wrapper.Value,
+		RunId:      watermark.RunId,
+		UpdatedAt:  watermark.UpdatedAt,
+	}
+
+	data, err = json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Written next to the state file then renamed, so a crash never leaves a truncated file
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileStore) read() (map[string]fileEntry, error) {
+	entries := map[string]fileEntry{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", s.path, err)
+	}
+	return entries, nil
+}
+
+// Mongo collection holding one document per watermark (_id is the key)
+type CollectionStore struct {
+	coll *mongodb.Collection
+}
+
+// Watermark as stored in a state collection
+type collectionEntry struct {
+	Key        string    `bson:"_id"`
+	Database   string    `bson:"database"`
+	Collection string    `bson:"collection"`
+	Field      string    `bson:"field"`
+	Value      any       `bson:"value"`
+	RunId      string    `bson:"run_id"`
+	UpdatedAt  time.Time `bson:"updated_at"`
+}
+
+// Store backed by a collection
+func NewCollectionStore(coll *mongodb.Collection) *CollectionStore {
+	return &CollectionStore{coll: coll}
+}
+
+func (s *CollectionStore) Load(key string) (*Watermark, error) {
+	var entry collectionEntry
+	err := s.coll.FindOne(context.Background(), bson.D{{Key: "_id", Value: key}}).Decode(&entry)
+	if errors.Is(err, mongodb.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Watermark{
+		Database:   entry.Database,
+		Collection: entry.Collection,
+		Field:      entry.Field,
+		Value:      entry.Value,
+		RunId:      entry.RunId,
+		UpdatedAt:  entry.UpdatedAt,
+	}, nil
+}
+
+func (s *CollectionStore) Save(key string, watermark Watermark) error {
+	entry := collectionEntry{
+		Key:        key,
+		Database:   watermark.Database,
+		Collection: watermark.Collection,
+		Field:      watermark.Field,
+		Value:      watermark.Value,
+		RunId:      watermark.RunId,
+		UpdatedAt:  watermark.UpdatedAt,
+	}
+	_, err := s.coll.ReplaceOne(context.Background(), bson.D{{Key: "_id", Value: key}}, entry, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Adds the watermark range to filter: documents with field above from (when set) and up to to
+func Filter(filter bson.D, field string, from, to any) bson.D {
+	condition := bson.D{}
+	if from != nil {
+		condition = append(condition, bson.E{Key: "$gt", Value: from})
+	}
+	condition = append(condition, bson.E{Key: "$lte", Value: to})
+
+	incremental := bson.D{{Key: field, Value: condition}}
+	if len(filter) == 0 {
+		return incremental
+	}
+	return bson.D{{Key: "$and", Value: bson.A{filter, incremental}}}
+}
@@ -0,0 +1,116 @@
+package state
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := NewFileStore(path)
+
+	watermark, err := store.Load("users")
+	if err != nil || watermark != nil {
+		t.Fatalf("Expected no watermark, got %v (%v)", watermark, err)
+	}
+
+	oid := primitive.NewObjectID()
+	updated := primitive.NewDateTimeFromTime(time.Date(2023, 9, 16, 10, 0, 0, 0, time.UTC))
+	saved := map[string]Watermark{
+		"users":  {Database: "crm", Collection: "people", Field: "_id", Value: oid, RunId: "run-1"},
+		"orders": {Database: "crm", Collection: "orders", Field: "updatedAt", Value: updated, RunId: "run-2"},
+		"events": {Database: "crm", Collection: "events", Field: "seq", Value: int64(1) << 40, RunId: "run-3"},
+	}
+	for key, watermark := range saved {
+		if err := store.Save(key, watermark); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saving again replaces the previous value
+	next := primitive.NewObjectID()
+	if err := store.Save("users", Watermark{Database: "crm", Collection: "people", Field: "_id", Value: next, RunId: "run-4"}); err != nil {
+		t.Fatal(err)
+	}
+	saved["users"] = Watermark{Database: "crm", Collection: "people", Field: "_id", Value: next, RunId: "run-4"}
+
+	// Values keep their bson type across runs
+	reopened := NewFileStore(path)
+	for key, expected := range saved {
+		watermark, err := reopened.Load(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if watermark == nil || watermark.Value != expected.Value || watermark.Field != expected.Field || watermark.RunId != expected.RunId {
+			t.Errorf("%s: expected %+v, got %+v", key, expected, watermark)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected temporary files to be removed, found %d files", len(entries))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	oid := primitive.NewObjectID()
+
+	first := Filter(nil, "_id", nil, oid)
+	expected := bson.D{{Key: "_id", Value: bson.D{{Key: "$lte", Value: oid}}}}
+	if !equal(t, first, expected) {
+		t.Errorf("Unexpected first run filter: %v", first)
+	}
+
+	query := bson.D{{Key: "status", Value: "active"}}
+	next := Filter(query, "seq", int64(10), int64(20))
+	expected = bson.D{{Key: "$and", Value: bson.A{
+		query,
+		bson.D{{Key: "seq", Value: bson.D{{Key: "$gt", Value: int64(10)}, {Key: "$lte", Value: int64(20)}}}},
+	}}}
+	if !equal(t, next, expected) {
+		t.Errorf("Unexpected incremental filter: %v", next)
+	}
+}
+
+func equal(t *testing.T, a, b bson.D) bool {
+	dataA, err := bson.MarshalExtJSON(a, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataB, err := bson.MarshalExtJSON(b, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(dataA) == string(dataB)
+}
+
+func TestFileStoreResumeToken(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	token, err := bson.Marshal(bson.D{{Key: "_data", Value: "8265A1B2C3000000012B022C0100296E5A1004"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Save("changes", Watermark{Database: "crm", Field: ResumeTokenField, Value: bson.Raw(token), RunId: "run-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	watermark, err := store.Load("changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := bson.Marshal(watermark.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, token) {
+		t.Errorf("Expected resume token %v, got %v", bson.Raw(token), bson.Raw(loaded))
+	}
+}
@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	file "github.com/farovictor/MongoDbLoader/src/fs"
+	logger "github.com/farovictor/MongoDbLoader/src/logging"
+	mongo "github.com/farovictor/MongodbDriver"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	options "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Execution logic for apply-changes command
+func ApplyChanges(cmd *cobra.Command, args []string) {
+	logger.Initialize(logLevel)
+
+	if collectionName == "" {
+		logger.ErrorLogger.Fatalln("No collection specified")
+	}
+	if numConcurrentFiles <= 0 || insertBatchSize <= 0 {
+		logger.ErrorLogger.Fatalln("--num-concurrent-files and --batch-size must be positive")
+	}
+
+	changeFiles, err := file.ListChangeFiles(filePrefix, searchPath)
+	if err != nil {
+		logger.ErrorLogger.Fatalln("Error listing change files:", err)
+	}
+	if len(changeFiles) == 0 {
+		logger.WarningLogger.Println("No change events to apply.")
+		return
+	}
+	logger.InfoLogger.Printf("%d change files found\n", len(changeFiles))
+
+	handler, disconnect := mongo.NewConnectionHandler(connUri, dbName, appName)
+	defer disconnect()
+
+	coll := handler.GetCollection(collectionName)
+
+	logger.InfoLogger.Println("Applying changes")
+	if err := applyChangeFiles(coll, changeFiles); err != nil {
+		logger.ErrorLogger.Fatalln(err)
+	}
+	logger.InfoLogger.Println("Ending Apply Changes")
+}
+
+// Totals of an apply-changes run
+type changeResult struct {
+	mu       sync.Mutex
+	events   int64
+	skipped  int64
+	ignored  int64
+	inserted int64
+	modified int64
+	deleted  int64
+	err      error
+	// Smallest token of the events not applied (every event before it was)
+	unapplied string
+}
+
+func (r *changeResult) applied(result *mongodb.BulkWriteResult, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if result != nil {
+		r.inserted += result.UpsertedCount
+		r.modified += result.ModifiedCount
+		r.deleted += result.DeletedCount
+	}
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *changeResult) notApplied(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.unapplied == "" || token < r.unapplied {
+		r.unapplied = token
+	}
+}
+
+func (r *changeResult) failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err != nil
+}
+
+// Applies the events of change files, in stream order, to a collection. Events are spread over workers
+// by document _id, so events of a document are applied in order by the same worker, in ordered bulk writes.
+// Events read twice in a run (token not above the last one read) are skipped, so overlapping files are applied
+// once. Nothing is kept between runs: files are applied again from their first event.
+func applyChangeFiles(coll *mongodb.Collection, changeFiles []file.ChangeFile) error {
+	ctx := context.Background()
+	result := &changeResult{}
+
+	pipes := make([]chan file.ChangeEvent, numConcurrentFiles)
+	var wg sync.WaitGroup
+	for i := range pipes {
+		pipes[i] = make(chan file.ChangeEvent, insertBatchSize)
+		wg.Add(1)
+		go applyEvents(ctx, pipes[i], &wg, coll, result)
+	}
+
+	var lastToken string
+	for _, changeFile := range changeFiles {
+		if result.failed() {
+			break
+		}
+		events, err := file.ReadChangeEvents(changeFile.Path)
+		if err != nil {
+			result.applied(nil, err)
+			break
+		}
+		logger.DebugLogger.Printf("%s: %d events\n", changeFile.Path, len(events))
+
+		for _, event := range events {
+			result.events++
+			if event.Id.Data <= lastToken {
+				result.skipped++
+				continue
+			}
+			lastToken = event.Id.Data
+			if sourceCollection != "" && event.Namespace.Collection != sourceCollection {
+				result.ignored++
+				continue
+			}
+			worker, err := eventWorker(event, len(pipes))
+			if err != nil {
+				result.applied(nil, err)
+				result.notApplied(event.Id.Data)
+				break
+			}
+			pipes[worker] <- event
+		}
+	}
+
+	for _, pipe := range pipes {
+		close(pipe)
+	}
+	wg.Wait()
+
+	logger.InfoLogger.Printf("%d events read, %d already applied, %d of other collections: %d documents upserted, %d modified, %d deleted\n",
+		result.events, result.skipped, result.ignored, result.inserted, result.modified, result.deleted)
+	if result.err != nil {
+		if result.unapplied != "" {
+			return fmt.Errorf("changes were not all applied, only events before token %s were: %w", result.unapplied, result.err)
+		}
+		return fmt.Errorf("changes were not all applied, only events up to token %s were: %w", lastToken, result.err)
+	}
+	return nil
+}
+
+// Worker applying the events of a document, chosen out of its _id
+func eventWorker(event file.ChangeEvent, workers int) (int, error) {
+	id, err := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: documentId(event.DocumentKey)}}, true, false)
+	if err != nil {
+		return 0, err
+	}
+	hash := fnv.New32a()
+	hash.Write(id)
+	return int(hash.Sum32() % uint32(workers)), nil
+}
+
+func documentId(documentKey bson.D) any {
+	for _, elem := range documentKey {
+		if elem.Key == "_id" {
+			return elem.Value
+		}
+	}
+	return nil
+}
+
+// This function retrieves events from channel and applies them to a collection, in ordered bulk writes.
+// Once a bulk write failed, later events are drained without being applied. The first token of every
+// batch not applied is recorded.
+func applyEvents(ctx context.Context, events <-chan file.ChangeEvent, wg *sync.WaitGroup, coll *mongodb.Collection, result *changeResult) {
+	defer wg.Done()
+
+	opts := options.BulkWrite().SetOrdered(true)
+	models := make([]mongodb.WriteModel, 0, insertBatchSize)
+	// Token of the first event of models
+	var first string
+	flush := func() {
+		defer func() {
+			models, first = models[:0], ""
+		}()
+		if len(models) == 0 {
+			return
+		}
+		if result.failed() {
+			result.notApplied(first)
+			return
+		}
+		res, err := coll.BulkWrite(ctx, models, opts)
+		result.applied(res, err)
+		if err != nil {
+			result.notApplied(first)
+		}
+		logger.DebugLogger.Printf("Applied %d operations\n", len(models))
+	}
+
+	for event := range events {
+		eventModels, err := writeModels(event)
+		if err != nil {
+			result.applied(nil, err)
+			result.notApplied(event.Id.Data)
+			continue
+		}
+		if len(eventModels) > 0 && first == "" {
+			first = event.Id.Data
+		}
+		models = append(models, eventModels...)
+		if int32(len(models)) >= insertBatchSize {
+			flush()
+		}
+	}
+	flush()
+}
+
+// Write operations replaying a change event. Inserts and replaces are upserts, so replaying an event twice
+// gives the same document. Events of other types (drop, rename, invalidate...) are not replayed.
+func writeModels(event file.ChangeEvent) ([]mongodb.WriteModel, error) {
+	switch event.OperationType {
+	case "insert", "update", "replace", "delete":
+		if len(event.DocumentKey) == 0 {
+			return nil, fmt.Errorf("%s event %s has no document key", event.OperationType, event.Id.Data)
+		}
+	default:
+		logger.WarningLogger.Printf("%s event %s is not replayed\n", event.OperationType, event.Id.Data)
+		return nil, nil
+	}
+
+	switch event.OperationType {
+	case "insert", "replace":
+		if event.FullDocument == nil {
+			return nil, fmt.Errorf("%s event %s has no full document", event.OperationType, event.Id.Data)
+		}
+		return []mongodb.WriteModel{
+			mongodb.NewReplaceOneModel().SetFilter(event.DocumentKey).SetReplacement(event.FullDocument).SetUpsert(true),
+		}, nil
+
+	case "update":
+		if event.UpdateDescription == nil {
+			// Only the document after the update was written (--full-document)
+			if event.FullDocument == nil {
+				return nil, fmt.Errorf("update event %s has no update description nor full document", event.Id.Data)
+			}
+			return []mongodb.WriteModel{
+				mongodb.NewReplaceOneModel().SetFilter(event.DocumentKey).SetReplacement(event.FullDocument),
+			}, nil
+		}
+		var models []mongodb.WriteModel
+		description := event.UpdateDescription
+
+		// Arrays are truncated before fields are updated, in a separate update since paths may overlap
+		if len(description.TruncatedArrays) > 0 {
+			truncate := bson.D{}
+			for _, array := range description.TruncatedArrays {
+				truncate = append(truncate, bson.E{Key: array.Field, Value: bson.D{
+					{Key: "$each", Value: bson.A{}},
+					{Key: "$slice", Value: array.NewSize},
+				}})
+			}
+			models = append(models, mongodb.NewUpdateOneModel().SetFilter(event.DocumentKey).SetUpdate(bson.D{{Key: "$push", Value: truncate}}))
+		}
+
+		update := bson.D{}
+		if len(description.UpdatedFields) > 0 {
+			update = append(update, bson.E{Key: "$set", Value: description.UpdatedFields})
+		}
+		if len(description.RemovedFields) > 0 {
+			unset := bson.D{}
+			for _, field := range description.RemovedFields {
+				unset = append(unset, bson.E{Key: field, Value: ""})
+			}
+			update = append(update, bson.E{Key: "$unset", Value: unset})
+		}
+		if len(update) > 0 {
+			models = append(models, mongodb.NewUpdateOneModel().SetFilter(event.DocumentKey).SetUpdate(update))
+		}
+		return models, nil
+	}
+
+	// Delete
+	return []mongodb.WriteModel{mongodb.NewDeleteOneModel().SetFilter(event.DocumentKey)}, nil
+}
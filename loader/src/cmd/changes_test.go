@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"testing"
+
+	file "github.com/farovictor/MongoDbLoader/src/fs"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestWriteModels(t *testing.T) {
+	key := bson.D{{Key: "_id", Value: 7}}
+	document := bson.D{{Key: "_id", Value: 7}, {Key: "name", Value: "Ana"}}
+
+	models, err := writeModels(file.ChangeEvent{OperationType: "insert", DocumentKey: key, FullDocument: document})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replace, ok := models[0].(*mongodb.ReplaceOneModel)
+	if len(models) != 1 || !ok || replace.Upsert == nil || !*replace.Upsert {
+		t.Errorf("Expected inserts to be replayed as upserts, got %+v", models)
+	}
+
+	update := file.ChangeEvent{OperationType: "update", DocumentKey: key, UpdateDescription: &file.UpdateDescription{
+		UpdatedFields:   bson.D{{Key: "tags.0", Value: "x"}},
+		RemovedFields:   []string{"age"},
+		TruncatedArrays: []file.TruncatedArray{{Field: "tags", NewSize: 2}},
+	}}
+	models, err = writeModels(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("Expected truncation and update, got %+v", models)
+	}
+	truncate := models[0].(*mongodb.UpdateOneModel).Update.(bson.D)
+	if truncate[0].Key != "$push" {
+		t.Errorf("Expected arrays truncated first, got %v", truncate)
+	}
+	fields := models[1].(*mongodb.UpdateOneModel).Update.(bson.D)
+	if len(fields) != 2 || fields[0].Key != "$set" || fields[1].Key != "$unset" {
+		t.Errorf("Unexpected update: %v", fields)
+	}
+
+	models, err = writeModels(file.ChangeEvent{OperationType: "delete", DocumentKey: key})
+	if _, ok := models[0].(*mongodb.DeleteOneModel); err != nil || !ok {
+		t.Errorf("Expected a delete, got %+v (%v)", models, err)
+	}
+
+	if models, err = writeModels(file.ChangeEvent{OperationType: "drop"}); err != nil || models != nil {
+		t.Errorf("Expected drop events to be ignored, got %+v (%v)", models, err)
+	}
+	if _, err = writeModels(file.ChangeEvent{OperationType: "replace", DocumentKey: key}); err == nil {
+		t.Error("Expected a replace without full document to be rejected")
+	}
+}
+
+func TestEventWorker(t *testing.T) {
+	event := file.ChangeEvent{DocumentKey: bson.D{{Key: "_id", Value: "a"}, {Key: "region", Value: "eu"}}}
+	first, err := eventWorker(event, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Shard key fields do not change the worker of a document
+	event.DocumentKey = bson.D{{Key: "_id", Value: "a"}, {Key: "region", Value: "us"}}
+	second, _ := eventWorker(event, 8)
+	if first != second || first < 0 || first >= 8 {
+		t.Errorf("Expected events of a document on the same worker, got %d and %d", first, second)
+	}
+}
+
+func TestChangeResultNotApplied(t *testing.T) {
+	result := &changeResult{}
+	for _, token := range []string{"8265A3", "8265A1", "8265A2"} {
+		result.notApplied(token)
+	}
+	if result.unapplied != "8265A1" {
+		t.Errorf("Expected the smallest token not applied, got %s", result.unapplied)
+	}
+}
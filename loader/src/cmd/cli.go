@@ -21,6 +21,12 @@ var (
 	collectionName     string
 	numConcurrentFiles int32
 	logLevel           string
+	archivePath        string
+	archiveCollection  string
+	archiveDb          string
+	insertBatchSize    int32
+	manifestPath       string
+	sourceCollection   string
 )
 
 // Root Command (does nothing, only prints nice things)
@@ -52,6 +58,14 @@ var loadBatchesCmd = &cobra.Command{
 	Run:     InsertBatches,
 }
 
+// Apply Changes Command
+var applyChangesCmd = &cobra.Command{
+	Use:     "apply-changes",
+	Version: rootCmd.Version,
+	Short:   "Replays change event files (written by extractor watch) into a mongodb collection",
+	Run:     ApplyChanges,
+}
+
 // Ping Command
 var pingCmd = &cobra.Command{
 	Use:     "ping",
@@ -95,7 +109,22 @@ func init() {
 	loadBatchesCmd.PersistentFlags().StringVarP(&searchPath, "search-path", "p", ".", "Search path to look for files")
 	loadBatchesCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "Specify the collection you want to check")
 	loadBatchesCmd.PersistentFlags().Int32VarP(&numConcurrentFiles, "num-concurrent-files", "n", 50, "Number of concurrent files to dump")
+	loadBatchesCmd.PersistentFlags().StringVar(&archivePath, "archive", "", "Restores from a mongodump archive at this path, gzipped or not (stdin when no path is given)")
+	loadBatchesCmd.PersistentFlags().Lookup("archive").NoOptDefVal = "-"
+	loadBatchesCmd.PersistentFlags().StringVar(&archiveCollection, "archive-collection", "", "Collection to restore from archive (defaults to --collection)")
+	loadBatchesCmd.PersistentFlags().StringVar(&archiveDb, "archive-db", "", "Database of the collection to restore from archive (required when several databases of the archive hold it)")
+	loadBatchesCmd.PersistentFlags().Int32Var(&insertBatchSize, "batch-size", 1000, "Number of documents per insert when restoring an archive")
+	loadBatchesCmd.PersistentFlags().StringVar(&manifestPath, "manifest", "", "Loads the chunks listed in an extraction manifest (manifest.json or its folder)")
+	loadBatchesCmd.MarkFlagsMutuallyExclusive("manifest", "archive")
 	loadBatchesCmd.MarkFlagRequired("collection")
+	// Apply changes command flags setup
+	applyChangesCmd.PersistentFlags().StringVarP(&filePrefix, "file-prefix", "o", "", "Filename prefix")
+	applyChangesCmd.PersistentFlags().StringVarP(&searchPath, "search-path", "p", ".", "Search path to look for change files")
+	applyChangesCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "Collection changes are applied to")
+	applyChangesCmd.PersistentFlags().StringVar(&sourceCollection, "source-collection", "", "Only applies events of this collection (events of every collection are applied when empty)")
+	applyChangesCmd.PersistentFlags().Int32VarP(&numConcurrentFiles, "num-concurrent-files", "n", 8, "Number of concurrent workers, events of a document are always applied by the same worker")
+	applyChangesCmd.PersistentFlags().Int32Var(&insertBatchSize, "batch-size", 1000, "Number of operations per bulk write")
+	applyChangesCmd.MarkFlagRequired("collection")
 	// Collection exists command flags setup
 	collExistsCmd.PersistentFlags().StringVar(&collectionName, "collection", "", "Specify the collection you want to check")
 	collExistsCmd.MarkFlagRequired("collection")
@@ -103,6 +132,7 @@ func init() {
 	// Attaching commands to root
 	rootCmd.AddCommand(loadCmd)
 	rootCmd.AddCommand(loadBatchesCmd)
+	rootCmd.AddCommand(applyChangesCmd)
 	rootCmd.AddCommand(pingCmd)
 	rootCmd.AddCommand(collExistsCmd)
 }
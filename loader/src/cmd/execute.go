@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"sync"
 
@@ -50,7 +53,7 @@ func LoadFile(cmd *cobra.Command, args []string) {
 	opts := options.InsertManyOptions{}
 
 	logger.InfoLogger.Println("Processing record")
-	if err := handler.InsertFromFiles(filePrefix, searchPath, mongo.ReadFiles, coll, &opts); err != nil {
+	if err := handler.InsertFromFiles(filePrefix, searchPath, file.ReadFiles, coll, &opts); err != nil {
 		logger.ErrorLogger.Fatalln(err)
 	}
 }
@@ -69,16 +72,42 @@ func InsertBatches(cmd *cobra.Command, args []string) {
 
 	coll := handler.GetCollection(collectionName)
 
+	if manifestPath != "" {
+		logger.InfoLogger.Println("Loading manifest chunks")
+		if err := loadManifest(coll); err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		logger.InfoLogger.Println("Ending Insert Batches")
+		return
+	}
+
+	if archivePath != "" {
+		logger.InfoLogger.Println("Restoring archive")
+		if err := restoreArchive(handler, coll); err != nil {
+			logger.ErrorLogger.Fatalln(err)
+		}
+		logger.InfoLogger.Println("Ending Insert Batches")
+		return
+	}
+
 	logger.InfoLogger.Println("Processing files")
 
-	if err := handler.ConcurrentBatchInsert(filePrefix, searchPath, numConcurrentFiles, insertArray, coll); err != nil {
+	failed := &insertErrors{}
+	insert := func(ctx context.Context, files <-chan string, wg *sync.WaitGroup, coll *mongodb.Collection) {
+		insertArray(ctx, files, wg, coll, failed)
+	}
+	if err := handler.ConcurrentBatchInsert(filePrefix, searchPath, numConcurrentFiles, insert, coll); err != nil {
 		logger.ErrorLogger.Fatalln(err)
 	}
+	if failed.count > 0 {
+		logger.ErrorLogger.Fatalf("%d files failed to load, first error: %s", failed.count, failed.first)
+	}
 	logger.InfoLogger.Println("Ending Insert Batches")
 }
 
-// This function retrieves the files from channel, reads, serialize it and load into a collection
-func insertArray(ctx context.Context, files <-chan string, wg *sync.WaitGroup, coll *mongodb.Collection) {
+// This function retrieves the files from channel, reads, serialize it and load into a collection.
+// A file that cannot be read or inserted is recorded and skipped.
+func insertArray(ctx context.Context, files <-chan string, wg *sync.WaitGroup, coll *mongodb.Collection, failed *insertErrors) {
 	defer wg.Done()
 
 	for filePath := range files {
@@ -87,16 +116,238 @@ func insertArray(ctx context.Context, files <-chan string, wg *sync.WaitGroup, c
 
 		if err != nil {
 			logger.ErrorLogger.Println(err)
+			failed.add(fmt.Errorf("%s: %w", filePath, err))
+			continue
+		}
+		if len(data) == 0 {
+			continue
 		}
 		results, err := coll.InsertMany(ctx, data, nil)
 		if err != nil {
 			logger.ErrorLogger.Println(err)
+			failed.add(fmt.Errorf("%s: %w", filePath, err))
+			continue
+		}
+
+		logger.DebugLogger.Printf("Inserted %d documents\n", len(results.InsertedIDs))
+	}
+}
+
+// Restores a collection from a mongodump archive: indexes found in archive prelude are created,
+// documents are sent in batches to a pool of workers that insert them
+func restoreArchive(handler *mongo.ConnectionHandler, coll *mongodb.Collection) error {
+	archive, err := file.OpenArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	source := archiveCollection
+	if source == "" {
+		source = collectionName
+	}
+	logger.InfoLogger.Printf("Archive written by %s (server %s)\n", archive.Header.ToolVersion, archive.Header.ServerVersion)
+
+	database, err := archiveDatabase(archive.Collections, archiveDb, source)
+	if err != nil {
+		return err
+	}
+	metadata, _, err := archive.Metadata(database, source)
+	if err != nil {
+		return err
+	}
+	if err = handler.CreateIndexes(coll, metadata.Indexes); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	failed := &insertErrors{}
+	pipe := make(chan []any, numConcurrentFiles)
+	var wg sync.WaitGroup
+	for i := int32(0); i < numConcurrentFiles; i++ {
+		wg.Add(1)
+		go insertDocuments(ctx, pipe, &wg, coll, failed)
+	}
+
+	var counter int
+	batch := make([]any, 0, insertBatchSize)
+	for {
+		db, collection, doc, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			close(pipe)
+			wg.Wait()
+			return err
+		}
+		if db != database || collection != source {
+			continue
+		}
+
+		batch = append(batch, doc)
+		counter++
+		if int32(len(batch)) >= insertBatchSize {
+			pipe <- batch
+			batch = make([]any, 0, insertBatchSize)
 		}
+	}
+	if len(batch) > 0 {
+		pipe <- batch
+	}
+
+	close(pipe)
+	wg.Wait()
+
+	logger.InfoLogger.Printf("%d documents read from archive\n", counter)
+	if failed.count > 0 {
+		return fmt.Errorf("%d batches failed to insert, first error: %w", failed.count, failed.first)
+	}
+	return nil
+}
+
+// Database of the archive holding collection: --archive-db when set, otherwise the only one holding it
+func archiveDatabase(collections []file.ArchiveCollection, database string, collection string) (string, error) {
+	var found []string
+	for _, item := range collections {
+		if item.Collection == collection && (database == "" || item.Database == database) {
+			found = append(found, item.Database)
+		}
+	}
+	switch {
+	case len(found) == 0 && database != "":
+		return "", fmt.Errorf("collection %s.%s not found in archive", database, collection)
+	case len(found) == 0:
+		return "", fmt.Errorf("collection %s not found in archive", collection)
+	case len(found) > 1:
+		return "", fmt.Errorf("collection %s found in databases %v of archive, set --archive-db", collection, found)
+	}
+	return found[0], nil
+}
+
+// Insert failures of workers (files or archive batches), the first one is returned once every worker is done
+type insertErrors struct {
+	mu    sync.Mutex
+	count int
+	first error
+}
+
+func (e *insertErrors) add(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.first == nil {
+		e.first = err
+	}
+	e.count++
+}
+
+// This function retrieves batches of documents from channel and inserts them into a collection
+func insertDocuments(ctx context.Context, batches <-chan []any, wg *sync.WaitGroup, coll *mongodb.Collection, failed *insertErrors) {
+	defer wg.Done()
 
+	for documents := range batches {
+		results, err := coll.InsertMany(ctx, documents, nil)
+		if err != nil {
+			logger.ErrorLogger.Println(err)
+			failed.add(err)
+			continue
+		}
 		logger.DebugLogger.Printf("Inserted %d documents\n", len(results.InsertedIDs))
 	}
 }
 
+// Totals of a manifest load, compared against manifest totals
+type reconciliation struct {
+	mu        sync.Mutex
+	chunks    int
+	documents int64
+	inserted  int64
+	failures  []string
+}
+
+func (r *reconciliation) loaded(chunk file.ManifestChunk, inserted int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inserted += inserted
+	if err != nil {
+		r.failures = append(r.failures, fmt.Sprintf("chunk %d (%s): %s", chunk.ChunkId, chunk.File, err))
+		return
+	}
+	r.chunks++
+	r.documents += chunk.Documents
+}
+
+// Loads exactly the chunks listed in an extraction manifest: every file is verified
+// (size, checksum and document count) before its documents are inserted
+func loadManifest(coll *mongodb.Collection) error {
+	manifest, err := file.ReadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	logger.InfoLogger.Printf("Manifest of run %s: %d chunks, %d documents (%s.%s, %s)\n",
+		manifest.RunId, manifest.TotalChunks, manifest.TotalDocuments, manifest.Database, manifest.Collection, manifest.Format)
+
+	ctx := context.Background()
+	result := &reconciliation{}
+	pipe := make(chan file.ManifestChunk, numConcurrentFiles)
+	var wg sync.WaitGroup
+	for i := int32(0); i < numConcurrentFiles; i++ {
+		wg.Add(1)
+		go insertChunks(ctx, pipe, &wg, coll, manifest, result)
+	}
+	for _, chunk := range manifest.Chunks {
+		pipe <- chunk
+	}
+	close(pipe)
+	wg.Wait()
+
+	logger.InfoLogger.Printf("Reconciliation: chunks %d/%d, documents verified %d/%d, inserted %d/%d\n",
+		result.chunks, manifest.TotalChunks, result.documents, manifest.TotalDocuments, result.inserted, manifest.TotalDocuments)
+	for _, failure := range result.failures {
+		logger.ErrorLogger.Println(failure)
+	}
+
+	if len(result.failures) > 0 || result.chunks != manifest.TotalChunks ||
+		result.documents != manifest.TotalDocuments || result.inserted != manifest.TotalDocuments {
+		return fmt.Errorf("load does not match manifest of run %s (%d failed chunks)", manifest.RunId, len(result.failures))
+	}
+	return nil
+}
+
+// This function retrieves manifest chunks from channel, verifies and inserts them into a collection
+func insertChunks(ctx context.Context, chunks <-chan file.ManifestChunk, wg *sync.WaitGroup, coll *mongodb.Collection, manifest *file.Manifest, result *reconciliation) {
+	defer wg.Done()
+
+	for chunk := range chunks {
+		documents, err := manifest.ReadChunk(chunk)
+		if err != nil {
+			result.loaded(chunk, 0, err)
+			continue
+		}
+		if len(documents) == 0 {
+			result.loaded(chunk, 0, nil)
+			continue
+		}
+
+		inserted := int64(len(documents))
+		_, err = coll.InsertMany(ctx, documents, nil)
+		if err != nil {
+			inserted = insertedBeforeError(err)
+		}
+		result.loaded(chunk, inserted, err)
+		logger.DebugLogger.Printf("Chunk %d: inserted %d documents\n", chunk.ChunkId, inserted)
+	}
+}
+
+// Number of documents an ordered insert wrote before failing
+func insertedBeforeError(err error) int64 {
+	var bulkErr mongodb.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		return int64(bulkErr.WriteErrors[0].Index)
+	}
+	return 0
+}
+
 // Execution logic for collxst command
 func CollExistsExecute(cmd *cobra.Command, args []string) {
 	logger.Initialize(logLevel)
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"testing"
+
+	file "github.com/farovictor/MongoDbLoader/src/fs"
+)
+
+func TestArchiveDatabase(t *testing.T) {
+	collections := []file.ArchiveCollection{
+		{Database: "db1", Collection: "users"},
+		{Database: "db2", Collection: "users"},
+		{Database: "db2", Collection: "orders"},
+	}
+
+	if database, err := archiveDatabase(collections, "", "orders"); err != nil || database != "db2" {
+		t.Errorf("Expected orders of db2, got %q (%v)", database, err)
+	}
+	if database, err := archiveDatabase(collections, "db1", "users"); err != nil || database != "db1" {
+		t.Errorf("Expected users of db1, got %q (%v)", database, err)
+	}
+	if _, err := archiveDatabase(collections, "", "users"); err == nil {
+		t.Error("Expected users of several databases to need --archive-db")
+	}
+	if _, err := archiveDatabase(collections, "db1", "orders"); err == nil {
+		t.Error("Expected orders not to be found in db1")
+	}
+}
@@ -0,0 +1,238 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mongodump archive constants
+// Check mongo-tools implementation: https://github.com/mongodb/mongo-tools/tree/master/common/archive
+const archiveMagicNumber uint32 = 0x8199e26d
+
+// Marks the end of the prelude and of every namespace block
+var archiveTerminator = []byte{0xFF, 0xFF, 0xFF, 0xFF}
+
+// Archive level information, found right after the magic number
+type ArchiveHeader struct {
+	ConcurrentCollections int32  `bson:"concurrent_collections"`
+	FormatVersion         string `bson:"version"`
+	ServerVersion         string `bson:"server_version"`
+	ToolVersion           string `bson:"tool_version"`
+}
+
+// One per collection in archive prelude, metadata holds <collection>.metadata.json content
+type ArchiveCollection struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Metadata   string `bson:"metadata"`
+	Size       int    `bson:"size"`
+	Type       string `bson:"type"`
+}
+
+// Announces the namespace of the following documents, or its end (EOF) with a checksum
+type archiveNamespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// Reads archives written by mongodump --archive (or extractor --archive), gzipped or not
+type ArchiveReader struct {
+	Header      ArchiveHeader
+	Collections []ArchiveCollection
+
+	reader  *bufio.Reader
+	closers []io.Closer
+
+	// Namespace of the block being read (nil between blocks)
+	current *archiveNamespaceHeader
+	hashes  map[string]hash.Hash64
+}
+
+// Opens an archive and reads its prelude. Path "-" reads from stdin.
+// Compression (gzip, zstd or snappy) is detected from the stream magic bytes.
+func OpenArchive(path string) (*ArchiveReader, error) {
+	a := &ArchiveReader{hashes: map[string]hash.Hash64{}}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		a.closers = append(a.closers, file)
+		in = file
+	}
+
+	decompressed, err := decompress(bufio.NewReader(in), "")
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.closers = append([]io.Closer{decompressed}, a.closers...)
+	a.reader = bufio.NewReader(decompressed)
+
+	if err := a.readPrelude(); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Magic number, header, collection metadata and terminator
+func (a *ArchiveReader) readPrelude() error {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(a.reader, magic); err != nil {
+		return fmt.Errorf("reading archive magic number: %w", err)
+	}
+	if binary.LittleEndian.Uint32(magic) != archiveMagicNumber {
+		return errors.New("stream is not a mongodump archive")
+	}
+
+	if err := a.readDocument(&a.Header); err != nil {
+		return fmt.Errorf("reading archive header: %w", err)
+	}
+
+	for {
+		end, err := a.atTerminator()
+		if err != nil {
+			return err
+		}
+		if end {
+			return nil
+		}
+		var collection ArchiveCollection
+		if err := a.readDocument(&collection); err != nil {
+			return fmt.Errorf("reading archive prelude: %w", err)
+		}
+		a.Collections = append(a.Collections, collection)
+	}
+}
+
+// Consumes the terminator if it is the next thing in the stream
+func (a *ArchiveReader) atTerminator() (bool, error) {
+	next, err := a.reader.Peek(4)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(next, archiveTerminator) {
+		return false, nil
+	}
+	_, err = a.reader.Discard(4)
+	return true, err
+}
+
+func (a *ArchiveReader) readDocument(value any) error {
+	doc, err := bson.ReadDocument(a.reader)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(doc, value)
+}
+
+// Returns the next document of the archive and its namespace, io.EOF at the end of the archive.
+// Checksums are verified at the end of every namespace.
+func (a *ArchiveReader) Next() (database string, collection string, doc bson.Raw, err error) {
+	for {
+		if a.current == nil {
+			// Between blocks: a namespace header is expected
+			if _, err = a.reader.Peek(1); err == io.EOF {
+				return "", "", nil, io.EOF
+			}
+			header := archiveNamespaceHeader{}
+			if err = a.readDocument(&header); err != nil {
+				return "", "", nil, fmt.Errorf("reading namespace header: %w", err)
+			}
+
+			if header.EOF {
+				if err = a.verify(header); err != nil {
+					return "", "", nil, err
+				}
+				if _, err = a.atTerminator(); err != nil {
+					return "", "", nil, err
+				}
+				continue
+			}
+			a.current = &header
+			continue
+		}
+
+		end, err := a.atTerminator()
+		if err != nil {
+			return "", "", nil, err
+		}
+		if end {
+			a.current = nil
+			continue
+		}
+
+		if doc, err = bson.ReadDocument(a.reader); err != nil {
+			return "", "", nil, fmt.Errorf("reading %s.%s document: %w", a.current.Database, a.current.Collection, err)
+		}
+		a.hash(a.current.Database, a.current.Collection).Write(doc)
+		return a.current.Database, a.current.Collection, doc, nil
+	}
+}
+
+func (a *ArchiveReader) hash(database, collection string) hash.Hash64 {
+	ns := database + "." + collection
+	h, ok := a.hashes[ns]
+	if !ok {
+		h = crc64.New(crc64.MakeTable(crc64.ECMA))
+		a.hashes[ns] = h
+	}
+	return h
+}
+
+// Compares the checksum of every document read for a namespace against the one found in its EOF header
+func (a *ArchiveReader) verify(header archiveNamespaceHeader) error {
+	if header.CRC == 0 {
+		return nil
+	}
+	if got := int64(a.hash(header.Database, header.Collection).Sum64()); got != header.CRC {
+		return fmt.Errorf("checksum mismatch for %s.%s: expected %d, got %d", header.Database, header.Collection, header.CRC, got)
+	}
+	return nil
+}
+
+// Returns the metadata of a collection found in archive prelude
+func (a *ArchiveReader) Metadata(database, collection string) (ArchiveMetadata, bool, error) {
+	for _, item := range a.Collections {
+		if item.Collection == collection && (database == "" || item.Database == database) {
+			metadata := ArchiveMetadata{}
+			if item.Metadata == "" {
+				return metadata, true, nil
+			}
+			err := bson.UnmarshalExtJSON([]byte(item.Metadata), false, &metadata)
+			return metadata, true, err
+		}
+	}
+	return ArchiveMetadata{}, false, nil
+}
+
+// Closes decompressed stream and file (stdin is never closed)
+func (a *ArchiveReader) Close() error {
+	var err error
+	for _, closer := range a.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	a.closers = nil
+	return err
+}
+
+// Collection metadata, as written in <collection>.metadata.json (only indexes are used)
+type ArchiveMetadata struct {
+	Indexes []bson.D `bson:"indexes"`
+}
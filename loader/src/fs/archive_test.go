@@ -0,0 +1,143 @@
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func marshal(t testing.TB, value any) []byte {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Builds an archive the same way mongodump does: prelude, then namespace blocks and EOF headers
+func buildArchive(t testing.TB, crcOffset int64) []byte {
+	var buf bytes.Buffer
+	magic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magic, archiveMagicNumber)
+	buf.Write(magic)
+	buf.Write(marshal(t, ArchiveHeader{ConcurrentCollections: 1, FormatVersion: "0.1", ServerVersion: "6.0.0", ToolVersion: "100.7.0"}))
+	for _, coll := range []string{"records", "others"} {
+		buf.Write(marshal(t, ArchiveCollection{Database: "db", Collection: coll, Metadata: `{"indexes":[{"v":{"$numberInt":"2"},"key":{"_id":{"$numberInt":"1"}},"name":"_id_"}]}`, Type: "collection"}))
+	}
+	buf.Write(archiveTerminator)
+
+	crcs := map[string]uint64{}
+	block := func(coll string, words ...string) {
+		h := crc64.New(crc64.MakeTable(crc64.ECMA))
+		buf.Write(marshal(t, archiveNamespaceHeader{Database: "db", Collection: coll}))
+		for _, word := range words {
+			doc := marshal(t, bson.D{{Key: "word", Value: word}})
+			h.Write(doc)
+			buf.Write(doc)
+		}
+		buf.Write(archiveTerminator)
+		crcs[coll] = h.Sum64()
+	}
+	// Interleaved blocks, as written when collections are dumped concurrently
+	block("records", "quaerat", "minima")
+	block("others", "ignored")
+
+	for _, coll := range []string{"records", "others"} {
+		buf.Write(marshal(t, archiveNamespaceHeader{Database: "db", Collection: coll, EOF: true, CRC: int64(crcs[coll]) + crcOffset}))
+		buf.Write(archiveTerminator)
+	}
+	return buf.Bytes()
+}
+
+func readAll(t testing.TB, path string) (map[string]int, error) {
+	archive, err := OpenArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	counts := map[string]int{}
+	for {
+		_, coll, _, err := archive.Next()
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return counts, err
+		}
+		counts[coll]++
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	tempDir := t.TempDir()
+	data := buildArchive(t, 0)
+
+	var gz bytes.Buffer
+	writer := gzip.NewWriter(&gz)
+	writer.Write(data)
+	writer.Close()
+
+	for name, content := range map[string][]byte{"plain.archive": data, "gzipped.archive.gz": gz.Bytes()} {
+		path := filepath.Join(tempDir, name)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		counts, err := readAll(t, path)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if counts["records"] != 2 || counts["others"] != 1 {
+			t.Errorf("%s: unexpected documents count %v", name, counts)
+		}
+	}
+}
+
+func TestArchivePrelude(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.archive")
+	if err := os.WriteFile(path, buildArchive(t, 0), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := OpenArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	if archive.Header.ToolVersion != "100.7.0" || len(archive.Collections) != 2 {
+		t.Errorf("Unexpected prelude: %+v %+v", archive.Header, archive.Collections)
+	}
+	metadata, found, err := archive.Metadata("", "records")
+	if err != nil || !found {
+		t.Fatal("Metadata not found", err)
+	}
+	if len(metadata.Indexes) != 1 {
+		t.Errorf("Expected 1 index, got %v", metadata.Indexes)
+	}
+}
+
+func TestArchiveChecksumMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.archive")
+	if err := os.WriteFile(path, buildArchive(t, 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readAll(t, path); err == nil {
+		t.Error("Expected a checksum error")
+	}
+}
+
+func TestNotAnArchive(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "test.json", arrayContent)
+	if _, err := OpenArchive(path); err == nil {
+		t.Error("Expected an error for a non archive file")
+	}
+}
@@ -0,0 +1,181 @@
+package fs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	mongo "github.com/farovictor/MongodbDriver"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Change event as written by extractor watch command
+type ChangeEvent struct {
+	Id                ResumeToken        `bson:"_id"`
+	OperationType     string             `bson:"operationType"`
+	Namespace         Namespace          `bson:"ns"`
+	DocumentKey       bson.D             `bson:"documentKey"`
+	FullDocument      bson.D             `bson:"fullDocument"`
+	UpdateDescription *UpdateDescription `bson:"updateDescription"`
+}
+
+// Resume token of an event. Tokens of a stream are hex strings sorting in stream order.
+type ResumeToken struct {
+	Data string `bson:"_data"`
+}
+
+// Database and collection an event happened in
+type Namespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"coll"`
+}
+
+// Fields changed by an update event
+type UpdateDescription struct {
+	UpdatedFields   bson.D           `bson:"updatedFields"`
+	RemovedFields   []string         `bson:"removedFields"`
+	TruncatedArrays []TruncatedArray `bson:"truncatedArrays"`
+}
+
+// Array shortened by an update event
+type TruncatedArray struct {
+	Field   string `bson:"field"`
+	NewSize int64  `bson:"newSize"`
+}
+
+// Change event file, along with the resume token of its first event
+type ChangeFile struct {
+	Path       string
+	FirstToken string
+	Events     int
+}
+
+// Reads the change events of a file (json, ndjson or bson, compressed or not)
+func ReadChangeEvents(filePath string) ([]ChangeEvent, error) {
+	documents, err := ReadFileToArray(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]ChangeEvent, len(documents))
+	for i, doc := range documents {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if err = bson.Unmarshal(data, &events[i]); err != nil {
+			return nil, fmt.Errorf("invalid change event %d in %s: %w", i, filePath, err)
+		}
+		if events[i].Id.Data == "" || events[i].OperationType == "" {
+			return nil, fmt.Errorf("invalid change event %d in %s: no resume token or operation type", i, filePath)
+		}
+	}
+	return events, nil
+}
+
+// Checks a json or ndjson change file is canonical extended json: relaxed json reads int64 fitting in 32 bits
+// back as int32 and plain json writes ObjectIds as strings, so replayed document keys would not match.
+// Bare json numbers (other than timestamp parts) and dates written as strings are only found in those formats.
+func checkCanonical(filePath string) error {
+	codec, name := splitCompression(filePath)
+	if strings.ToLower(filepath.Ext(name)) == ".bson" {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := decompress(bufio.NewReader(file), codec)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	for {
+		var value any
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid change file %s: %w", filePath, err)
+		}
+		if !isCanonical(value) {
+			return fmt.Errorf("change file %s is not canonical extended json (write it with watch --output-format ndjson-canonical), bson types would be lost", filePath)
+		}
+	}
+}
+
+func isCanonical(value any) bool {
+	switch v := value.(type) {
+	case json.Number:
+		return false
+	case []any:
+		for _, item := range v {
+			if !isCanonical(item) {
+				return false
+			}
+		}
+	case map[string]any:
+		if _, found := v["$timestamp"]; found {
+			return true
+		}
+		if date, found := v["$date"]; found {
+			if _, relaxed := date.(string); relaxed {
+				return false
+			}
+		}
+		for _, item := range v {
+			if !isCanonical(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Lists change event files matching prefix inside folder, in stream order (token of their first event).
+// Every file is read once to find its first event, empty files are left out. Files that are not canonical
+// extended json (nor bson) are refused.
+func ListChangeFiles(filePrefix string, folder string) ([]ChangeFile, error) {
+	var changeFiles []ChangeFile
+
+	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !strings.HasPrefix(info.Name(), filePrefix) || mongo.IsMetadataFile(info.Name()) {
+			return nil
+		}
+		if info.Name() == ManifestFile || info.Name() == SuccessMarker {
+			return nil
+		}
+
+		if err := checkCanonical(path); err != nil {
+			return err
+		}
+		events, err := ReadChangeEvents(path)
+		if err != nil {
+			return err
+		}
+		if len(events) > 0 {
+			changeFiles = append(changeFiles, ChangeFile{Path: path, FirstToken: events[0].Id.Data, Events: len(events)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(changeFiles, func(i, j int) bool { return changeFiles[i].FirstToken < changeFiles[j].FirstToken })
+	return changeFiles, nil
+}
@@ -0,0 +1,78 @@
+package fs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Events as written by extractor watch command (ndjson, canonical extended json)
+const (
+	changesA = `{"_id":{"_data":"8265A1000000000001"},"operationType":"insert","ns":{"db":"crm","coll":"users"},"documentKey":{"_id":{"$oid":"65063a2c27b6b3d5da64db70"}},"fullDocument":{"_id":{"$oid":"65063a2c27b6b3d5da64db70"},"name":"Ana","tags":["a","b","c"]}}
+{"_id":{"_data":"8265A1000000000002"},"operationType":"update","ns":{"db":"crm","coll":"users"},"documentKey":{"_id":{"$oid":"65063a2c27b6b3d5da64db70"}},"updateDescription":{"updatedFields":{"name":"Ana Maria"},"removedFields":["age"],"truncatedArrays":[{"field":"tags","newSize":{"$numberInt":"1"}}]}}
+`
+	changesB = `{"_id":{"_data":"8265A1000000000003"},"operationType":"delete","ns":{"db":"crm","coll":"users"},"documentKey":{"_id":{"$oid":"65063a2c27b6b3d5da64db70"}}}
+`
+)
+
+func TestListChangeFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	// Run ids are random, names do not follow stream order
+	writeFile(t, tempDir, "users_b1_1.ndjson", changesA)
+	writeFile(t, tempDir, "users_a2_1.ndjson.gz", compress(t, compressGzip, changesB))
+	writeFile(t, tempDir, "users_a2_2.ndjson", "")
+	writeFile(t, tempDir, ManifestFile, "{}")
+
+	changeFiles, err := ListChangeFiles("users", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changeFiles) != 2 {
+		t.Fatalf("Expected 2 change files, got %+v", changeFiles)
+	}
+	if filepath.Base(changeFiles[0].Path) != "users_b1_1.ndjson" || changeFiles[0].Events != 2 {
+		t.Errorf("Expected files in stream order, got %+v", changeFiles)
+	}
+
+	events, err := ReadChangeEvents(changeFiles[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := events[1]
+	if update.OperationType != "update" || update.Namespace.Collection != "users" || update.UpdateDescription == nil {
+		t.Fatalf("Unexpected update event: %+v", update)
+	}
+	description := update.UpdateDescription
+	if len(description.UpdatedFields) != 1 || len(description.RemovedFields) != 1 || description.TruncatedArrays[0].NewSize != 1 {
+		t.Errorf("Unexpected update description: %+v", description)
+	}
+	if events[0].FullDocument == nil || len(events[0].DocumentKey) != 1 {
+		t.Errorf("Unexpected insert event: %+v", events[0])
+	}
+}
+
+func TestReadChangeEventsInvalid(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "users.ndjson", `{"_id":1,"name":"not an event"}`)
+	if _, err := ReadChangeEvents(path); err == nil {
+		t.Error("Expected documents without resume token to be rejected")
+	}
+}
+
+func TestListChangeFilesNotCanonical(t *testing.T) {
+	for name, content := range map[string]string{
+		"relaxed number": `{"_id":{"_data":"82"},"operationType":"insert","documentKey":{"_id":7},"fullDocument":{"_id":7}}`,
+		"relaxed date":   `{"_id":{"_data":"82"},"operationType":"delete","documentKey":{"_id":{"$oid":"65063a2c27b6b3d5da64db70"}},"wallTime":{"$date":"2023-09-16T00:00:00Z"}}`,
+	} {
+		tempDir := t.TempDir()
+		writeFile(t, tempDir, "users_1.ndjson", content)
+		if _, err := ListChangeFiles("users", tempDir); err == nil {
+			t.Errorf("%s: expected file to be refused", name)
+		}
+	}
+
+	// Timestamps hold bare numbers in canonical json too
+	tempDir := t.TempDir()
+	writeFile(t, tempDir, "users_1.json", `[{"_id":{"_data":"82"},"operationType":"delete","documentKey":{"_id":{"$oid":"65063a2c27b6b3d5da64db70"}},"clusterTime":{"$timestamp":{"t":1695000000,"i":1}}}]`)
+	if changeFiles, err := ListChangeFiles("users", tempDir); err != nil || len(changeFiles) != 1 {
+		t.Errorf("Expected canonical json array to be accepted: %v", err)
+	}
+}
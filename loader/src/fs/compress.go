@@ -0,0 +1,77 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs written by extractor --compress
+const (
+	compressGzip   = "gzip"
+	compressZstd   = "zstd"
+	compressSnappy = "snappy"
+)
+
+// Magic bytes found at the beginning of compressed streams
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// File extensions of compressed files
+var compressionExtensions = map[string]string{
+	".gz":     compressGzip,
+	".zst":    compressZstd,
+	".sz":     compressSnappy,
+	".snappy": compressSnappy,
+}
+
+// Returns the codec matching file extension and file path without that extension
+// (e.g.: users.ndjson.zst gives zstd and users.ndjson)
+func splitCompression(filePath string) (string, string) {
+	ext := filepath.Ext(filePath)
+	if codec, ok := compressionExtensions[strings.ToLower(ext)]; ok {
+		return codec, strings.TrimSuffix(filePath, ext)
+	}
+	return "", filePath
+}
+
+// Returns the codec of a stream from its first bytes (empty when not compressed)
+func detectCompression(reader *bufio.Reader) string {
+	for codec, magic := range map[string][]byte{compressGzip: gzipMagic, compressZstd: zstdMagic, compressSnappy: snappyMagic} {
+		if head, err := reader.Peek(len(magic)); err == nil && bytes.Equal(head, magic) {
+			return codec
+		}
+	}
+	return ""
+}
+
+// Wraps reader with a decompressor. Codec is detected from magic bytes when empty,
+// the reader is returned as is when stream is not compressed.
+func decompress(reader *bufio.Reader, codec string) (io.ReadCloser, error) {
+	if codec == "" {
+		codec = detectCompression(reader)
+	}
+
+	switch codec {
+	case compressGzip:
+		return gzip.NewReader(reader)
+	case compressZstd:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case compressSnappy:
+		return io.NopCloser(snappy.NewReader(reader)), nil
+	}
+	return io.NopCloser(reader), nil
+}
@@ -0,0 +1,77 @@
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	mongo "github.com/farovictor/MongodbDriver"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+func compress(t testing.TB, codec, content string) string {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch codec {
+	case compressGzip:
+		writer = gzip.NewWriter(&buf)
+	case compressZstd:
+		encoder, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer = encoder
+	case compressSnappy:
+		writer = snappy.NewBufferedWriter(&buf)
+	}
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestReadCompressedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cases := map[string]string{
+		"test_array.json.gz":     compress(t, compressGzip, arrayContent),
+		"test_lines.ndjson.zst":  compress(t, compressZstd, ndjsonContent),
+		"test_lines.jsonl.sz":    compress(t, compressSnappy, ndjsonContent),
+		"test_detected.json":     compress(t, compressZstd, arrayContent),
+		"test_detected.ndjson":   compress(t, compressGzip, ndjsonContent),
+		"test_array.json.snappy": compress(t, compressSnappy, arrayContent),
+		"test_empty.ndjson.gz":   compress(t, compressGzip, ""),
+		"test.metadata.json.zst": compress(t, compressZstd, `{"indexes":[]}`),
+	}
+	for name, content := range cases {
+		path := writeFile(t, tempDir, name, content)
+		if mongo.IsMetadataFile(name) {
+			continue
+		}
+		docs, err := ReadFileToArray(path)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		expected := 2
+		if name == "test_empty.ndjson.gz" {
+			expected = 0
+		}
+		if len(docs) != expected {
+			t.Errorf("%s: expected %d documents, got %d", name, expected, len(docs))
+		}
+	}
+
+	// Metadata files are skipped, compressed or not
+	docs, err := ReadFiles("test", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 12 {
+		t.Errorf("Expected 12 documents, got %d", len(docs))
+	}
+}
@@ -1,17 +1,30 @@
 package fs
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 
 	logger "github.com/farovictor/MongoDbLoader/src/logging"
+	mongo "github.com/farovictor/MongodbDriver"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
-// Reads file and returns a bson.M array
+// Max size of a single line in a ndjson file
+const maxLineSize = 16 * 1024 * 1024
+
+// Reads file and returns a bson.D array
+// Accepts plain json as well as MongoDB Extended JSON (canonical or relaxed), so bson types
+// written by extractor (ObjectId, dates, decimals, binaries...) are restored as they were.
+// Files ending with .ndjson or .jsonl are read as one document per line, .bson files as raw bson
+// documents (mongodump layout) and any other file as a json array.
+// Compressed files (gzip, zstd or snappy) are decompressed based on their extension (e.g.: .json.gz,
+// .ndjson.zst) or their magic bytes.
 func ReadFileToArray(filePath string) ([]any, error) {
 
 	file, err := os.Open(filePath)
@@ -22,32 +35,131 @@ func ReadFileToArray(filePath string) ([]any, error) {
 
 	defer file.Close()
 
-	data, err := ioutil.ReadAll(file)
-
-	var jsonArray []map[string]any
+	codec, filePath := splitCompression(filePath)
+	reader, err := decompress(bufio.NewReader(file), codec)
+	if err != nil {
+		logger.ErrorLogger.Println(err)
+		return nil, err
+	}
+	defer reader.Close()
 
-	err = json.Unmarshal(data, &jsonArray)
+	var documents []any
+	if isNdjson(filePath) {
+		documents, err = readNdjson(reader)
+	} else if strings.ToLower(filepath.Ext(filePath)) == ".bson" {
+		documents, err = readBson(reader)
+	} else {
+		documents, err = readJsonArray(reader)
+	}
 	if err != nil {
 		logger.ErrorLogger.Println(err)
 		return nil, err
 	}
+	return documents, nil
+}
+
+// Checks if file holds one document per line
+func isNdjson(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return ext == ".ndjson" || ext == ".jsonl"
+}
+
+// Reads a json array of documents
+func readJsonArray(r io.Reader) ([]any, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
 
-	// This is the same as: []*bson.M
+	// Documents are kept raw so they can be parsed as extended json
+	var jsonArray []json.RawMessage
+
+	if err = json.Unmarshal(data, &jsonArray); err != nil {
+		return nil, err
+	}
+
+	// bson.D keeps fields order
 	documents := make([]any, len(jsonArray))
 
 	for i, jsonObj := range jsonArray {
-		bsonMap, err := bson.Marshal(jsonObj)
+		doc := bson.D{}
+		if err = bson.UnmarshalExtJSON(jsonObj, false, &doc); err != nil {
+			return nil, err
+		}
+		documents[i] = doc
+	}
+	return documents, nil
+}
+
+// Reads newline delimited documents, blank lines are skipped
+func readNdjson(r io.Reader) ([]any, error) {
+	var documents []any
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		doc := bson.D{}
+		if err := bson.UnmarshalExtJSON(line, false, &doc); err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+// Reads concatenated raw bson documents
+func readBson(r io.Reader) ([]any, error) {
+	var documents []any
+
+	reader := bufio.NewReader(r)
+	for {
+		doc, err := bson.ReadDocument(reader)
+		if err == io.EOF {
+			return documents, nil
+		}
 		if err != nil {
-			logger.ErrorLogger.Println(err)
 			return nil, err
 		}
-		bsonM := bson.M{}
-		err = bson.Unmarshal(bsonMap, &bsonM)
+		documents = append(documents, doc)
+	}
+}
+
+// Reads every file matching prefix inside folder and returns all documents
+func ReadFiles(filePrefix string, folder string) ([]any, error) {
+	var documents []any
+
+	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			logger.ErrorLogger.Println(err)
-			return nil, err
+			return err
+		}
+
+		// To check if file does not have a regular mode
+		if !info.Mode().IsRegular() {
+			return nil
 		}
-		documents[i] = &bsonM
+
+		// Read only files that match prefix
+		if strings.HasPrefix(info.Name(), filePrefix) && !mongo.IsMetadataFile(info.Name()) {
+			data, err := ReadFileToArray(path)
+			if err != nil {
+				return err
+			}
+			documents = append(documents, data...)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 	return documents, nil
 }
@@ -0,0 +1,109 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	arrayContent  = `[{"_id":{"$oid":"65063a2c27b6b3d5da64db70"},"created":{"$date":{"$numberLong":"1694858400000"}},"word":"quaerat"},{"_id":{"$oid":"65063a2c27b6b3d5da64db75"},"word":"minima"}]`
+	ndjsonContent = "{\"_id\":{\"$oid\":\"65063a2c27b6b3d5da64db70\"},\"word\":\"quaerat\"}\n\n{\"_id\":{\"$oid\":\"65063a2c27b6b3d5da64db75\"},\"word\":\"minima\"}\n"
+)
+
+func writeFile(t testing.TB, folder, name, content string) string {
+	path := filepath.Join(folder, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFileToArray(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cases := map[string]string{
+		"test_array.json":    arrayContent,
+		"test_lines.ndjson":  ndjsonContent,
+		"test_lines.jsonl":   ndjsonContent,
+		"test_legacy.json":   `[{"_id":"65063a2c27b6b3d5da64db70","latitude":79.68}]`,
+		"test_empty.ndjson":  "",
+		"test_empty.json":    "[]",
+		"test_relaxed.jsonl": `{"n":{"$numberLong":"1099511627776"}}`,
+	}
+	expected := map[string]int{
+		"test_array.json": 2, "test_lines.ndjson": 2, "test_lines.jsonl": 2,
+		"test_legacy.json": 1, "test_empty.ndjson": 0, "test_empty.json": 0, "test_relaxed.jsonl": 1,
+	}
+
+	for name, content := range cases {
+		path := writeFile(t, tempDir, name, content)
+		docs, err := ReadFileToArray(path)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(docs) != expected[name] {
+			t.Errorf("%s: expected %d documents, got %d", name, expected[name], len(docs))
+		}
+	}
+}
+
+func TestReadFileToArrayKeepsTypes(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "test.json", arrayContent)
+	docs, err := ReadFileToArray(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := docs[0].(bson.D).Map()
+	if _, ok := doc["_id"].(primitive.ObjectID); !ok {
+		t.Errorf("Expected ObjectID, got %T", doc["_id"])
+	}
+	if _, ok := doc["created"].(primitive.DateTime); !ok {
+		t.Errorf("Expected DateTime, got %T", doc["created"])
+	}
+}
+
+func TestReadFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	writeFile(t, tempDir, "test_a.json", arrayContent)
+	writeFile(t, tempDir, "test_b.ndjson", ndjsonContent)
+	writeFile(t, tempDir, "other.json", arrayContent)
+
+	docs, err := ReadFiles("test_", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 4 {
+		t.Errorf("Expected 4 documents, got %d", len(docs))
+	}
+}
+
+func TestReadBsonFile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	var content []byte
+	for _, word := range []string{"quaerat", "minima"} {
+		doc, err := bson.Marshal(bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "word", Value: word}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, doc...)
+	}
+	writeFile(t, tempDir, "records.bson", string(content))
+	writeFile(t, tempDir, "records.metadata.json", `{"indexes":[]}`)
+
+	docs, err := ReadFiles("records", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 {
+		t.Fatalf("Expected 2 documents, got %d", len(docs))
+	}
+	if word := docs[1].(bson.Raw).Lookup("word").StringValue(); word != "minima" {
+		t.Errorf("Expected minima, got %s", word)
+	}
+}
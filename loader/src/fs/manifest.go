@@ -0,0 +1,129 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File names written by extractor next to extracted files
+const (
+	ManifestFile  = "manifest.json"
+	SuccessMarker = "_SUCCESS"
+)
+
+// Formats written by extractor that can be loaded from a manifest
+var loadableFormats = map[string]bool{
+	"json":             true,
+	"canonical":        true,
+	"relaxed":          true,
+	"ndjson":           true,
+	"ndjson-canonical": true,
+	"bson":             true,
+}
+
+// Extraction manifest, as written by extractor extract-batch
+type Manifest struct {
+	RunId          string          `json:"run_id"`
+	Mapping        string          `json:"mapping"`
+	Database       string          `json:"database"`
+	Collection     string          `json:"collection"`
+	Query          string          `json:"query"`
+	Format         string          `json:"format"`
+	Compression    string          `json:"compression,omitempty"`
+	StartTime      time.Time       `json:"start_time"`
+	EndTime        time.Time       `json:"end_time"`
+	TotalDocuments int64           `json:"total_documents"`
+	TotalChunks    int             `json:"total_chunks"`
+	FailedChunks   int             `json:"failed_chunks"`
+	Error          string          `json:"error,omitempty"`
+	Chunks         []ManifestChunk `json:"chunks"`
+
+	// Folder holding manifest and chunk files
+	folder string
+}
+
+// A single file listed in a manifest
+type ManifestChunk struct {
+	ChunkId   int64  `json:"chunk_id"`
+	File      string `json:"file"`
+	Documents int64  `json:"documents"`
+	Bytes     int64  `json:"bytes"`
+	Sha256    string `json:"sha256"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Reads a manifest and checks the extraction it describes is complete:
+// _SUCCESS marker must be next to it, no chunk may have failed and format must be loadable.
+// Path may point to manifest.json or to the folder holding it.
+func ReadManifest(path string) (*Manifest, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, ManifestFile)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{folder: filepath.Dir(path)}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(manifest.folder, SuccessMarker)); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s marker not found in %s, extraction did not complete", SuccessMarker, manifest.folder)
+	} else if err != nil {
+		return nil, err
+	}
+	if manifest.Error != "" || manifest.FailedChunks > 0 {
+		return nil, fmt.Errorf("extraction %s failed (%d failed chunks): %s", manifest.RunId, manifest.FailedChunks, manifest.Error)
+	}
+	if !loadableFormats[manifest.Format] {
+		return nil, fmt.Errorf("chunks written as %s cannot be loaded", manifest.Format)
+	}
+	return manifest, nil
+}
+
+// Verifies size and checksum of a chunk file, then reads it and checks its document count
+func (m *Manifest) ReadChunk(chunk ManifestChunk) ([]any, error) {
+	filePath := filepath.Join(m.folder, chunk.File)
+	if err := verifyChecksum(filePath, chunk); err != nil {
+		return nil, err
+	}
+
+	documents, err := ReadFileToArray(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(documents)) != chunk.Documents {
+		return nil, fmt.Errorf("%s: expected %d documents, found %d", chunk.File, chunk.Documents, len(documents))
+	}
+	return documents, nil
+}
+
+func verifyChecksum(filePath string, chunk ManifestChunk) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return err
+	}
+	if size != chunk.Bytes {
+		return fmt.Errorf("%s: expected %d bytes, found %d", chunk.File, chunk.Bytes, size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != chunk.Sha256 {
+		return fmt.Errorf("%s: checksum mismatch, expected %s, got %s", chunk.File, chunk.Sha256, sum)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func manifestChunk(t testing.TB, folder string, id int64, name, content string, documents int64) ManifestChunk {
+	writeFile(t, folder, name, content)
+	sum := sha256.Sum256([]byte(content))
+	return ManifestChunk{ChunkId: id, File: name, Documents: documents, Bytes: int64(len(content)), Sha256: hex.EncodeToString(sum[:])}
+}
+
+func writeManifest(t testing.TB, folder string, manifest Manifest, success bool) string {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, folder, ManifestFile, string(data))
+	if success {
+		writeFile(t, folder, SuccessMarker, "")
+	}
+	return path
+}
+
+func TestReadManifestChunks(t *testing.T) {
+	tempDir := t.TempDir()
+	chunks := []ManifestChunk{
+		manifestChunk(t, tempDir, 1, "users_000001.ndjson", ndjsonContent, 2),
+		manifestChunk(t, tempDir, 2, "users_000002.ndjson.gz", compress(t, compressGzip, ndjsonContent), 2),
+	}
+	writeManifest(t, tempDir, Manifest{RunId: "run", Format: "ndjson", TotalChunks: 2, TotalDocuments: 4, Chunks: chunks}, true)
+
+	// Folder holding manifest.json is accepted too
+	manifest, err := ReadManifest(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range manifest.Chunks {
+		documents, err := manifest.ReadChunk(chunk)
+		if err != nil {
+			t.Fatalf("Chunk %d: %s", chunk.ChunkId, err)
+		}
+		if len(documents) != 2 {
+			t.Errorf("Chunk %d: expected 2 documents, got %d", chunk.ChunkId, len(documents))
+		}
+	}
+}
+
+func TestReadManifestChunkMismatch(t *testing.T) {
+	tempDir := t.TempDir()
+	corrupted := manifestChunk(t, tempDir, 1, "users_000001.ndjson", ndjsonContent, 2)
+	writeFile(t, tempDir, "users_000001.ndjson", strings.Replace(ndjsonContent, "minima", "maxima", 1))
+	miscounted := manifestChunk(t, tempDir, 2, "users_000002.ndjson", ndjsonContent, 3)
+	truncated := manifestChunk(t, tempDir, 3, "users_000003.ndjson", ndjsonContent, 2)
+	truncated.Bytes++
+	path := writeManifest(t, tempDir, Manifest{Format: "ndjson", Chunks: []ManifestChunk{corrupted, miscounted, truncated}}, true)
+
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range manifest.Chunks {
+		if _, err := manifest.ReadChunk(chunk); err == nil {
+			t.Errorf("Chunk %d: expected a verification error", chunk.ChunkId)
+		}
+	}
+}
+
+func TestReadManifestIncomplete(t *testing.T) {
+	cases := map[string]struct {
+		manifest Manifest
+		success  bool
+	}{
+		"missing marker": {Manifest{Format: "json"}, false},
+		"failed chunk":   {Manifest{Format: "json", FailedChunks: 1}, true},
+		"run error":      {Manifest{Format: "json", Error: "cursor error"}, true},
+		"parquet":        {Manifest{Format: "parquet"}, true},
+		"archive":        {Manifest{Format: "archive"}, true},
+	}
+	for name, c := range cases {
+		tempDir := t.TempDir()
+		path := writeManifest(t, tempDir, c.manifest, c.success)
+		if _, err := ReadManifest(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := ReadManifest(filepath.Join(t.TempDir(), ManifestFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected a not exist error, got %v", err)
+	}
+}